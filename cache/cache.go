@@ -26,11 +26,30 @@ type envelope struct {
 	time    time.Time
 }
 
+// a view of a single cached response
+type Entry struct {
+	Partition string   `json:"partition"`
+	Domain    string   `json:"domain"`
+	Class     string   `json:"class"`
+	Type      string   `json:"type"`
+	TTL       uint32   `json:"ttl"`
+	Records   []string `json:"records"`
+}
+
 type Cache interface {
 	Store(partition string, request *dns.Msg, response *dns.Msg) bool
 	Query(partition string, request *dns.Msg) (*dns.Msg, bool)
 	Size() uint32
 	Clear()
+
+	// inspection and targeted removal, an empty partition means all partitions
+	Entries(partition string, domain string) []*Entry
+	FlushDomain(partition string, domain string) int
+	FlushPartition(partition string) int
+
+	// persist and restore entries, versions map partition names to a version that must match on load
+	Save(filePath string, versions map[string]string) (int, error)
+	Load(filePath string, versions map[string]string) (int, error)
 }
 
 // keeps a pointer to the backer as well as a map of
@@ -92,6 +111,24 @@ func (gocache *gocache) key(questions []dns.Question) string {
 	return ""
 }
 
+// get the backer for the given partition, creating it if it does not exist
+func (gocache *gocache) backer(partition string) *backer.Cache {
+	gocache.partitionMux.RLock()
+	partitionBacker, found := gocache.backers[partition]
+	gocache.partitionMux.RUnlock()
+	if found {
+		return partitionBacker
+	}
+
+	gocache.partitionMux.Lock()
+	defer gocache.partitionMux.Unlock()
+	if partitionBacker, found = gocache.backers[partition]; !found {
+		partitionBacker = backer.New(backer.NoExpiration, defaultCacheScrapeMinutes*time.Minute)
+		gocache.backers[partition] = partitionBacker
+	}
+	return partitionBacker
+}
+
 func (gocache *gocache) Store(partition string, request *dns.Msg, response *dns.Msg) bool {
 	// you shouldn't cache an empty response (or a truncated response)
 	if util.IsEmptyResponse(response) || response.MsgHdr.Truncated {
@@ -115,17 +152,8 @@ func (gocache *gocache) Store(partition string, request *dns.Msg, response *dns.
 			return false
 		}
 
-		// ensure partition is created
-		if _, found := gocache.backers[partition]; !found {
-			gocache.partitionMux.Lock()
-			if _, found := gocache.backers[partition]; !found {
-				gocache.backers[partition] = backer.New(backer.NoExpiration, defaultCacheScrapeMinutes*time.Minute)
-			}
-			gocache.partitionMux.Unlock()
-		}
-
 		// put in backing store key -> envelope
-		gocache.backers[partition].Set(key, &envelope{
+		gocache.backer(partition).Set(key, &envelope{
 			message: response,
 			time:    time.Now(),
 		}, time.Duration(ttl)*time.Second)
@@ -206,3 +234,115 @@ func (gocache *gocache) Clear() {
 	}
 	gocache.partitionMux.Unlock()
 }
+
+// the partitions that are selected by the given partition name, all partitions if the name is empty
+func (gocache *gocache) selectBackers(partition string) map[string]*backer.Cache {
+	gocache.partitionMux.RLock()
+	defer gocache.partitionMux.RUnlock()
+
+	selected := make(map[string]*backer.Cache)
+	for name, partitionBacker := range gocache.backers {
+		if "" == partition || name == partition {
+			selected[name] = partitionBacker
+		}
+	}
+	return selected
+}
+
+// true if any question in the message is for the domain or a subdomain of the domain
+func questionInDomain(message *dns.Msg, domain string) bool {
+	if "" == domain || "." == domain {
+		return true
+	}
+	for _, question := range message.Question {
+		name := strings.ToLower(dns.Fqdn(question.Name))
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// list the entries in the partition (or all partitions) for the given domain (and subdomains) with the ttl counted down
+func (gocache *gocache) Entries(partition string, domain string) []*Entry {
+	domain = strings.ToLower(dns.Fqdn(strings.TrimSpace(domain)))
+	now := time.Now()
+
+	entries := make([]*Entry, 0)
+	for name, partitionBacker := range gocache.selectBackers(partition) {
+		for _, item := range partitionBacker.Items() {
+			env, ok := item.Object.(*envelope)
+			if !ok || env == nil || env.message == nil || len(env.message.Question) < 1 || !questionInDomain(env.message, domain) {
+				continue
+			}
+
+			entry := &Entry{
+				Partition: name,
+				Domain:    env.message.Question[0].Name,
+				Class:     dns.Class(env.message.Question[0].Qclass).String(),
+				Type:      dns.Type(env.message.Question[0].Qtype).String(),
+				Records:   make([]string, 0, len(env.message.Answer)+len(env.message.Ns)),
+			}
+			if item.Expiration > 0 {
+				remaining := time.Unix(0, item.Expiration).Sub(now)
+				if remaining <= 0 {
+					continue
+				}
+				entry.TTL = uint32(remaining / time.Second)
+			}
+
+			// use a copy to show the records with the remaining ttl
+			messageCopy := env.message.Copy()
+			secondDelta := uint32(now.Sub(env.time) / time.Second)
+			adjustTtls(secondDelta, messageCopy.Answer)
+			adjustTtls(secondDelta, messageCopy.Ns)
+			for _, rr := range append(messageCopy.Answer, messageCopy.Ns...) {
+				entry.Records = append(entry.Records, rr.String())
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// remove every entry for the domain and its subdomains from the partition (or all partitions)
+func (gocache *gocache) FlushDomain(partition string, domain string) int {
+	domain = strings.ToLower(dns.Fqdn(strings.TrimSpace(domain)))
+	if "." == domain {
+		return 0
+	}
+
+	flushed := 0
+	for _, partitionBacker := range gocache.selectBackers(partition) {
+		for key, item := range partitionBacker.Items() {
+			env, ok := item.Object.(*envelope)
+			if !ok || env == nil || env.message == nil || !questionInDomain(env.message, domain) {
+				continue
+			}
+			partitionBacker.Delete(key)
+			flushed++
+		}
+	}
+
+	return flushed
+}
+
+// remove every entry from the given partition
+func (gocache *gocache) FlushPartition(partition string) int {
+	if "" == partition {
+		return 0
+	}
+
+	gocache.partitionMux.RLock()
+	partitionBacker, found := gocache.backers[partition]
+	gocache.partitionMux.RUnlock()
+	if !found {
+		return 0
+	}
+
+	flushed := partitionBacker.ItemCount()
+	partitionBacker.Flush()
+	return flushed
+}
@@ -2,9 +2,13 @@ package cache
 
 import (
 	"net"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/testutil"
 )
 
 func TestSimpleCache(t *testing.T) {
@@ -32,3 +36,104 @@ func TestSimpleCache(t *testing.T) {
 		t.Errorf("Could not find expected question answer")
 	}
 }
+
+func TestCacheSaveLoad(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+	snapshotPath := path.Join(tmpDir, "cache", "snapshot.json")
+
+	// create new cache and fill two partitions
+	cache := New()
+
+	request := new(dns.Msg)
+	request.Question = append(request.Question, dns.Question{Name: "google.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	response := request.Copy()
+	response.Answer = append(response.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "google.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP("192.168.0.1"),
+	})
+	cache.Store("default", request, response)
+	cache.Store("changed", request, response)
+
+	saved, err := cache.Save(snapshotPath, map[string]string{"default": "1", "changed": "1"})
+	if err != nil {
+		t.Errorf("Could not save cache: %s", err)
+		return
+	}
+	if saved != 2 {
+		t.Errorf("Expected to save 2 entries but saved %d", saved)
+	}
+
+	// load into a new cache where one partition has changed version
+	loaded := New()
+	restored, err := loaded.Load(snapshotPath, map[string]string{"default": "1", "changed": "2"})
+	if err != nil {
+		t.Errorf("Could not load cache: %s", err)
+		return
+	}
+	if restored != 1 {
+		t.Errorf("Expected to restore 1 entry but restored %d", restored)
+	}
+
+	cached, found := loaded.Query("default", request)
+	if !found {
+		t.Errorf("Could not find expected question answer in restored partition")
+	} else if ttl := cached.Answer[0].Header().Ttl; ttl > 300 || ttl < 290 {
+		t.Errorf("Expected restored ttl to count down from 300 but got %d", ttl)
+	}
+
+	if _, found := loaded.Query("changed", request); found {
+		t.Errorf("Partition with a changed version should not be restored")
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	cache := New()
+
+	// store an answer for each domain in each partition
+	domains := []string{"example.com.", "www.example.com.", "notexample.com.", "google.com."}
+	for _, domain := range domains {
+		request := new(dns.Msg)
+		request.Question = append(request.Question, dns.Question{Name: domain, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+		response := request.Copy()
+		response.Answer = append(response.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.ParseIP("192.168.0.1"),
+		})
+		cache.Store("one", request, response)
+		cache.Store("two", request, response)
+	}
+
+	if entries := cache.Entries("", ""); len(entries) != 8 {
+		t.Errorf("Expected 8 entries but found %d", len(entries))
+	}
+	if entries := cache.Entries("one", "example.com"); len(entries) != 2 {
+		t.Errorf("Expected 2 entries for example.com in partition one but found %d", len(entries))
+	} else if entries[0].TTL < 1 || entries[0].TTL > 300 || len(entries[0].Records) != 1 {
+		t.Errorf("Unexpected entry contents: %v", entries[0])
+	}
+
+	// flush the domain and subdomains from one partition, similarly named domains must remain
+	if flushed := cache.FlushDomain("one", "example.com"); flushed != 2 {
+		t.Errorf("Expected to flush 2 entries but flushed %d", flushed)
+	}
+	if entries := cache.Entries("one", ""); len(entries) != 2 {
+		t.Errorf("Expected 2 entries to remain in partition one but found %d", len(entries))
+	}
+	if entries := cache.Entries("two", "example.com"); len(entries) != 2 {
+		t.Errorf("Expected partition two to be untouched but found %d entries", len(entries))
+	}
+
+	// flush from all partitions
+	if flushed := cache.FlushDomain("", "google.com."); flushed != 2 {
+		t.Errorf("Expected to flush 2 entries but flushed %d", flushed)
+	}
+
+	// flush a whole partition
+	if flushed := cache.FlushPartition("two"); flushed != 3 {
+		t.Errorf("Expected to flush 3 entries but flushed %d", flushed)
+	}
+	if size := cache.Size(); size != 1 {
+		t.Errorf("Expected 1 entry to remain but found %d", size)
+	}
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// on-disk representation of the cache, partitions are saved with a version
+// so that a partition is only restored when the thing that filled it has not changed
+type snapshot struct {
+	Partitions map[string]*snapshotPartition `json:"partitions"`
+}
+
+type snapshotPartition struct {
+	Version string           `json:"version"`
+	Entries []*snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Key     string `json:"key"`
+	Stored  int64  `json:"stored"`
+	Expires int64  `json:"expires"`
+	Message []byte `json:"message"`
+}
+
+// write all unexpired entries to the file at the given path, only partitions that have a version
+// in the versions map are saved and the version is saved alongside the partition
+func (gocache *gocache) Save(filePath string, versions map[string]string) (int, error) {
+	saved := 0
+	now := time.Now().UnixNano()
+
+	output := &snapshot{
+		Partitions: make(map[string]*snapshotPartition),
+	}
+
+	gocache.partitionMux.RLock()
+	for partition, backer := range gocache.backers {
+		version, found := versions[partition]
+		if !found {
+			continue
+		}
+
+		items := backer.Items()
+		snapPartition := &snapshotPartition{
+			Version: version,
+			Entries: make([]*snapshotEntry, 0, len(items)),
+		}
+
+		for key, item := range items {
+			// skip expired items that have not been scraped yet
+			if item.Expiration > 0 && item.Expiration <= now {
+				continue
+			}
+			env, ok := item.Object.(*envelope)
+			if !ok || env == nil || env.message == nil {
+				continue
+			}
+			packed, err := env.message.Pack()
+			if err != nil {
+				continue
+			}
+			snapPartition.Entries = append(snapPartition.Entries, &snapshotEntry{
+				Key:     key,
+				Stored:  env.time.UnixNano(),
+				Expires: item.Expiration,
+				Message: packed,
+			})
+		}
+
+		saved += len(snapPartition.Entries)
+		output.Partitions[partition] = snapPartition
+	}
+	gocache.partitionMux.RUnlock()
+
+	bytes, err := json.Marshal(output)
+	if err != nil {
+		return 0, fmt.Errorf("Marshaling cache snapshot: %s", err)
+	}
+
+	// make sure the target directory exists
+	if _, err := os.Stat(path.Dir(filePath)); os.IsNotExist(err) {
+		err = os.MkdirAll(path.Dir(filePath), os.ModePerm)
+		if err != nil {
+			return 0, fmt.Errorf("Creating cache snapshot directory: %s", err)
+		}
+	}
+
+	// write to a temporary file and move it over the original so that a failed write doesn't destroy the last snapshot
+	tmpPath := filePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, bytes, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("Writing cache snapshot: %s", err)
+	}
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		return 0, fmt.Errorf("Moving cache snapshot into place: %s", err)
+	}
+
+	return saved, nil
+}
+
+// restore unexpired entries from the file at the given path, partitions are only restored
+// when the saved version matches the version given for that partition in the versions map
+func (gocache *gocache) Load(filePath string, versions map[string]string) (int, error) {
+	bytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("Reading cache snapshot: %s", err)
+	}
+
+	input := &snapshot{}
+	err = json.Unmarshal(bytes, input)
+	if err != nil {
+		return 0, fmt.Errorf("Unmarshaling cache snapshot: %s", err)
+	}
+
+	restored := 0
+	now := time.Now()
+
+	for partition, snapPartition := range input.Partitions {
+		if snapPartition == nil {
+			continue
+		}
+		if version, found := versions[partition]; !found || version != snapPartition.Version {
+			continue
+		}
+
+		for _, entry := range snapPartition.Entries {
+			if entry == nil || "" == entry.Key {
+				continue
+			}
+
+			// only restore entries that have time left
+			remaining := time.Duration(entry.Expires - now.UnixNano())
+			if remaining <= 0 {
+				continue
+			}
+
+			message := new(dns.Msg)
+			if err := message.Unpack(entry.Message); err != nil {
+				continue
+			}
+
+			// keeping the original stored time means that ttls are counted down correctly when queried
+			gocache.backer(partition).Set(entry.Key, &envelope{
+				message: message,
+				time:    time.Unix(0, entry.Stored),
+			}, remaining)
+			restored++
+		}
+	}
+
+	return restored, nil
+}
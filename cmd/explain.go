@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/engine"
+	"github.com/chrisruffalo/gudgeon/rule"
+)
+
+// load the rules from the configuration and print every rule that matches the domain for the consumer or groups
+func explain(out io.Writer, conf *config.GudgeonConfig, command config.ExplainCommand) {
+	// only the rules are loaded, and only into memory, so nothing that a running instance is using is changed
+	explainer := engine.NewExplainer(conf)
+	defer explainer.Close()
+
+	var explanation *rule.Explanation
+	if len(command.Groups) > 0 {
+		explanation = explainer.ExplainWithGroups(command.Groups, command.Args.Domain)
+	} else {
+		explanation = explainer.ExplainWithConsumerName(command.Consumer, command.Args.Domain)
+	}
+
+	printExplanation(out, explanation)
+}
+
+func printExplanation(out io.Writer, explanation *rule.Explanation) {
+	fmt.Fprintf(out, "Domain: %s\n", explanation.Domain)
+
+	fmt.Fprintf(out, "Matches:\n")
+	if len(explanation.Matches) < 1 {
+		fmt.Fprintf(out, "  (none)\n")
+	}
+	for _, match := range explanation.Matches {
+		fmt.Fprintf(out, "  %-5s  %-6s  %s  [%s]\n", match.ListType, match.Kind, match.Rule, match.List)
+	}
+
+	fmt.Fprintf(out, "Steps:\n")
+	for idx, step := range explanation.Steps {
+		fmt.Fprintf(out, "  %d. %s\n", idx+1, step)
+	}
+
+	result := "none"
+	switch explanation.Match {
+	case rule.MatchAllow:
+		result = "allow"
+	case rule.MatchBlock:
+		result = "block"
+	}
+	fmt.Fprintf(out, "Result: %s\n", strings.ToUpper(result))
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
@@ -30,6 +31,14 @@ type Gudgeon struct {
 	engine   engine.Engine
 	provider provider.Provider
 	web      web.Web
+
+	// guards the configuration and the web ui, which change when the configuration is reloaded
+	mux          sync.Mutex
+	configHandle *events.Handle
+	// updates run one at a time and an update older than the last one applied is skipped
+	updates uint64
+	applied uint64
+	stopped bool
 }
 
 // NewGudgeon Create a new Gudgeon instance from a given Gudgeon Config
@@ -75,6 +84,18 @@ func (gudgeon *Gudgeon) Start() error {
 		}
 	}
 
+	// follow configuration changes from the reloading engine
+	gudgeon.configHandle = events.Listen("config:changed", func(message *events.Message) {
+		if message == nil {
+			return
+		}
+		if conf, ok := (*message)["config"].(*config.GudgeonConfig); ok && conf != nil {
+			// shutting down servers can take a while and the bus calls every listener from one goroutine
+			sequence := atomic.AddUint64(&gudgeon.updates, 1)
+			go gudgeon.updateConfig(sequence, conf)
+		}
+	})
+
 	// try and print out error if we caught one during startup
 	if recovery := recover(); recovery != nil {
 		return fmt.Errorf("unrecoverable error: %s", recovery)
@@ -83,9 +104,47 @@ func (gudgeon *Gudgeon) Start() error {
 	return nil
 }
 
+// move the dns listeners and the web ui to match the new configuration
+func (gudgeon *Gudgeon) updateConfig(sequence uint64, conf *config.GudgeonConfig) {
+	gudgeon.mux.Lock()
+	defer gudgeon.mux.Unlock()
+
+	if gudgeon.stopped || sequence < gudgeon.applied {
+		return
+	}
+	gudgeon.applied = sequence
+
+	gudgeon.config = conf
+
+	if gudgeon.provider != nil {
+		if err := gudgeon.provider.UpdateConfig(conf); err != nil {
+			log.Errorf("Could not update DNS endpoints: %s", err)
+		}
+	}
+
+	if gudgeon.web != nil {
+		if err := gudgeon.web.UpdateConfig(conf); err != nil {
+			log.Errorf("Could not update web ui: %s", err)
+		}
+	} else if conf.Web.Enabled {
+		gudgeon.web = web.New()
+		if err := gudgeon.web.Serve(conf, gudgeon.engine); err != nil {
+			log.Errorf("Could not host web: %s", err)
+		}
+	}
+}
+
 func (gudgeon *Gudgeon) Shutdown() {
 	wg := sync.WaitGroup{}
 
+	// stop following configuration changes, waiting for an update that has already started
+	gudgeon.mux.Lock()
+	if gudgeon.configHandle != nil {
+		gudgeon.configHandle.Close()
+	}
+	gudgeon.stopped = true
+	gudgeon.mux.Unlock()
+
 	wg.Add(1)
 	go func() {
 		// stop the file watcher
@@ -194,6 +253,15 @@ func main() {
 		log.Infof("Configuration file: %s", filename)
 	}
 
+	// explain the rules for a domain and exit instead of starting
+	if "explain" == opts.Command {
+		if !opts.DebugOptions.Debug {
+			log.SetLevel(log.WarnLevel)
+		}
+		explain(os.Stdout, conf, opts.Explain)
+		os.Exit(0)
+	}
+
 	// create new Gudgeon instance
 	instance := NewGudgeon(&filename, conf)
 
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -20,13 +23,34 @@ const (
 	ALLOW = ListType(1)
 	// the constant that means BLOCK after pasring "allow" or "block"
 	BLOCK = ListType(0)
+	// the constant that means the list is a response policy zone
+	RPZ = ListType(2)
 
 	// the string that represents "allow", all other results are treated as "block"
 	ALLOWSTRING = ListString("allow")
 	BLOCKSTRING = ListString("block")
+	RPZSTRING   = ListString("rpz")
+
+	// list formats, "auto" detects adblock-style lists and otherwise treats the list as hosts-style
+	FormatAuto    = "auto"
+	FormatHosts   = "hosts"
+	FormatAdblock = "adblock"
+
+	// list kinds, "domain" lists are matched against the question and "ip" lists are matched against answer addresses
+	KindDomain = "domain"
+	KindIP     = "ip"
+
+	// responses to requests from networks that are not allowed
+	DenyRefused = "refused"
+	DenyDrop    = "drop"
 
 	defaultString = "default"
 	systemString  = "system"
+
+	// limits on the edns0 udp size
+	defaultUDPSize = 1232
+	minUDPSize     = 512
+	maxUDPSize     = 4096
 )
 
 var remoteProtocols = []string{"http:", "https:"}
@@ -66,6 +90,8 @@ type GudgeonQueryLog struct {
 	MdnsLookup *bool `yaml:"mdns"`
 	// add netbios capability to lookup
 	NetbiosLookup *bool `yaml:"netbios"`
+	// find the hardware address of clients in the neighbour (arp/ndp) table
+	MacLookup *bool `yaml:"mac"`
 }
 
 type GudgeonMetrics struct {
@@ -88,6 +114,10 @@ type GudgeonStorage struct {
 	RuleStorage string `yaml:"rules"`
 	// you can enable/disable the cache here, default is to enable
 	CacheEnabled *bool `yaml:"cache"`
+	// persist the cache to {home}/data on shutdown and restore unexpired entries on start, default is to enable
+	CachePersist *bool `yaml:"cache_persist"`
+	// how often the cache is snapshot to disk while running
+	CacheInterval string `yaml:"cache_interval"`
 }
 
 // network interface information
@@ -102,6 +132,17 @@ type GudgeonInterface struct {
 	UDP *bool `yaml:"udp"`
 	// TLS settings
 	TLS *GudgeonTLS `yaml:"tls"`
+	// networks that can (or can't) use the interface, the allow list defaults to the network list and the deny list of
+	// the network is always added to the deny list of the interface
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+	// how requests from networks that can't use the interface are answered, defaults to the network value
+	DenyResponse string `yaml:"denyResponse"`
+	// the consumer for clients of the interface that no consumer matches, or the groups for such a consumer
+	Consumer string   `yaml:"consumer"`
+	Groups   []string `yaml:"groups"`
+	// use the consumer of the interface for every client of the interface, even clients that a consumer matches
+	Override bool `yaml:"override"`
 }
 
 // network: general dns network configuration
@@ -112,8 +153,43 @@ type GudgeonNetwork struct {
 	TCP *bool `yaml:"tcp"`
 	// udp: true when the default for all interfaces is to use udp
 	UDP *bool `yaml:"udp"`
+	// the edns0 buffer size that is advertised to clients and the largest udp response that is sent
+	UDPSize int `yaml:"udpSize"`
 	// endpoints: list of string endpoints that should have dns
 	Interfaces []*GudgeonInterface `yaml:"interfaces"`
+	// addresses or networks that can use gudgeon, when the allow list is empty everything not denied is allowed
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+	// how requests from networks that are not allowed are answered: "refused" or "drop"
+	DenyResponse string `yaml:"denyResponse"`
+	// addresses or networks of forwarders (like a router running dnsmasq) that are trusted to identify
+	// the clients they forward requests for
+	TrustedForwarders []string `yaml:"trustedForwarders"`
+	// the query rate limit for each client, consumers can have their own limit
+	RateLimit *GudgeonRateLimit `yaml:"rateLimit"`
+	// response rate limiting for udp to keep gudgeon from being used for reflection/amplification
+	RRL *GudgeonRRL `yaml:"rrl"`
+}
+
+// response rate limiting (like bind) counts identical responses to a network of clients, responses over the limit
+// are dropped except for every "slip" response which is sent truncated so that real clients can retry over tcp
+type GudgeonRRL struct {
+	Enabled *bool `yaml:"enabled"`
+	// identical responses per second for a network of clients
+	ResponsesPerSecond int `yaml:"responsesPerSecond"`
+	// nxdomain responses per second, defaults to the responses per second
+	NxdomainsPerSecond int `yaml:"nxdomainsPerSecond"`
+	// error responses (servfail, refused, ...) per second, defaults to the responses per second
+	ErrorsPerSecond int `yaml:"errorsPerSecond"`
+	// seconds of responses over the limit that a network has to make up for before it is no longer limited
+	Window int `yaml:"window"`
+	// every slip-th limited response is sent truncated instead of dropped, 0 drops every limited response
+	Slip *int `yaml:"slip"`
+	// the networks of clients that share a limit
+	IPv4PrefixLength int `yaml:"ipv4PrefixLength"`
+	IPv6PrefixLength int `yaml:"ipv6PrefixLength"`
+	// only log and count the responses that would be limited
+	LogOnly bool `yaml:"logOnly"`
 }
 
 // provides more configuration options and details for sources beyond the simple source specification
@@ -142,6 +218,10 @@ type GudgeonResolver struct {
 	Hosts []string `yaml:"hosts"`
 	// sources (described via string)
 	Sources []string `yaml:"sources"`
+	// remove private, loopback, link-local, and unique local addresses from answers given by upstream dns sources
+	RebindProtection bool `yaml:"rebindProtection"`
+	// domains (or globs) that are allowed to resolve to private addresses when rebind protection is enabled
+	RebindAllow []string `yaml:"rebindAllow"`
 }
 
 // GudgeonList different types of lists for domains that gudgeon will evaluate (and if they explicitly allow or block the matched entries)
@@ -149,7 +229,7 @@ type GudgeonList struct {
 	// the name of the list
 	Name      string `yaml:"name"`
 	shortName string `yaml:"-"`
-	// the type of the list, requires "allow" or "block", defaults to "block"
+	// the type of the list, requires "allow", "block", or "rpz" (response policy zone file), defaults to "block"
 	Type       string   `yaml:"type"`
 	parsedType ListType `yaml:"-"`
 	// should items in the list be interpreted as **regex only**
@@ -158,6 +238,19 @@ type GudgeonList struct {
 	Tags *[]string `yaml:"tags"`
 	// the path to the list, remote paths will be downloaded if possible
 	Source string `yaml:"src"`
+	// the format of the list: "auto", "hosts", or "adblock", defaults to "auto"
+	Format string `yaml:"format"`
+	// the kind of entries in the list: "domain" or "ip" (addresses and cidrs), defaults to "domain"
+	Kind string `yaml:"kind"`
+
+	// companion lists hold rules parsed from this list that need to be handled differently
+	// than the list itself, like exceptions (allow rules) inside of an adblock-style block list
+	parent     *GudgeonList `yaml:"-"`
+	exceptions *GudgeonList `yaml:"-"`
+	important  *GudgeonList `yaml:"-"`
+
+	// managed lists are created for each group and their rules are edited at runtime instead of coming from a source
+	managed bool `yaml:"-"`
 }
 
 // simple function to get source as name if name is missing
@@ -204,6 +297,67 @@ func (list *GudgeonList) ParsedType() ListType {
 	return list.parsedType
 }
 
+// managed lists have no source, their rules are added and removed while running
+func (list *GudgeonList) IsManaged() bool {
+	return list != nil && list.managed
+}
+
+// ip lists hold addresses and networks that are checked against the answers in a response
+func (list *GudgeonList) IsIPList() bool {
+	return list != nil && KindIP == list.Kind
+}
+
+// create a companion list that takes its identity from this list
+func (list *GudgeonList) companion(suffix string, listType ListType) *GudgeonList {
+	companion := &GudgeonList{
+		Name:       list.CanonicalName() + " " + suffix,
+		parsedType: listType,
+		Regex:      list.Regex,
+		Tags:       list.Tags,
+		Format:     list.Format,
+		parent:     list,
+	}
+	companion.shortName = list.ShortName() + "_" + suffix
+	if ALLOW == listType {
+		companion.Type = string(ALLOWSTRING)
+	} else {
+		companion.Type = string(BLOCKSTRING)
+	}
+	return companion
+}
+
+// the list that holds the exceptions (allow rules) found in this list
+func (list *GudgeonList) Exceptions() *GudgeonList {
+	if list.exceptions == nil {
+		list.exceptions = list.companion("exceptions", ALLOW)
+	}
+	return list.exceptions
+}
+
+// the list that holds the rules marked as important in this list, a companion list has no important list of its own
+func (list *GudgeonList) Important() *GudgeonList {
+	if list.parent != nil {
+		return nil
+	}
+	if list.important == nil {
+		list.important = list.companion("important", list.parsedType)
+	}
+	return list.important
+}
+
+// the list that this list was created to serve, nil if this is not a companion list
+func (list *GudgeonList) Parent() *GudgeonList {
+	return list.parent
+}
+
+// all of the companion lists of a block list, allow lists have no companions
+func (list *GudgeonList) Companions() []*GudgeonList {
+	if list.parent != nil || BLOCK != list.parsedType || list.IsIPList() {
+		return []*GudgeonList{}
+	}
+	return []*GudgeonList{list.Exceptions(), list.Important(), list.Important().Exceptions()}
+}
+
 func (list *GudgeonList) SafeTags() []string {
 	if list.Tags == nil {
 		return []string{"default"}
@@ -246,6 +400,81 @@ type GudgeonMatch struct {
 	IP    string             `yaml:"ip"`
 	Range *GudgeonMatchRange `yaml:"range"`
 	Net   string             `yaml:"net"`
+	// a hardware address ("aa:bb:cc:dd:ee:ff") or the vendor prefix of one ("aa:bb:cc")
+	MAC string `yaml:"mac"`
+	// a device id given by a trusted forwarder
+	Device string `yaml:"device"`
+	// a glob ("kids-*.lan") for the name of the client found by reverse, mdns, or netbios lookup
+	Hostname string `yaml:"hostname"`
+	// a subnet that contains the client subnet (edns0 client subnet) given by a forwarder
+	ECS string `yaml:"ecs"`
+	// matches when the match does not
+	Not *GudgeonMatch `yaml:"not"`
+	// matches when all (or any) of the matches do
+	All []*GudgeonMatch `yaml:"all"`
+	Any []*GudgeonMatch `yaml:"any"`
+}
+
+// true if the match is only an ip, range, net, or mac match
+func (match *GudgeonMatch) IsSimple() bool {
+	return match != nil && "" == match.Hostname && "" == match.ECS && match.Not == nil && len(match.All) == 0 && len(match.Any) == 0
+}
+
+// the hardware address or vendor prefix of a mac match, nil when the match is not a mac match
+func (match *GudgeonMatch) ParsedMAC() (net.HardwareAddr, error) {
+	if match == nil || "" == match.MAC {
+		return nil, nil
+	}
+	cleaned := strings.NewReplacer(":", "", "-", "", ".", "").Replace(match.MAC)
+	parsed, err := hex.DecodeString(cleaned)
+	if err != nil || (len(parsed) != 6 && len(parsed) != 3) {
+		return nil, fmt.Errorf("invalid mac '%s'", match.MAC)
+	}
+	return net.HardwareAddr(parsed), nil
+}
+
+// schedule: changes the groups of the consumers that use it during a window of time on the given days
+type GudgeonSchedule struct {
+	// name of the schedule, used by consumers and shown in the query log when the schedule is active
+	Name string `yaml:"name"`
+	// days of the week that the window starts on ("mon", "tuesday", ...), defaults to every day
+	Days []string `yaml:"days"`
+	// start and end of the window as HH:MM, a window that ends before it starts ends on the next day
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// the time zone of the window (like "America/New_York"), defaults to the local time zone
+	TimeZone string `yaml:"timezone"`
+	// groups added to the consumer while the schedule is active
+	Groups []string `yaml:"groups"`
+	// when true the groups replace the groups of the consumer instead of being added to them
+	Replace bool `yaml:"replace"`
+
+	// parsed values
+	days     map[time.Weekday]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// a token bucket for the queries of a client, the bucket holds "burst" queries and refills at "rate" queries per second
+type GudgeonRateLimit struct {
+	Enabled *bool `yaml:"enabled"`
+	// queries per second
+	Rate float64 `yaml:"rate"`
+	// queries that can be made at once
+	Burst int `yaml:"burst"`
+	// "address" gives each client a bucket, "consumer" shares one bucket between all of the clients of a consumer
+	Per string `yaml:"per"`
+	// how queries over the limit are answered: "refused", "drop", or "truncate"
+	Response string `yaml:"response"`
+	// a client that stays over the limit for this long is banned, "0" never bans
+	BanAfter string `yaml:"banAfter"`
+	// how long a ban lasts
+	BanFor string `yaml:"banFor"`
+
+	// parsed values
+	banAfter time.Duration
+	banFor   time.Duration
 }
 
 type GudgeonConsumer struct {
@@ -253,6 +482,13 @@ type GudgeonConsumer struct {
 	Block   bool            `yaml:"block"`
 	Groups  []string        `yaml:"groups"`
 	Matches []*GudgeonMatch `yaml:"matches"`
+	// names of the schedules that change the groups of the consumer, the first active schedule is used
+	Schedules []string `yaml:"schedules"`
+	// replaces the network rate limit for the clients of the consumer, unset values are the same as the network rate limit
+	RateLimit *GudgeonRateLimit `yaml:"rateLimit"`
+
+	// the configured schedules that the names refer to
+	schedules []*GudgeonSchedule
 }
 
 type GudgeonWeb struct {
@@ -274,6 +510,7 @@ type GudgeonConfig struct {
 	Resolvers []*GudgeonResolver `yaml:"resolvers"`
 	Lists     []*GudgeonList     `yaml:"lists"`
 	Groups    []*GudgeonGroup    `yaml:"groups"`
+	Schedules []*GudgeonSchedule `yaml:"schedules"`
 	Consumers []*GudgeonConsumer `yaml:"consumers"`
 
 	// private values
@@ -281,7 +518,9 @@ type GudgeonConfig struct {
 	resolverMap map[string]*GudgeonResolver
 	listMap     map[string]*GudgeonList
 	groupMap    map[string]*GudgeonGroup
+	scheduleMap map[string]*GudgeonSchedule
 	consumerMap map[string]*GudgeonConsumer
+	customLists []*GudgeonList
 }
 
 func (config *GudgeonConfig) GetResolver(name string) *GudgeonResolver {
@@ -291,6 +530,16 @@ func (config *GudgeonConfig) GetResolver(name string) *GudgeonResolver {
 	return nil
 }
 
+// the managed (custom) list of the given type for the group, nil if the group doesn't exist
+func (config *GudgeonConfig) GetCustomList(groupName string, listType ListType) *GudgeonList {
+	return config.GetList(customListName(groupName, listType))
+}
+
+// all of the managed (custom) lists, these are not part of the configured lists
+func (config *GudgeonConfig) CustomLists() []*GudgeonList {
+	return config.customLists
+}
+
 func (config *GudgeonConfig) GetList(name string) *GudgeonList {
 	if value, found := config.listMap[name]; found {
 		return value
@@ -305,6 +554,13 @@ func (config *GudgeonConfig) GetGroup(name string) *GudgeonGroup {
 	return nil
 }
 
+func (config *GudgeonConfig) GetSchedule(name string) *GudgeonSchedule {
+	if value, found := config.scheduleMap[name]; found {
+		return value
+	}
+	return nil
+}
+
 func (config *GudgeonConfig) GetConsumer(name string) *GudgeonConsumer {
 	if value, found := config.consumerMap[name]; found {
 		return value
@@ -331,6 +587,11 @@ func (config *GudgeonConfig) CacheRoot() string {
 	return path.Join(config.Home, "cache")
 }
 
+// rule data that is kept between runs
+func (config *GudgeonConfig) RulesRoot() string {
+	return path.Join(config.Home, "rules")
+}
+
 func (config *GudgeonConfig) DataRoot() string {
 	return path.Join(config.Home, "data")
 }
@@ -30,6 +30,7 @@ func (config *GudgeonConfig) verifyAndInit() ([]string, []error) {
 	config.listMap = make(map[string]*GudgeonList, 0)
 	config.consumerMap = make(map[string]*GudgeonConsumer, 0)
 	config.groupMap = make(map[string]*GudgeonGroup, 0)
+	config.scheduleMap = make(map[string]*GudgeonSchedule, 0)
 
 	// set home dir
 	if "" == config.Home {
@@ -49,7 +50,9 @@ func (config *GudgeonConfig) verifyAndInit() ([]string, []error) {
 			CacheEnabled: boolPointer(true),
 		}
 	}
-	config.Storage.verifyAndInit()
+	warn, err := config.Storage.verifyAndInit()
+	errors = append(errors, err...)
+	warnings = append(warnings, warn...)
 
 	// systemd
 	if config.Systemd == nil {
@@ -68,7 +71,7 @@ func (config *GudgeonConfig) verifyAndInit() ([]string, []error) {
 			},
 		}
 	}
-	warn, err := config.Network.verifyAndInit()
+	warn, err = config.Network.verifyAndInit()
 	errors = append(errors, err...)
 	warnings = append(warnings, warn...)
 
@@ -108,6 +111,11 @@ func (config *GudgeonConfig) verifyAndInit() ([]string, []error) {
 	errors = append(errors, err...)
 	warnings = append(warnings, warn...)
 
+	// schedules, before the consumers that use them
+	warn, err = config.verifyAndInitSchedules()
+	errors = append(errors, err...)
+	warnings = append(warnings, warn...)
+
 	// consumers
 	warn, err = config.verifyAndInitConsumers()
 	errors = append(errors, err...)
@@ -128,15 +136,36 @@ func (config *GudgeonConfig) verifyAndInit() ([]string, []error) {
 	errors = append(errors, err...)
 	warnings = append(warnings, warn...)
 
+	// custom lists for each group
+	config.initCustomLists()
+
 	return warnings, errors
 }
 
 func (storage *GudgeonStorage) verifyAndInit() ([]string, []error) {
+	// collect warnings
+	warnings := make([]string, 0)
+
 	if storage.CacheEnabled == nil {
 		storage.CacheEnabled = boolPointer(true)
 	}
 
-	return []string{}, []error{}
+	if storage.CachePersist == nil {
+		storage.CachePersist = storage.CacheEnabled
+	}
+
+	if "" == storage.CacheInterval {
+		storage.CacheInterval = "5m"
+	}
+	if parsed, err := util.ParseDuration(storage.CacheInterval); err != nil {
+		warnings = append(warnings, fmt.Sprintf("Could not parse cache snapshot interval: %s, using default (5m)", err))
+		storage.CacheInterval = "5m"
+	} else if parsed < 10*time.Second {
+		warnings = append(warnings, fmt.Sprintf("A cache snapshot interval less than 10s is probably too short, using default value (5m)"))
+		storage.CacheInterval = "5m"
+	}
+
+	return warnings, []error{}
 }
 
 func (web *GudgeonWeb) verifyAndInit() ([]string, []error) {
@@ -161,6 +190,24 @@ func (network *GudgeonNetwork) verifyAndInit() ([]string, []error) {
 		network.UDP = boolPointer(true)
 	}
 
+	// the default udp size avoids fragmentation on most networks (dns flag day 2020)
+	warnings := make([]string, 0)
+	if network.UDPSize == 0 {
+		network.UDPSize = defaultUDPSize
+	} else if network.UDPSize < minUDPSize || network.UDPSize > maxUDPSize {
+		warnings = append(warnings, fmt.Sprintf("The udp size must be between %d and %d but was %d, using default (%d)", minUDPSize, maxUDPSize, network.UDPSize, defaultUDPSize))
+		network.UDPSize = defaultUDPSize
+	}
+
+	// requests that are not allowed are refused by default
+	network.DenyResponse = strings.ToLower(strings.TrimSpace(network.DenyResponse))
+	if "" == network.DenyResponse {
+		network.DenyResponse = DenyRefused
+	} else if DenyRefused != network.DenyResponse && DenyDrop != network.DenyResponse {
+		warnings = append(warnings, fmt.Sprintf("Network deny response must be '%s' or '%s' but was '%s', using '%s'", DenyRefused, DenyDrop, network.DenyResponse, DenyRefused))
+		network.DenyResponse = DenyRefused
+	}
+
 	// do the same for all configured interfaces
 	for _, iface := range network.Interfaces {
 		if iface.TCP == nil {
@@ -169,9 +216,34 @@ func (network *GudgeonNetwork) verifyAndInit() ([]string, []error) {
 		if iface.UDP == nil {
 			iface.UDP = network.UDP
 		}
+		// an interface can only narrow down what the network allows, networks denied for the network are denied on
+		// every interface and an interface without its own allow list uses the allow list of the network
+		if iface.Allow == nil {
+			iface.Allow = network.Allow
+		}
+		iface.Deny = mergeLists(network.Deny, iface.Deny)
+		iface.DenyResponse = strings.ToLower(strings.TrimSpace(iface.DenyResponse))
+		if DenyRefused != iface.DenyResponse && DenyDrop != iface.DenyResponse {
+			if "" != iface.DenyResponse {
+				warnings = append(warnings, fmt.Sprintf("Interface %s:%d deny response must be '%s' or '%s' but was '%s', using '%s'", iface.IP, iface.Port, DenyRefused, DenyDrop, iface.DenyResponse, network.DenyResponse))
+			}
+			iface.DenyResponse = network.DenyResponse
+		}
 	}
 
-	return []string{}, []error{}
+	// rate limiting is off unless it is configured
+	if network.RateLimit == nil {
+		network.RateLimit = &GudgeonRateLimit{Enabled: boolPointer(false)}
+	}
+	warnings = append(warnings, network.RateLimit.VerifyAndInit(nil)...)
+
+	// and so is response rate limiting
+	if network.RRL == nil {
+		network.RRL = &GudgeonRRL{Enabled: boolPointer(false)}
+	}
+	warnings = append(warnings, network.RRL.VerifyAndInit()...)
+
+	return warnings, []error{}
 }
 
 func (database *GudgeonDatabase) verifyAndInit() ([]string, []error) {
@@ -286,6 +358,10 @@ func (ql *GudgeonQueryLog) verifyAndInit() ([]string, []error) {
 		ql.NetbiosLookup = ql.Enabled
 	}
 
+	if ql.MacLookup == nil {
+		ql.MacLookup = ql.Enabled
+	}
+
 	if "" == ql.Duration {
 		ql.Duration = "7d"
 	}
@@ -337,11 +413,51 @@ func (config *GudgeonConfig) verifyAndInitGroups() ([]string, []error) {
 	return warnings, []error{}
 }
 
+// verify all schedules at once and set the schedule map, schedules that can't be parsed are not used
+func (config *GudgeonConfig) verifyAndInitSchedules() ([]string, []error) {
+	// collect warnings
+	warnings := make([]string, 0)
+
+	for _, schedule := range config.Schedules {
+		if schedule == nil {
+			continue
+		}
+		if "" == schedule.Name {
+			warnings = append(warnings, "A schedule with no name was found in the configuration, a schedule with no name will not be used.")
+			continue
+		}
+		if err := schedule.VerifyAndInit(); err != nil {
+			warnings = append(warnings, fmt.Sprintf("The schedule '%s' is invalid and will not be used: %s", schedule.Name, err))
+			continue
+		}
+		if _, found := config.scheduleMap[schedule.Name]; found {
+			warnings = append(warnings, fmt.Sprintf("More than one schedule was found with the name '%s', schedule names are case insensitive and must be unique.", schedule.Name))
+			continue
+		}
+		config.scheduleMap[schedule.Name] = schedule
+	}
+
+	return warnings, []error{}
+}
+
 // verify all consumers at once, add a default consumer if needed, and set the group map
 func (config *GudgeonConfig) verifyAndInitConsumers() ([]string, []error) {
 	// collect warnings
 	warnings := make([]string, 0)
 
+	// interfaces that only have groups get a consumer of their own
+	if config.Network != nil {
+		for _, iface := range config.Network.Interfaces {
+			if iface != nil && "" == iface.Consumer && len(iface.Groups) > 0 {
+				iface.Consumer = fmt.Sprintf("interface-%s-%d", iface.IP, iface.Port)
+				config.Consumers = append(config.Consumers, &GudgeonConsumer{
+					Name:   iface.Consumer,
+					Groups: iface.Groups,
+				})
+			}
+		}
+	}
+
 	for _, consumer := range config.Consumers {
 		if consumer == nil {
 			continue
@@ -352,6 +468,29 @@ func (config *GudgeonConfig) verifyAndInitConsumers() ([]string, []error) {
 		}
 		consumer.Name = strings.ToLower(consumer.Name)
 
+		// the schedules are used in the order the consumer names them
+		consumer.schedules = make([]*GudgeonSchedule, 0, len(consumer.Schedules))
+		for idx, name := range consumer.Schedules {
+			consumer.Schedules[idx] = strings.ToLower(name)
+			schedule := config.GetSchedule(consumer.Schedules[idx])
+			if schedule == nil {
+				warnings = append(warnings, fmt.Sprintf("Consumer '%s' uses the schedule '%s' which is not configured or is invalid, it will not be used", consumer.Name, name))
+				continue
+			}
+			consumer.schedules = append(consumer.schedules, schedule)
+		}
+
+		// the consumer rate limit fills in what it does not set from the network rate limit
+		if consumer.RateLimit != nil {
+			var parent *GudgeonRateLimit
+			if config.Network != nil {
+				parent = config.Network.RateLimit
+			}
+			for _, warning := range consumer.RateLimit.VerifyAndInit(parent) {
+				warnings = append(warnings, fmt.Sprintf("Consumer '%s': %s", consumer.Name, warning))
+			}
+		}
+
 		if _, found := config.consumerMap[consumer.Name]; found {
 			warnings = append(warnings, "More than one consumer was found with the name '%s', consumer names are case insensitive and must be unique.", consumer.Name)
 			continue
@@ -368,6 +507,20 @@ func (config *GudgeonConfig) verifyAndInitConsumers() ([]string, []error) {
 		config.consumerMap[defaultString] = defaultConsumer
 	}
 
+	// the consumer of an interface has to exist
+	if config.Network != nil {
+		for _, iface := range config.Network.Interfaces {
+			if iface == nil || "" == iface.Consumer {
+				continue
+			}
+			iface.Consumer = strings.ToLower(iface.Consumer)
+			if _, found := config.consumerMap[iface.Consumer]; !found {
+				warnings = append(warnings, fmt.Sprintf("Interface %s:%d has a consumer '%s' that does not exist and will not be used", iface.IP, iface.Port, iface.Consumer))
+				iface.Consumer = ""
+			}
+		}
+	}
+
 	return warnings, []error{}
 }
 
@@ -485,6 +638,43 @@ func (config *GudgeonConfig) verifyAndInitLists() ([]string, []error) {
 	return warnings, []error{}
 }
 
+// the name of the managed list of the given type for a group
+func customListName(groupName string, listType ListType) string {
+	if ALLOW == listType {
+		return "custom allow " + groupName
+	}
+	return "custom block " + groupName
+}
+
+// create a managed allow list and block list for each group, the rules in the lists are edited at runtime
+func (config *GudgeonConfig) initCustomLists() {
+	config.customLists = make([]*GudgeonList, 0, len(config.Groups)*2)
+	for _, group := range config.Groups {
+		if group == nil || "" == group.Name {
+			continue
+		}
+		for _, listType := range []ListType{ALLOW, BLOCK} {
+			name := customListName(group.Name, listType)
+			if _, found := config.listMap[name]; found {
+				continue
+			}
+			list := &GudgeonList{
+				Name:    name,
+				Type:    string(BLOCKSTRING),
+				Tags:    &[]string{},
+				Format:  FormatHosts,
+				managed: true,
+			}
+			if ALLOW == listType {
+				list.Type = string(ALLOWSTRING)
+			}
+			list.VerifyAndInit()
+			config.customLists = append(config.customLists, list)
+			config.listMap[list.CanonicalName()] = list
+		}
+	}
+}
+
 func (list *GudgeonList) VerifyAndInit() {
 	if list == nil {
 		return
@@ -502,10 +692,48 @@ func (list *GudgeonList) VerifyAndInit() {
 	// canonical and pre-paresed values for allow/block
 	if strings.EqualFold(string(ALLOWSTRING), list.Type) {
 		list.parsedType = ALLOW
+	} else if strings.EqualFold(string(RPZSTRING), list.Type) {
+		list.Type = string(RPZSTRING)
+		list.parsedType = RPZ
 	} else {
 		list.Type = string(BLOCKSTRING)
 		list.parsedType = BLOCK
 	}
 
 	list.Name = strings.ToLower(list.Name)
+
+	// unknown formats are detected
+	list.Format = strings.ToLower(strings.TrimSpace(list.Format))
+	if FormatHosts != list.Format && FormatAdblock != list.Format {
+		list.Format = FormatAuto
+	}
+
+	// unknown kinds are domain lists and policy zones are always domain lists
+	list.Kind = strings.ToLower(strings.TrimSpace(list.Kind))
+	if KindIP != list.Kind || RPZ == list.parsedType {
+		list.Kind = KindDomain
+	}
+
+	// create companions up front so that they are not created while matching
+	list.exceptions = nil
+	list.important = nil
+	list.Companions()
+}
+
+// the entries of both lists, in order and without duplicates
+func mergeLists(first []string, second []string) []string {
+	if len(second) < 1 {
+		return first
+	}
+	merged := make([]string, 0, len(first)+len(second))
+	seen := make(map[string]bool, len(first)+len(second))
+	for _, list := range [][]string{first, second} {
+		for _, entry := range list {
+			if !seen[entry] {
+				seen[entry] = true
+				merged = append(merged, entry)
+			}
+		}
+	}
+	return merged
 }
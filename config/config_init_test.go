@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -17,3 +18,70 @@ func TestInit(t *testing.T) {
 		t.Errorf("Expected GudgeonQueryLog block")
 	}
 }
+
+// interfaces without their own lists use the lists of the network
+func TestInitInterfaceACL(t *testing.T) {
+	config := &GudgeonConfig{
+		Network: &GudgeonNetwork{
+			Allow:        []string{"192.168.0.0/16"},
+			Deny:         []string{"192.168.99.0/24"},
+			DenyResponse: "DROP",
+			Interfaces: []*GudgeonInterface{
+				{IP: "127.0.0.1", Port: 53},
+				{IP: "0.0.0.0", Port: 53, Deny: []string{"203.0.113.0/24"}, DenyResponse: "refused"},
+				{IP: "10.0.0.1", Port: 53, Allow: []string{"10.0.0.0/8"}},
+			},
+		},
+	}
+	config.verifyAndInit()
+
+	first, second, third := config.Network.Interfaces[0], config.Network.Interfaces[1], config.Network.Interfaces[2]
+	if len(first.Allow) != 1 || len(first.Deny) != 1 || first.DenyResponse != DenyDrop {
+		t.Errorf("Expected the interface to use the lists of the network")
+	}
+	if len(second.Allow) != 1 || len(second.Deny) != 2 || second.Deny[0] != "192.168.99.0/24" || second.DenyResponse != DenyRefused {
+		t.Errorf("Expected the interface to add its deny list to the deny list of the network: %v", second.Deny)
+	}
+	if len(third.Allow) != 1 || third.Allow[0] != "10.0.0.0/8" || len(third.Deny) != 1 || third.Deny[0] != "192.168.99.0/24" {
+		t.Errorf("Expected the interface to keep its own allow list and the deny list of the network")
+	}
+
+	// initializing again doesn't add the network deny list twice
+	config.verifyAndInit()
+	if len(second.Deny) != 2 {
+		t.Errorf("Expected the deny list to not change when initialized again but got %v", second.Deny)
+	}
+}
+
+// consumers use the top level schedules by name
+func TestInitSchedules(t *testing.T) {
+	config := &GudgeonConfig{
+		Schedules: []*GudgeonSchedule{
+			{Name: "Bedtime", Start: "21:00", End: "07:00", Groups: []string{"strict"}},
+			{Name: "broken", Start: "25:00", End: "07:00"},
+			{Start: "08:00", End: "15:00"},
+		},
+		Consumers: []*GudgeonConsumer{
+			{Name: "tablets", Schedules: []string{"broken", "BEDTIME", "missing"}},
+		},
+	}
+	warnings, _ := config.verifyAndInit()
+
+	if config.GetSchedule("bedtime") == nil || config.GetSchedule("broken") != nil {
+		t.Errorf("Expected only the valid schedule to be configured")
+	}
+	consumer := config.GetConsumer("tablets")
+	if len(consumer.schedules) != 1 || consumer.schedules[0] != config.GetSchedule("bedtime") {
+		t.Errorf("Expected the consumer to use the bedtime schedule but got %v", consumer.schedules)
+	}
+	// the invalid schedule, the schedule without a name, and the two names that can't be used
+	found := 0
+	for _, warning := range warnings {
+		if strings.Contains(warning, "schedule") {
+			found++
+		}
+	}
+	if found != 4 {
+		t.Errorf("Expected 4 schedule warnings but got %d: %v", found, warnings)
+	}
+}
@@ -21,6 +21,14 @@ type DebugOptions struct {
 	Debug   bool `short:"d" long:"debug" description:"Force the console log to the debug level"`
 }
 
+type ExplainCommand struct {
+	Consumer string   `short:"n" long:"consumer" description:"Explain the rules for the lists of this consumer." default:"default"`
+	Groups   []string `short:"g" long:"group" description:"Explain the rules for the lists of this group instead of a consumer, can be given more than once."`
+	Args     struct {
+		Domain string `positional-arg-name:"domain" description:"The domain to explain."`
+	} `positional-args:"yes" required:"yes"`
+}
+
 type GudgeonOptions struct {
 	// explicit app group
 	AppOptions AppOptions `group:"Application Options"`
@@ -30,12 +38,22 @@ type GudgeonOptions struct {
 
 	// emulate help flag with direct support for accessing it
 	HelpOptions HelpOptions `group:"Help Options"`
+
+	// explain the rules that match a domain instead of starting the service
+	Explain ExplainCommand `command:"explain" description:"Show every rule that matches a domain and how the decision is made."`
+
+	// the name of the command that was given, empty when starting the service
+	Command string `no-flag:"true"`
 }
 
 func Options(longVersion string) (GudgeonOptions, error) {
 	var opts GudgeonOptions
 	parser := flags.NewParser(&opts, flags.PassDoubleDash)
+	parser.SubcommandsOptional = true
 	_, err := parser.ParseArgs(os.Args[1:])
+	if parser.Active != nil {
+		opts.Command = parser.Active.Name
+	}
 
 	// if version or help we start out the same way
 	if opts.HelpOptions.Help || opts.HelpOptions.Version {
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chrisruffalo/gudgeon/util"
+)
+
+const (
+	// rate limit buckets
+	RateLimitPerAddress  = "address"
+	RateLimitPerConsumer = "consumer"
+
+	// responses to queries over the limit
+	RateLimitRefused  = "refused"
+	RateLimitDrop     = "drop"
+	RateLimitTruncate = "truncate"
+
+	defaultRateLimitRate     = 50
+	defaultRateLimitBanAfter = "30s"
+	defaultRateLimitBanFor   = "10m"
+)
+
+// sets the defaults of the rate limit, unset values are taken from the parent (when not nil) and then from the defaults
+func (limit *GudgeonRateLimit) VerifyAndInit(parent *GudgeonRateLimit) []string {
+	warnings := make([]string, 0)
+	if parent == nil {
+		parent = &GudgeonRateLimit{}
+	}
+
+	// a rate limit that is configured is enabled
+	if limit.Enabled == nil {
+		limit.Enabled = boolPointer(true)
+	}
+
+	if limit.Rate == 0 {
+		limit.Rate = parent.Rate
+	}
+	if limit.Rate <= 0 {
+		limit.Rate = defaultRateLimitRate
+	}
+	if limit.Burst == 0 {
+		limit.Burst = parent.Burst
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = int(2 * limit.Rate)
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+	}
+
+	limit.Per = strings.ToLower(strings.TrimSpace(limit.Per))
+	if "" == limit.Per {
+		limit.Per = parent.Per
+	}
+	if "" == limit.Per {
+		limit.Per = RateLimitPerAddress
+	} else if RateLimitPerAddress != limit.Per && RateLimitPerConsumer != limit.Per {
+		warnings = append(warnings, fmt.Sprintf("Rate limit per must be '%s' or '%s' but was '%s', using '%s'", RateLimitPerAddress, RateLimitPerConsumer, limit.Per, RateLimitPerAddress))
+		limit.Per = RateLimitPerAddress
+	}
+
+	limit.Response = strings.ToLower(strings.TrimSpace(limit.Response))
+	if "" == limit.Response {
+		limit.Response = parent.Response
+	}
+	if "" == limit.Response {
+		limit.Response = RateLimitRefused
+	} else if RateLimitRefused != limit.Response && RateLimitDrop != limit.Response && RateLimitTruncate != limit.Response {
+		warnings = append(warnings, fmt.Sprintf("Rate limit response must be one of '%s', '%s', or '%s' but was '%s', using '%s'", RateLimitRefused, RateLimitDrop, RateLimitTruncate, limit.Response, RateLimitRefused))
+		limit.Response = RateLimitRefused
+	}
+
+	if "" == limit.BanAfter {
+		limit.BanAfter = parent.BanAfter
+	}
+	if "" == limit.BanAfter {
+		limit.BanAfter = defaultRateLimitBanAfter
+	}
+	if parsed, err := util.ParseDuration(limit.BanAfter); err != nil {
+		warnings = append(warnings, fmt.Sprintf("Could not parse rate limit ban after: %s, using default (%s)", err, defaultRateLimitBanAfter))
+		limit.BanAfter = defaultRateLimitBanAfter
+		limit.banAfter, _ = util.ParseDuration(limit.BanAfter)
+	} else {
+		limit.banAfter = parsed
+	}
+
+	if "" == limit.BanFor {
+		limit.BanFor = parent.BanFor
+	}
+	if "" == limit.BanFor {
+		limit.BanFor = defaultRateLimitBanFor
+	}
+	if parsed, err := util.ParseDuration(limit.BanFor); err != nil || parsed <= 0 {
+		warnings = append(warnings, fmt.Sprintf("Could not parse rate limit ban length '%s', using default (%s)", limit.BanFor, defaultRateLimitBanFor))
+		limit.BanFor = defaultRateLimitBanFor
+		limit.banFor, _ = util.ParseDuration(limit.BanFor)
+	} else {
+		limit.banFor = parsed
+	}
+
+	return warnings
+}
+
+// how long a client can stay over the limit before it is banned, zero when clients are never banned
+func (limit *GudgeonRateLimit) BanAfterDuration() time.Duration {
+	return limit.banAfter
+}
+
+// how long a ban lasts
+func (limit *GudgeonRateLimit) BanForDuration() time.Duration {
+	return limit.banFor
+}
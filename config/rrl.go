@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+)
+
+const (
+	defaultRRLResponsesPerSecond = 10
+	defaultRRLWindow             = 15
+	defaultRRLSlip               = 2
+	defaultRRLIPv4PrefixLength   = 24
+	defaultRRLIPv6PrefixLength   = 56
+)
+
+// sets the defaults for response rate limiting
+func (rrl *GudgeonRRL) VerifyAndInit() []string {
+	warnings := make([]string, 0)
+
+	// response rate limiting that is configured is enabled
+	if rrl.Enabled == nil {
+		rrl.Enabled = boolPointer(true)
+	}
+
+	if rrl.ResponsesPerSecond <= 0 {
+		rrl.ResponsesPerSecond = defaultRRLResponsesPerSecond
+	}
+	if rrl.NxdomainsPerSecond <= 0 {
+		rrl.NxdomainsPerSecond = rrl.ResponsesPerSecond
+	}
+	if rrl.ErrorsPerSecond <= 0 {
+		rrl.ErrorsPerSecond = rrl.ResponsesPerSecond
+	}
+	if rrl.Window <= 0 {
+		rrl.Window = defaultRRLWindow
+	} else if rrl.Window > 3600 {
+		warnings = append(warnings, "An rrl window of more than 3600 seconds is too long, using 3600")
+		rrl.Window = 3600
+	}
+
+	if rrl.Slip == nil {
+		slip := defaultRRLSlip
+		rrl.Slip = &slip
+	} else if *rrl.Slip < 0 || *rrl.Slip > 10 {
+		warnings = append(warnings, fmt.Sprintf("The rrl slip must be between 0 and 10 but was %d, using default (%d)", *rrl.Slip, defaultRRLSlip))
+		slip := defaultRRLSlip
+		rrl.Slip = &slip
+	}
+
+	if rrl.IPv4PrefixLength <= 0 || rrl.IPv4PrefixLength > 32 {
+		rrl.IPv4PrefixLength = defaultRRLIPv4PrefixLength
+	}
+	if rrl.IPv6PrefixLength <= 0 || rrl.IPv6PrefixLength > 128 {
+		rrl.IPv6PrefixLength = defaultRRLIPv6PrefixLength
+	}
+
+	return warnings
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// short and long names for the days of the week
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// parse HH:MM into minutes after midnight
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("time '%s' is not in the form HH:MM", clock)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("time '%s' has an invalid hour", clock)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("time '%s' has an invalid minute", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+// parse the days, window, and time zone of the schedule
+func (schedule *GudgeonSchedule) VerifyAndInit() error {
+	if schedule == nil {
+		return fmt.Errorf("schedule is empty")
+	}
+
+	schedule.Name = strings.ToLower(schedule.Name)
+
+	schedule.days = make(map[time.Weekday]bool)
+	for _, day := range schedule.Days {
+		weekday, found := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !found {
+			return fmt.Errorf("schedule '%s' has an unknown day '%s'", schedule.Name, day)
+		}
+		schedule.days[weekday] = true
+	}
+	// no days means every day
+	if len(schedule.days) < 1 {
+		for _, weekday := range weekdays {
+			schedule.days[weekday] = true
+		}
+	}
+
+	var err error
+	if schedule.start, err = parseClock(schedule.Start); err != nil {
+		return fmt.Errorf("schedule '%s' start %s", schedule.Name, err)
+	}
+	if schedule.end, err = parseClock(schedule.End); err != nil {
+		return fmt.Errorf("schedule '%s' end %s", schedule.Name, err)
+	}
+
+	schedule.location = time.Local
+	if "" != schedule.TimeZone {
+		if schedule.location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return fmt.Errorf("schedule '%s' has an unknown time zone '%s'", schedule.Name, schedule.TimeZone)
+		}
+	}
+
+	for idx := range schedule.Groups {
+		schedule.Groups[idx] = strings.ToLower(schedule.Groups[idx])
+	}
+
+	return nil
+}
+
+// is the schedule active at the given time, the window belongs to the day that it starts on
+// so a window from 21:00 to 07:00 on friday is active until 07:00 on saturday
+func (schedule *GudgeonSchedule) Active(now time.Time) bool {
+	if schedule == nil || schedule.days == nil {
+		return false
+	}
+
+	now = now.In(schedule.location)
+	minute := now.Hour()*60 + now.Minute()
+	today := now.Weekday()
+	yesterday := (today + 6) % 7
+
+	// a window that starts and ends at the same time lasts all day
+	if schedule.start == schedule.end {
+		return schedule.days[today]
+	}
+
+	// the window is within a single day
+	if schedule.start < schedule.end {
+		return schedule.days[today] && minute >= schedule.start && minute < schedule.end
+	}
+
+	// the window crosses midnight
+	return (schedule.days[today] && minute >= schedule.start && minute < minutesPerDay) || (schedule.days[yesterday] && minute < schedule.end)
+}
+
+// the first schedule of the consumer that is active at the given time
+func (consumer *GudgeonConsumer) ActiveSchedule(now time.Time) *GudgeonSchedule {
+	if consumer == nil {
+		return nil
+	}
+	for _, schedule := range consumer.schedules {
+		if schedule.Active(now) {
+			return schedule
+		}
+	}
+	return nil
+}
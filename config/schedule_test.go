@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	// bedtime on school nights, the window starts on sunday-thursday and ends the next morning
+	bedtime := &GudgeonSchedule{Name: "Bedtime", Days: []string{"sun", "mon", "tue", "wed", "thursday"}, Start: "21:00", End: "07:00", TimeZone: "America/New_York"}
+	if err := bedtime.VerifyAndInit(); err != nil {
+		t.Fatalf("Could not parse schedule: %s", err)
+	}
+	school := &GudgeonSchedule{Name: "school", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "15:00", TimeZone: "America/New_York"}
+	if err := school.VerifyAndInit(); err != nil {
+		t.Fatalf("Could not parse schedule: %s", err)
+	}
+	allDay := &GudgeonSchedule{Start: "00:00", End: "00:00"}
+	if err := allDay.VerifyAndInit(); err != nil {
+		t.Fatalf("Could not parse schedule: %s", err)
+	}
+
+	location, _ := time.LoadLocation("America/New_York")
+
+	data := []struct {
+		schedule *GudgeonSchedule
+		time     time.Time
+		active   bool
+	}{
+		// 2026-10-18 is a sunday
+		{bedtime, time.Date(2026, 10, 18, 20, 59, 0, 0, location), false},
+		{bedtime, time.Date(2026, 10, 18, 21, 0, 0, 0, location), true},
+		{bedtime, time.Date(2026, 10, 19, 6, 59, 0, 0, location), true},
+		{bedtime, time.Date(2026, 10, 19, 7, 0, 0, 0, location), false},
+		// friday night is not a school night but thursday night ends on friday morning
+		{bedtime, time.Date(2026, 10, 23, 6, 0, 0, 0, location), true},
+		{bedtime, time.Date(2026, 10, 23, 22, 0, 0, 0, location), false},
+		{bedtime, time.Date(2026, 10, 24, 6, 0, 0, 0, location), false},
+		// the same instant in another time zone
+		{bedtime, time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC), true},
+		{school, time.Date(2026, 10, 19, 8, 0, 0, 0, location), true},
+		{school, time.Date(2026, 10, 19, 15, 0, 0, 0, location), false},
+		{school, time.Date(2026, 10, 18, 10, 0, 0, 0, location), false},
+		{allDay, time.Now(), true},
+	}
+
+	for _, d := range data {
+		if active := d.schedule.Active(d.time); active != d.active {
+			t.Errorf("Expected schedule %s active=%t at %s but got %t", d.schedule.Name, d.active, d.time, active)
+		}
+	}
+
+	if bedtime.Name != "bedtime" {
+		t.Errorf("Expected schedule name to be lower case")
+	}
+
+	// invalid schedules
+	for _, invalid := range []*GudgeonSchedule{
+		{Start: "25:00", End: "07:00"},
+		{Start: "21:00", End: "7"},
+		{Start: "21:00", End: "07:00", Days: []string{"someday"}},
+		{Start: "21:00", End: "07:00", TimeZone: "Not/AZone"},
+	} {
+		if err := invalid.VerifyAndInit(); err == nil {
+			t.Errorf("Expected schedule %v to be invalid", invalid)
+		}
+	}
+}
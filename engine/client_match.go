@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+// what is known about a client when finding its consumer
+type clientInfo struct {
+	ip  net.IP
+	mac net.HardwareAddr
+	// the name of the client, only looked up when a hostname match needs it
+	name string
+	// the client subnet given by a forwarder and the length of its prefix
+	ecs     net.IP
+	ecsBits int
+	// the device id given by a trusted forwarder
+	device string
+}
+
+// the identity of the client that a trusted forwarder sent a request for
+type ForwardedClient struct {
+	// the address of the client, from a client subnet option that covers a single address
+	Address net.IP
+	// the hardware address of the client
+	MAC net.HardwareAddr
+	// the id of the device
+	DeviceID string
+	// the client subnet and the length of its prefix
+	Subnet     net.IP
+	SubnetBits int
+}
+
+// a match from the configuration that is checked against everything known about a client
+type clientMatch interface {
+	matches(client clientInfo) bool
+}
+
+// what a client needs to have looked up for the matches to be checked
+type clientNeeds struct {
+	mac  bool
+	name bool
+	ecs  bool
+}
+
+type addressMatch struct {
+	interval *consumerInterval
+}
+
+func (match *addressMatch) matches(client clientInfo) bool {
+	key, ok := keyForIP(client.ip)
+	return ok && match.interval.contains(key)
+}
+
+// a hardware address or the vendor prefix of one
+type macMatch struct {
+	mac net.HardwareAddr
+}
+
+func (match *macMatch) matches(client clientInfo) bool {
+	return len(client.mac) >= len(match.mac) && bytes.Equal(client.mac[:len(match.mac)], match.mac)
+}
+
+type deviceMatch struct {
+	device string
+}
+
+func (match *deviceMatch) matches(client clientInfo) bool {
+	return "" != client.device && match.device == client.device
+}
+
+type hostnameMatch struct {
+	glob string
+}
+
+func (match *hostnameMatch) matches(client clientInfo) bool {
+	if "" == client.name {
+		return false
+	}
+	matched, _ := path.Match(match.glob, strings.ToLower(strings.TrimSuffix(client.name, ".")))
+	return matched
+}
+
+// the client subnet is inside of the network
+type ecsMatch struct {
+	network *net.IPNet
+	bits    int
+}
+
+func (match *ecsMatch) matches(client clientInfo) bool {
+	return client.ecs != nil && client.ecsBits >= match.bits && match.network.Contains(client.ecs)
+}
+
+type notMatch struct {
+	match clientMatch
+}
+
+func (match *notMatch) matches(client clientInfo) bool {
+	return !match.match.matches(client)
+}
+
+type allMatch []clientMatch
+
+func (all allMatch) matches(client clientInfo) bool {
+	for _, match := range all {
+		if !match.matches(client) {
+			return false
+		}
+	}
+	return true
+}
+
+type anyMatch []clientMatch
+
+func (any anyMatch) matches(client clientInfo) bool {
+	for _, match := range any {
+		if match.matches(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiles a match from the configuration, noting what needs to be looked up about a client to check it, the
+// first kind of match that is set (in the order of the fields) is used
+func compileMatch(match *config.GudgeonMatch, needs *clientNeeds) (clientMatch, error) {
+	if match == nil {
+		return nil, fmt.Errorf("empty match")
+	}
+	if interval, err := intervalForMatch(match); err != nil {
+		return nil, err
+	} else if interval != nil {
+		return &addressMatch{interval: interval}, nil
+	}
+	if mac, err := match.ParsedMAC(); err != nil {
+		return nil, err
+	} else if mac != nil {
+		needs.mac = true
+		return &macMatch{mac: mac}, nil
+	}
+	if "" != match.Device {
+		return &deviceMatch{device: match.Device}, nil
+	}
+	if "" != match.Hostname {
+		glob := strings.ToLower(strings.TrimSuffix(match.Hostname, "."))
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid hostname '%s'", match.Hostname)
+		}
+		needs.name = true
+		return &hostnameMatch{glob: glob}, nil
+	}
+	if "" != match.ECS {
+		_, network, err := net.ParseCIDR(match.ECS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ecs '%s'", match.ECS)
+		}
+		needs.ecs = true
+		bits, _ := network.Mask.Size()
+		return &ecsMatch{network: network, bits: bits}, nil
+	}
+	if match.Not != nil {
+		inner, err := compileMatch(match.Not, needs)
+		if err != nil {
+			return nil, err
+		}
+		return &notMatch{match: inner}, nil
+	}
+	if len(match.All) > 0 {
+		all := make(allMatch, 0, len(match.All))
+		for _, inner := range match.All {
+			compiled, err := compileMatch(inner, needs)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, compiled)
+		}
+		return all, nil
+	}
+	if len(match.Any) > 0 {
+		any := make(anyMatch, 0, len(match.Any))
+		for _, inner := range match.Any {
+			compiled, err := compileMatch(inner, needs)
+			if err != nil {
+				return nil, err
+			}
+			any = append(any, compiled)
+		}
+		return any, nil
+	}
+	return nil, fmt.Errorf("empty match")
+}
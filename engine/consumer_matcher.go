@@ -0,0 +1,341 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+// an address as two 64 bit halves so that ipv4 (as ::ffff:a.b.c.d) and ipv6 addresses can be compared the same way
+type ipKey struct {
+	hi uint64
+	lo uint64
+}
+
+func (key ipKey) less(other ipKey) bool {
+	return key.hi < other.hi || (key.hi == other.hi && key.lo < other.lo)
+}
+
+// the address after the key, false when the key is the last address
+func (key ipKey) next() (ipKey, bool) {
+	if key.lo == ^uint64(0) {
+		if key.hi == ^uint64(0) {
+			return key, false
+		}
+		return ipKey{hi: key.hi + 1}, true
+	}
+	return ipKey{hi: key.hi, lo: key.lo + 1}, true
+}
+
+// the number of addresses between two keys
+func (key ipKey) minus(other ipKey) ipKey {
+	lo := key.lo - other.lo
+	hi := key.hi - other.hi
+	if key.lo < other.lo {
+		hi--
+	}
+	return ipKey{hi: hi, lo: lo}
+}
+
+func keyForIP(ip net.IP) (ipKey, bool) {
+	if len(ip) == net.IPv4len {
+		return ipKey{lo: 0xffff<<32 | uint64(binary.BigEndian.Uint32(ip))}, true
+	}
+	if len(ip) == net.IPv6len {
+		return ipKey{hi: binary.BigEndian.Uint64(ip[:8]), lo: binary.BigEndian.Uint64(ip[8:])}, true
+	}
+	return ipKey{}, false
+}
+
+// a match from the configuration as the addresses that it covers
+type consumerInterval struct {
+	start    ipKey
+	end      ipKey
+	consumer *consumer
+	// position of the match in the configuration
+	order       int
+	description string
+}
+
+// true if the interval is more specific than the other interval, covering fewer addresses or coming first in the configuration
+func (interval *consumerInterval) moreSpecific(other *consumerInterval) bool {
+	size, otherSize := interval.end.minus(interval.start), other.end.minus(other.start)
+	if size != otherSize {
+		return size.less(otherSize)
+	}
+	return interval.order < other.order
+}
+
+func (interval *consumerInterval) contains(key ipKey) bool {
+	return !key.less(interval.start) && !interval.end.less(key)
+}
+
+// everything from the start of the segment until the start of the next segment belongs to the consumer
+type consumerSegment struct {
+	start    ipKey
+	consumer *consumer
+}
+
+// consumer matches compiled into sorted segments that do not overlap, each segment belongs to the most specific
+// match that covers it so finding the consumer for an address is a binary search
+type consumerMatcher struct {
+	segments []consumerSegment
+
+	// a hardware address is a single device so it is more specific than any address match, a vendor prefix
+	// is only used when no address match covers the client
+	macs    map[string]*consumer
+	vendors map[string]*consumer
+
+	// device ids given by trusted forwarders identify a single device like a hardware address
+	devices map[string]*consumer
+
+	// matches that are more than a single address or hardware address (hostnames, client subnets, and
+	// combinations of matches) are checked first, in the order of the configuration
+	rules []consumerRule
+	needs clientNeeds
+}
+
+type consumerRule struct {
+	consumer *consumer
+	match    clientMatch
+}
+
+func newConsumerMatcher(consumers []*consumer) *consumerMatcher {
+	matcher := &consumerMatcher{
+		macs:    make(map[string]*consumer),
+		vendors: make(map[string]*consumer),
+		devices: make(map[string]*consumer),
+	}
+
+	intervals := make([]*consumerInterval, 0)
+	for _, consumer := range consumers {
+		if consumer == nil || consumer.configConsumer == nil {
+			continue
+		}
+		for _, match := range consumer.configConsumer.Matches {
+			if !match.IsSimple() {
+				compiled, err := compileMatch(match, &matcher.needs)
+				if err != nil {
+					log.Warnf("Consumer '%s' has a match that can't be used: %s", consumer.configConsumer.Name, err)
+					continue
+				}
+				matcher.rules = append(matcher.rules, consumerRule{consumer: consumer, match: compiled})
+				continue
+			}
+			if "" != match.Device {
+				if existing, found := matcher.devices[match.Device]; found && existing != consumer {
+					log.Warnf("Consumer '%s' match device %s is the same as consumer '%s' match device %s and will never be used", consumer.configConsumer.Name, match.Device, existing.configConsumer.Name, match.Device)
+				} else if !found {
+					matcher.devices[match.Device] = consumer
+				}
+				continue
+			}
+			if mac, err := match.ParsedMAC(); err != nil {
+				log.Warnf("Consumer '%s' has a match that can't be used: %s", consumer.configConsumer.Name, err)
+				continue
+			} else if mac != nil {
+				matcher.addMAC(consumer, mac)
+				continue
+			}
+			interval, err := intervalForMatch(match)
+			if err != nil {
+				log.Warnf("Consumer '%s' has a match that can't be used: %s", consumer.configConsumer.Name, err)
+				continue
+			}
+			if interval == nil {
+				continue
+			}
+			interval.consumer = consumer
+			interval.order = len(intervals)
+			intervals = append(intervals, interval)
+		}
+	}
+	warnOverlaps(intervals)
+
+	// every place that the most specific match can change
+	points := make([]ipKey, 0, 2*len(intervals))
+	for _, interval := range intervals {
+		points = append(points, interval.start)
+		if after, ok := interval.end.next(); ok {
+			points = append(points, after)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].less(points[j])
+	})
+
+	matcher.segments = make([]consumerSegment, 0, len(points))
+	for idx, point := range points {
+		if idx > 0 && point == points[idx-1] {
+			continue
+		}
+		var best *consumerInterval
+		for _, interval := range intervals {
+			if interval.contains(point) && (best == nil || interval.moreSpecific(best)) {
+				best = interval
+			}
+		}
+		var found *consumer
+		if best != nil {
+			found = best.consumer
+		}
+		// neighbouring segments for the same consumer are one segment
+		if len(matcher.segments) > 0 && matcher.segments[len(matcher.segments)-1].consumer == found {
+			continue
+		}
+		matcher.segments = append(matcher.segments, consumerSegment{start: point, consumer: found})
+	}
+
+	return matcher
+}
+
+// the first consumer with a hardware address (or vendor prefix) is used for it
+func (matcher *consumerMatcher) addMAC(consumer *consumer, mac net.HardwareAddr) {
+	macs := matcher.macs
+	if len(mac) < 6 {
+		macs = matcher.vendors
+	}
+	if existing, found := macs[string(mac)]; found {
+		if existing != consumer {
+			log.Warnf("Consumer '%s' match mac %s is the same as consumer '%s' match mac %s and will never be used", consumer.configConsumer.Name, mac, existing.configConsumer.Name, mac)
+		}
+		return
+	}
+	macs[string(mac)] = consumer
+}
+
+// true if finding the consumer for a client needs the hardware address of the client
+func (matcher *consumerMatcher) matchesMAC() bool {
+	return matcher != nil && (len(matcher.macs) > 0 || len(matcher.vendors) > 0 || matcher.needs.mac)
+}
+
+// true if finding the consumer for a client needs the name of the client
+func (matcher *consumerMatcher) matchesHostname() bool {
+	return matcher != nil && matcher.needs.name
+}
+
+// true if finding the consumer for a client needs the client subnet of the request
+func (matcher *consumerMatcher) matchesECS() bool {
+	return matcher != nil && matcher.needs.ecs
+}
+
+// the addresses covered by a match or nil if the match has no addresses
+func intervalForMatch(match *config.GudgeonMatch) (*consumerInterval, error) {
+	if match == nil {
+		return nil, nil
+	}
+	if "" != match.IP {
+		key, ok := keyForIP(net.ParseIP(match.IP))
+		if !ok {
+			return nil, fmt.Errorf("invalid ip '%s'", match.IP)
+		}
+		return &consumerInterval{start: key, end: key, description: "ip " + match.IP}, nil
+	}
+	if match.Range != nil && "" != match.Range.Start && "" != match.Range.End {
+		start, startOk := keyForIP(net.ParseIP(match.Range.Start))
+		end, endOk := keyForIP(net.ParseIP(match.Range.End))
+		if !startOk || !endOk || end.less(start) {
+			return nil, fmt.Errorf("invalid range '%s' to '%s'", match.Range.Start, match.Range.End)
+		}
+		return &consumerInterval{start: start, end: end, description: "range " + match.Range.Start + "-" + match.Range.End}, nil
+	}
+	if "" != match.Net {
+		_, parsedNet, err := net.ParseCIDR(match.Net)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net '%s'", match.Net)
+		}
+		start, _ := keyForIP(parsedNet.IP)
+		ones, bits := parsedNet.Mask.Size()
+		// ipv4 networks are the last 32 bits of the ipv4 in ipv6 space
+		hostBits := uint(bits - ones)
+		end := start
+		if hostBits >= 64 {
+			end.lo = ^uint64(0)
+			end.hi |= ^uint64(0) >> (128 - hostBits)
+		} else if hostBits > 0 {
+			end.lo |= ^uint64(0) >> (64 - hostBits)
+		}
+		return &consumerInterval{start: start, end: end, description: "net " + match.Net}, nil
+	}
+	return nil, nil
+}
+
+// warn about matches of different consumers that share addresses, only the most specific match is used for any address
+func warnOverlaps(intervals []*consumerInterval) {
+	for i, first := range intervals {
+		for _, second := range intervals[i+1:] {
+			if first.consumer == second.consumer || first.end.less(second.start) || second.end.less(first.start) {
+				continue
+			}
+			firstName, secondName := first.consumer.configConsumer.Name, second.consumer.configConsumer.Name
+			if first.start == second.start && first.end == second.end {
+				log.Warnf("Consumer '%s' match %s is the same as consumer '%s' match %s and will never be used", secondName, second.description, firstName, first.description)
+			} else if first.contains(second.start) && first.contains(second.end) {
+				log.Warnf("Consumer '%s' match %s is inside of consumer '%s' match %s, consumer '%s' is used for those addresses", secondName, second.description, firstName, first.description, secondName)
+			} else if second.contains(first.start) && second.contains(first.end) {
+				log.Warnf("Consumer '%s' match %s is inside of consumer '%s' match %s, consumer '%s' is used for those addresses", firstName, first.description, secondName, second.description, firstName)
+			} else {
+				log.Warnf("Consumer '%s' match %s overlaps consumer '%s' match %s, the smaller match is used for the addresses they share", firstName, first.description, secondName, second.description)
+			}
+		}
+	}
+}
+
+// the consumer with the most specific match for the client or nil if no match covers the client
+func (matcher *consumerMatcher) find(client clientInfo) *consumer {
+	if matcher == nil {
+		return nil
+	}
+	for _, rule := range matcher.rules {
+		if rule.match.matches(client) {
+			return rule.consumer
+		}
+	}
+	if "" != client.device {
+		if found, ok := matcher.devices[client.device]; ok {
+			return found
+		}
+	}
+	ip, mac := client.ip, client.mac
+	if len(mac) == 6 {
+		if found, ok := matcher.macs[string(mac)]; ok {
+			return found
+		}
+	}
+	if found := matcher.findAddress(ip); found != nil {
+		return found
+	}
+	if len(mac) == 6 {
+		if found, ok := matcher.vendors[string(mac[:3])]; ok {
+			return found
+		}
+	}
+	return nil
+}
+
+// the consumer with the most specific address match for the address
+func (matcher *consumerMatcher) findAddress(ip net.IP) *consumer {
+	key, ok := keyForIP(ip)
+	if !ok {
+		return nil
+	}
+	// the last segment that starts at or before the address
+	low, high := 0, len(matcher.segments)
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if key.less(matcher.segments[middle].start) {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	if low < 1 {
+		return nil
+	}
+	return matcher.segments[low-1].consumer
+}
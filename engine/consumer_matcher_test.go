@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+func testConsumer(name string, matches ...*config.GudgeonMatch) *consumer {
+	return &consumer{configConsumer: &config.GudgeonConsumer{Name: name, Matches: matches}}
+}
+
+func TestConsumerMatcherMostSpecific(t *testing.T) {
+	consumers := []*consumer{
+		// listed first but covers the most addresses
+		testConsumer("lan", &config.GudgeonMatch{Net: "10.0.0.0/8"}, &config.GudgeonMatch{Net: "fd00::/8"}),
+		testConsumer("office", &config.GudgeonMatch{Range: &config.GudgeonMatchRange{Start: "10.0.5.10", End: "10.0.5.200"}}),
+		testConsumer("printer", &config.GudgeonMatch{IP: "10.0.5.20"}, &config.GudgeonMatch{IP: "fd00::20"}),
+		testConsumer("lab", &config.GudgeonMatch{Net: "10.0.5.0/24"}),
+		// the same as the lab network so it is never used
+		testConsumer("shadowed", &config.GudgeonMatch{Net: "10.0.5.0/24"}),
+		testConsumer("bad", &config.GudgeonMatch{IP: "not an ip"}, &config.GudgeonMatch{Range: &config.GudgeonMatchRange{Start: "10.0.0.9", End: "10.0.0.1"}}),
+	}
+	matcher := newConsumerMatcher(consumers)
+
+	data := []struct {
+		ip       string
+		consumer string
+	}{
+		{"10.0.0.1", "lan"},
+		{"10.255.255.255", "lan"},
+		{"10.0.5.1", "lab"},
+		{"10.0.5.9", "lab"},
+		{"10.0.5.10", "office"},
+		{"10.0.5.20", "printer"},
+		{"10.0.5.21", "office"},
+		{"10.0.5.200", "office"},
+		{"10.0.5.201", "lab"},
+		{"10.0.6.1", "lan"},
+		{"fd00::1", "lan"},
+		{"fd00::20", "printer"},
+		{"11.0.0.1", ""},
+		{"9.255.255.255", ""},
+		{"::1", ""},
+	}
+	for _, d := range data {
+		ip := net.ParseIP(d.ip)
+		name := ""
+		if found := matcher.find(clientInfo{ip: ip}); found != nil {
+			name = found.configConsumer.Name
+		}
+		if name != d.consumer {
+			t.Errorf("Expected consumer '%s' for %s but got '%s'", d.consumer, d.ip, name)
+		}
+		// short form ipv4 addresses are the same addresses
+		if ip4 := ip.To4(); ip4 != nil {
+			if found := matcher.find(clientInfo{ip: ip4}); (found == nil && "" != d.consumer) || (found != nil && found.configConsumer.Name != d.consumer) {
+				t.Errorf("Expected consumer '%s' for 4 byte %s", d.consumer, d.ip)
+			}
+		}
+	}
+
+	ip := net.ParseIP("10.0.5.20")
+	if allocs := testing.AllocsPerRun(100, func() { matcher.find(clientInfo{ip: ip}) }); allocs > 0 {
+		t.Errorf("Expected no allocations when finding a consumer but got %f", allocs)
+	}
+}
+
+func BenchmarkConsumerMatcher(b *testing.B) {
+	consumers := make([]*consumer, 0, 256)
+	for idx := 0; idx < 256; idx++ {
+		consumers = append(consumers, testConsumer(fmt.Sprintf("consumer%d", idx), &config.GudgeonMatch{Net: fmt.Sprintf("10.%d.0.0/16", idx)}, &config.GudgeonMatch{IP: fmt.Sprintf("192.168.0.%d", idx)}))
+	}
+	matcher := newConsumerMatcher(consumers)
+	ip := net.ParseIP("192.168.0.200")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.find(clientInfo{ip: ip})
+	}
+}
+
+func TestConsumerMatcherRules(t *testing.T) {
+	consumers := []*consumer{
+		testConsumer("lan", &config.GudgeonMatch{Net: "10.0.0.0/8"}),
+		// all of 10.0.0.0/8 except for 10.0.5.0/24
+		testConsumer("most", &config.GudgeonMatch{All: []*config.GudgeonMatch{
+			{Net: "10.0.0.0/8"},
+			{Not: &config.GudgeonMatch{Net: "10.0.5.0/24"}},
+		}}),
+		testConsumer("kids", &config.GudgeonMatch{Hostname: "Kids-*.lan."}),
+		testConsumer("branch", &config.GudgeonMatch{ECS: "172.16.0.0/16"}),
+		testConsumer("either", &config.GudgeonMatch{Any: []*config.GudgeonMatch{
+			{MAC: "aa:bb:cc"},
+			{Hostname: "tv"},
+		}}),
+		testConsumer("bad", &config.GudgeonMatch{Not: &config.GudgeonMatch{}}, &config.GudgeonMatch{Hostname: "[bad"}),
+	}
+	matcher := newConsumerMatcher(consumers)
+	if !matcher.matchesHostname() || !matcher.matchesECS() || !matcher.matchesMAC() {
+		t.Errorf("Expected the matcher to need hostnames, client subnets, and hardware addresses")
+	}
+
+	mac, _ := net.ParseMAC("aa:bb:cc:01:02:03")
+	data := []struct {
+		client   clientInfo
+		consumer string
+	}{
+		{clientInfo{ip: net.ParseIP("10.0.1.1")}, "most"},
+		{clientInfo{ip: net.ParseIP("10.0.5.1")}, "lan"},
+		{clientInfo{ip: net.ParseIP("192.168.0.5"), name: "kids-tablet.lan."}, "kids"},
+		{clientInfo{ip: net.ParseIP("192.168.0.5"), name: "kids.lan"}, ""},
+		{clientInfo{ip: net.ParseIP("192.168.0.5"), ecs: net.ParseIP("172.16.4.0"), ecsBits: 24}, "branch"},
+		// a client subnet bigger than the match is not inside of it
+		{clientInfo{ip: net.ParseIP("192.168.0.5"), ecs: net.ParseIP("172.16.0.0"), ecsBits: 12}, ""},
+		{clientInfo{ip: net.ParseIP("192.168.0.5"), mac: mac}, "either"},
+		{clientInfo{ip: net.ParseIP("192.168.0.5"), name: "TV"}, "either"},
+		{clientInfo{ip: net.ParseIP("192.168.0.5")}, ""},
+	}
+	for _, d := range data {
+		name := ""
+		if found := matcher.find(d.client); found != nil {
+			name = found.configConsumer.Name
+		}
+		if name != d.consumer {
+			t.Errorf("Expected consumer '%s' for %v but got '%s'", d.consumer, d.client, name)
+		}
+	}
+}
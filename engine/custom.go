@@ -0,0 +1,323 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/rule"
+)
+
+// how often expired custom rules are removed from the store
+var customRuleSweepInterval = 15 * time.Second
+
+// a rule in the managed allow or block list of a group
+type CustomRule struct {
+	Group   string     `json:"group"`
+	Type    string     `json:"type"`
+	Rule    string     `json:"rule"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (rule *CustomRule) expired(now time.Time) bool {
+	return rule.Expires != nil && !rule.Expires.After(now)
+}
+
+// the custom rules for every group are kept in a single file in the data directory
+type customRules struct {
+	engine   *engine
+	filePath string
+	// group -> type -> rule
+	rules map[string]map[string]map[string]*CustomRule
+	mux   sync.Mutex
+	done  chan bool
+}
+
+func newCustomRules(engine *engine, filePath string) *customRules {
+	custom := &customRules{
+		engine:   engine,
+		filePath: filePath,
+		rules:    make(map[string]map[string]map[string]*CustomRule),
+	}
+
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Could not read custom rules: %s", err)
+		}
+		return custom
+	}
+
+	loaded := make([]*CustomRule, 0)
+	if err := json.Unmarshal(bytes, &loaded); err != nil {
+		log.Errorf("Could not read custom rules: %s", err)
+		return custom
+	}
+
+	now := time.Now()
+	for _, rule := range loaded {
+		// rules for groups that no longer exist are dropped with expired rules
+		if rule == nil || rule.expired(now) || engine.customList(rule.Group, rule.Type) == nil {
+			continue
+		}
+		custom.put(rule)
+	}
+
+	return custom
+}
+
+func (custom *customRules) put(rule *CustomRule) {
+	if _, found := custom.rules[rule.Group]; !found {
+		custom.rules[rule.Group] = make(map[string]map[string]*CustomRule)
+	}
+	if _, found := custom.rules[rule.Group][rule.Type]; !found {
+		custom.rules[rule.Group][rule.Type] = make(map[string]*CustomRule)
+	}
+	custom.rules[rule.Group][rule.Type][rule.Rule] = rule
+}
+
+// all rules, sorted, must be called while holding the lock
+func (custom *customRules) all(group string) []*CustomRule {
+	list := make([]*CustomRule, 0)
+	for groupName, types := range custom.rules {
+		if "" != group && group != groupName {
+			continue
+		}
+		for _, rules := range types {
+			for _, rule := range rules {
+				list = append(list, rule)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Group != list[j].Group {
+			return list[i].Group < list[j].Group
+		}
+		if list[i].Type != list[j].Type {
+			return list[i].Type < list[j].Type
+		}
+		return list[i].Rule < list[j].Rule
+	})
+	return list
+}
+
+// write the rules to the file, must be called while holding the lock
+func (custom *customRules) save() {
+	bytes, err := json.Marshal(custom.all(""))
+	if err != nil {
+		log.Errorf("Could not save custom rules: %s", err)
+		return
+	}
+
+	if _, err := os.Stat(path.Dir(custom.filePath)); os.IsNotExist(err) {
+		if err = os.MkdirAll(path.Dir(custom.filePath), os.ModePerm); err != nil {
+			log.Errorf("Could not create custom rules directory: %s", err)
+			return
+		}
+	}
+
+	if err := ioutil.WriteFile(custom.filePath, bytes, 0644); err != nil {
+		log.Errorf("Could not save custom rules: %s", err)
+	}
+}
+
+// replace the rules of the managed list in the store without touching any other list, must be called while holding the lock
+func (custom *customRules) apply(group string, ruleType string) {
+	list := custom.engine.customList(group, ruleType)
+	if list == nil || custom.engine.store == nil {
+		return
+	}
+
+	rules := make([]string, 0, len(custom.rules[group][ruleType]))
+	for _, rule := range custom.rules[group][ruleType] {
+		rules = append(rules, rule.Rule)
+	}
+	rule.ReplaceRules(custom.engine.store, custom.engine.Root(), custom.engine.config, list, rules)
+}
+
+// load every managed list into the store, lists without rules are cleared because a persistent store can still have
+// rules that expired or were removed while gudgeon was not running
+func (custom *customRules) applyAll() {
+	custom.mux.Lock()
+	defer custom.mux.Unlock()
+	for _, group := range custom.engine.config.Groups {
+		if group == nil {
+			continue
+		}
+		for _, ruleType := range []string{string(config.ALLOWSTRING), string(config.BLOCKSTRING)} {
+			custom.apply(group.Name, ruleType)
+		}
+	}
+}
+
+// remove expired rules and update the lists that they were removed from
+func (custom *customRules) sweep() {
+	custom.mux.Lock()
+	defer custom.mux.Unlock()
+
+	now := time.Now()
+	changed := false
+	for group, types := range custom.rules {
+		for ruleType, rules := range types {
+			removed := false
+			for text, rule := range rules {
+				if rule.expired(now) {
+					delete(rules, text)
+					removed = true
+				}
+			}
+			if removed {
+				custom.apply(group, ruleType)
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		custom.save()
+	}
+}
+
+// periodically remove expired rules until signaled to stop
+func (custom *customRules) sweepWorker() {
+	ticker := time.NewTicker(customRuleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			custom.sweep()
+		case <-custom.done:
+			custom.done <- true
+			return
+		}
+	}
+}
+
+func (custom *customRules) start() {
+	custom.done = make(chan bool)
+	go custom.sweepWorker()
+}
+
+func (custom *customRules) stop() {
+	if custom.done != nil {
+		custom.done <- true
+		<-custom.done
+		close(custom.done)
+		custom.done = nil
+	}
+}
+
+// the managed list for the group and rule type ("allow" or "block")
+func (engine *engine) customList(group string, ruleType string) *config.GudgeonList {
+	switch ruleType {
+	case string(config.ALLOWSTRING):
+		return engine.config.GetCustomList(group, config.ALLOW)
+	case string(config.BLOCKSTRING):
+		return engine.config.GetCustomList(group, config.BLOCK)
+	}
+	return nil
+}
+
+// normalize and check the group and rule type for a custom rule
+func (engine *engine) customRuleTarget(group string, ruleType string) (string, string, error) {
+	group = strings.TrimSpace(group)
+	ruleType = strings.ToLower(strings.TrimSpace(ruleType))
+	if ruleType != string(config.ALLOWSTRING) && ruleType != string(config.BLOCKSTRING) {
+		return group, ruleType, fmt.Errorf("Rule type must be '%s' or '%s'", config.ALLOWSTRING, config.BLOCKSTRING)
+	}
+	if engine.customList(group, ruleType) == nil {
+		return group, ruleType, fmt.Errorf("No group named '%s'", group)
+	}
+	return group, ruleType, nil
+}
+
+// domains and globs are matched without case but the text of a regex is kept as it was given
+func normalizeCustomRule(text string) string {
+	text = strings.TrimSpace(text)
+	if rule.IsRegex(text) {
+		return text
+	}
+	return strings.ToLower(text)
+}
+
+// add a rule to the managed allow or block list of a group, a duration greater than zero makes the rule expire
+func (engine *engine) AddCustomRule(group string, ruleType string, rule string, duration time.Duration) (*CustomRule, error) {
+	group, ruleType, err := engine.customRuleTarget(group, ruleType)
+	if err != nil {
+		return nil, err
+	}
+
+	rule = normalizeCustomRule(rule)
+	if "" == rule || strings.ContainsAny(rule, " \t") {
+		return nil, fmt.Errorf("Rule must be a single domain, glob, or regex")
+	}
+
+	added := &CustomRule{
+		Group: group,
+		Type:  ruleType,
+		Rule:  rule,
+	}
+	if duration > 0 {
+		expires := time.Now().Add(duration)
+		added.Expires = &expires
+	}
+
+	engine.custom.mux.Lock()
+	defer engine.custom.mux.Unlock()
+	engine.custom.put(added)
+	engine.custom.apply(group, ruleType)
+	engine.custom.save()
+
+	return added, nil
+}
+
+// remove a rule from the managed allow or block list of a group
+func (engine *engine) RemoveCustomRule(group string, ruleType string, rule string) (bool, error) {
+	group, ruleType, err := engine.customRuleTarget(group, ruleType)
+	if err != nil {
+		return false, err
+	}
+	rule = normalizeCustomRule(rule)
+
+	engine.custom.mux.Lock()
+	defer engine.custom.mux.Unlock()
+	if _, found := engine.custom.rules[group][ruleType][rule]; !found {
+		return false, nil
+	}
+	delete(engine.custom.rules[group][ruleType], rule)
+	engine.custom.apply(group, ruleType)
+	engine.custom.save()
+
+	return true, nil
+}
+
+// the custom rules for a group, or for all groups if no group is given
+func (engine *engine) CustomRules(group string) []*CustomRule {
+	group = strings.TrimSpace(group)
+
+	engine.custom.mux.Lock()
+	defer engine.custom.mux.Unlock()
+
+	now := time.Now()
+	rules := make([]*CustomRule, 0)
+	for _, rule := range engine.custom.all(group) {
+		if !rule.expired(now) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (engine *engine) CustomRulesPath() string {
+	return path.Join(engine.config.DataRoot(), "custom.json")
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/db"
+	"github.com/chrisruffalo/gudgeon/resolver"
+	"github.com/chrisruffalo/gudgeon/rule"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestCustomRules(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/override.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+
+	check := func(engine Engine, domain string, rcode int, match rule.Match) {
+		request := new(dns.Msg)
+		request.SetQuestion(domain, dns.TypeA)
+		response, _, result := engine.HandleWithConsumerName("default", resolver.DefaultRequestContext(), request)
+		if response == nil || result == nil {
+			t.Errorf("Expected response for '%s'", domain)
+			return
+		}
+		if response.Rcode != rcode || result.Match != match {
+			t.Errorf("Expected rcode %d and match %d for '%s' but got rcode %d and match %d", rcode, match, domain, response.Rcode, result.Match)
+		}
+	}
+
+	check(testEngine, "blocked.example.", dns.RcodeNameError, rule.MatchBlock)
+	check(testEngine, "good.example.", dns.RcodeSuccess, rule.MatchNone)
+
+	// invalid rules
+	if _, err := testEngine.AddCustomRule("nogroup", "block", "good.example", 0); err == nil {
+		t.Errorf("Expected error for an unknown group")
+	}
+	if _, err := testEngine.AddCustomRule("default", "deny", "good.example", 0); err == nil {
+		t.Errorf("Expected error for an unknown rule type")
+	}
+	if _, err := testEngine.AddCustomRule("default", "block", "", 0); err == nil {
+		t.Errorf("Expected error for an empty rule")
+	}
+
+	// rules take effect immediately
+	if _, err := testEngine.AddCustomRule("default", "block", "good.example", 0); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+	if _, err := testEngine.AddCustomRule("default", "allow", "blocked.example", 0); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+	check(testEngine, "good.example.", dns.RcodeNameError, rule.MatchBlock)
+	check(testEngine, "blocked.example.", dns.RcodeSuccess, rule.MatchAllow)
+
+	// rules survive a reload
+	testEngine.Shutdown()
+	testEngine, err = NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not recreate engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+	if len(testEngine.CustomRules("default")) != 2 {
+		t.Errorf("Expected 2 custom rules after reload but got %d", len(testEngine.CustomRules("default")))
+	}
+	check(testEngine, "good.example.", dns.RcodeNameError, rule.MatchBlock)
+
+	// removing a rule restores the other lists
+	if removed, err := testEngine.RemoveCustomRule("default", "allow", "blocked.example"); !removed || err != nil {
+		t.Errorf("Expected rule to be removed")
+	}
+	check(testEngine, "blocked.example.", dns.RcodeNameError, rule.MatchBlock)
+
+	// rules expire
+	if _, err := testEngine.AddCustomRule("default", "block", "other.example", time.Millisecond); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if len(testEngine.CustomRules("")) != 1 {
+		t.Errorf("Expected expired rules to be hidden but got %d rules", len(testEngine.CustomRules("")))
+	}
+	if current, ok := testEngine.(*engine); ok {
+		current.custom.sweep()
+		if len(current.custom.all("")) != 1 {
+			t.Errorf("Expected expired rules to be removed")
+		}
+	}
+
+	// the case of a regex is kept, "\S" does not match what "\s" does
+	added, err := testEngine.AddCustomRule("default", "block", " /^CDN\\S+\\.example/ ", 0)
+	if err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	} else if added.Rule != "/^CDN\\S+\\.example/" {
+		t.Errorf("Expected the regex to be kept as given but got '%s'", added.Rule)
+	}
+	if _, err := testEngine.AddCustomRule("default", "block", "/^cdn\\S+\\.example/", 0); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+	check(testEngine, "cdnx.example.", dns.RcodeNameError, rule.MatchBlock)
+	if removed, err := testEngine.RemoveCustomRule("default", "block", "/^CDN\\S+\\.example/"); !removed || err != nil {
+		t.Errorf("Expected the regex to be removed by its text")
+	}
+	if len(testEngine.CustomRules("default")) != 2 {
+		t.Errorf("Expected regex rules that differ in case to be separate rules")
+	}
+}
+
+func TestCustomRulesExpireWhileStopped(t *testing.T) {
+	conf := testutil.TestConf(t, "testdata/override.yml")
+	defer os.RemoveAll(conf.Home)
+	// the sqlite store keeps rules between runs
+	conf.Storage.RuleStorage = "sqlite"
+
+	testEngine, err := NewEngine(conf)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	if _, err := testEngine.AddCustomRule("default", "block", "good.example", 50*time.Millisecond); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+	if match, _, _ := testEngine.IsDomainRuleMatched(nil, "good.example"); match != rule.MatchBlock {
+		t.Errorf("Expected the custom rule to block the domain")
+	}
+	testEngine.Shutdown()
+
+	// the only rule in the list expires while the engine is stopped
+	time.Sleep(100 * time.Millisecond)
+	testEngine, err = NewEngine(conf)
+	if err != nil {
+		t.Errorf("Could not recreate engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+	if match, _, _ := testEngine.IsDomainRuleMatched(nil, "good.example"); match != rule.MatchNone {
+		t.Errorf("Expected the expired custom rule to not match but got match %d", match)
+	}
+
+	// the rule is removed from the rules that are kept between runs
+	rulesDb, err := db.Open(path.Join(conf.RulesRoot(), "sqlite", "rules.db"), "cache=shared")
+	if err != nil {
+		t.Errorf("Could not open rules database: %s", err)
+		return
+	}
+	defer rulesDb.Close()
+	count := 0
+	row := rulesDb.QueryRow("SELECT COUNT(*) FROM rules r JOIN lists l ON r.ListRowId = l.Id WHERE l.ShortName = ?", conf.GetCustomList("default", config.BLOCK).ShortName())
+	if err := row.Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected no kept rules for the custom list but found %d (%v)", count, err)
+	}
+}
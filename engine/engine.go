@@ -1,11 +1,11 @@
 package engine
 
 import (
-	"bytes"
 	"database/sql"
 	"fmt"
 	"github.com/chrisruffalo/gudgeon/events"
 	"net"
+	"os"
 	"path"
 	"reflect"
 	"strings"
@@ -14,6 +14,7 @@ import (
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/chrisruffalo/gudgeon/cache"
 	"github.com/chrisruffalo/gudgeon/config"
 	"github.com/chrisruffalo/gudgeon/resolver"
 	"github.com/chrisruffalo/gudgeon/rule"
@@ -71,6 +72,8 @@ type engine struct {
 	// the session (which will represent the on-disk location inside of the gudgeon folder)
 	// that is being used as backing storage and state behind the engine
 	session string
+	// held while the engine is using the session so that other processes leave it in place
+	sessionLock *util.FileLock
 
 	// database for long term data storage
 	db *sql.DB
@@ -94,6 +97,12 @@ type engine struct {
 	// map for out-of-order consumer getting
 	consumerMap map[string]*consumer
 
+	// consumer matches compiled for finding the consumer of an address
+	consumerMatcher *consumerMatcher
+
+	// hardware addresses of local clients, nil when they are not used
+	neighbors *neighbors
+
 	// default consumer
 	defaultConsumer *consumer
 
@@ -103,6 +112,24 @@ type engine struct {
 	// the backing store for block/allow rules
 	store rule.Store
 
+	// response policy zones
+	policies rule.RpzStore
+
+	// ip lists that are checked against answers
+	ips rule.IpStore
+
+	// temporary pauses and blocks
+	overrides *overrides
+
+	// token buckets and bans for clients over the rate limit
+	rateLimiter *rateLimiter
+
+	// consumers of listening interfaces
+	bindings []*interfaceBinding
+
+	// rules that are added and removed at runtime
+	custom *customRules
+
 	// the resolution structure
 	resolvers     resolver.ResolverMap
 	resolverNames *[]string
@@ -116,6 +143,9 @@ type engine struct {
 
 	// list of handles
 	handles []*events.Handle
+
+	// stops the periodic cache snapshot
+	cacheSnapshotDone chan bool
 }
 
 func (engine *engine) Root() string {
@@ -126,13 +156,23 @@ func (engine *engine) ListPath(listType string) string {
 	return path.Join(engine.Root(), listType+".list")
 }
 
+func (engine *engine) CacheSnapshotPath() string {
+	return path.Join(engine.config.DataRoot(), "cache", "snapshot.json")
+}
+
 type Engine interface {
 	IsDomainRuleMatched(consumer *net.IP, domain string) (rule.Match, *config.GudgeonList, string)
+
+	// every rule that matches the domain and how the decision is reached
+	ExplainWithConsumerName(consumerName string, domain string) *rule.Explanation
+	ExplainWithGroups(groups []string, domain string) *rule.Explanation
 	Resolve(domainName string) (string, error)
 	Reverse(address string) string
 
 	// different direct handle methods
-	Handle(address *net.IP, protocol string, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult)
+	// the listener is the local address the request was received on and the forwarded client is the identity of the
+	// client given by a trusted forwarder, both can be nil
+	Handle(address *net.IP, listener net.Addr, protocol string, forwarded *ForwardedClient, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult)
 	HandleWithConsumerName(consumerName string, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult)
 	HandleWithConsumer(consumer *consumer, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult)
 	HandleWithGroups(groups []string, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult)
@@ -147,6 +187,25 @@ type Engine interface {
 	// stats
 	CacheSize() int64
 
+	// cache inspection and targeted flushing
+	CacheEntries(partition string, domain string) []*cache.Entry
+	CacheFlushDomain(partition string, domain string) int
+	CacheFlushPartition(partition string) int
+
+	// temporary pausing or forcing of blocking
+	SetOverride(scope string, name string, action string, duration time.Duration) (*Override, error)
+	ClearOverride(scope string, name string) bool
+	Overrides() []*Override
+
+	// clients banned for staying over the rate limit
+	Bans() []*Ban
+	Unban(client string) bool
+
+	// runtime editing of the custom allow and block lists of each group
+	AddCustomRule(group string, ruleType string, rule string, duration time.Duration) (*CustomRule, error)
+	RemoveCustomRule(group string, ruleType string, rule string) (bool, error)
+	CustomRules(group string) []*CustomRule
+
 	// inner providers
 	QueryLog() QueryLog
 	Metrics() Metrics
@@ -159,42 +218,59 @@ type Engine interface {
 }
 
 func (engine *engine) getConsumerForIP(consumerIP *net.IP) *consumer {
-	var foundConsumer *consumer
+	return engine.getConsumerForClient(engine.getClient(consumerIP, nil))
+}
 
-	for _, activeConsumer := range engine.consumers {
-		for _, match := range activeConsumer.configConsumer.Matches {
-			// test ip match
-			if "" != match.IP {
-				matchIP := net.ParseIP(match.IP)
-				if matchIP != nil && bytes.Compare(matchIP.To16(), consumerIP.To16()) == 0 {
-					foundConsumer = activeConsumer
-					break
-				}
-			}
-			// test range match
-			if foundConsumer == nil && match.Range != nil && "" != match.Range.Start && "" != match.Range.End {
-				startIP := net.ParseIP(match.Range.Start)
-				endIP := net.ParseIP(match.Range.End)
-				if startIP != nil && endIP != nil && bytes.Compare(consumerIP.To16(), startIP.To16()) >= 0 && bytes.Compare(consumerIP.To16(), endIP.To16()) <= 0 {
-					foundConsumer = activeConsumer
-					break
-				}
-			}
-			// test net (subnet) match
-			if foundConsumer == nil && "" != match.Net {
-				_, parsedNet, err := net.ParseCIDR(match.Net)
-				if err == nil && parsedNet != nil && parsedNet.Contains(*consumerIP) {
-					foundConsumer = activeConsumer
-					break
-				}
-			}
-			if foundConsumer != nil {
-				break
-			}
-		}
-		if foundConsumer != nil {
-			break
+// what is known about the client that sent the request, the forwarded client can be nil
+func (engine *engine) getClient(address *net.IP, forwarded *ForwardedClient) clientInfo {
+	client := clientInfo{}
+	if address != nil {
+		client.ip = *address
+	}
+	// a trusted forwarder identifies the client it sent the request for
+	if forwarded != nil {
+		if forwarded.Address != nil {
+			client.ip = forwarded.Address
 		}
+		client.mac = forwarded.MAC
+		client.device = forwarded.DeviceID
+	}
+	if client.ip == nil {
+		return client
+	}
+
+	// only look up what is used, a forwarded request comes from the forwarder so the client is not a neighbour
+	if forwarded == nil {
+		client.mac = engine.neighbors.lookup(client.ip)
+	}
+	if engine.consumerMatcher.matchesHostname() && engine.recorder != nil {
+		client.name = engine.recorder.knownName(client.ip.String())
+	}
+	// the client subnet is only used when a trusted forwarder gave it
+	if forwarded != nil && engine.consumerMatcher.matchesECS() {
+		client.ecs, client.ecsBits = forwarded.Subnet, forwarded.SubnetBits
+	}
+	return client
+}
+
+func (engine *engine) getConsumerForClient(client clientInfo) *consumer {
+	return engine.getConsumerForListener(client, nil)
+}
+
+// the consumer of an interface is used for the clients of the interface that no consumer matches, or for every
+// client of the interface when it overrides matching
+func (engine *engine) getConsumerForListener(client clientInfo, binding *interfaceBinding) *consumer {
+	if binding != nil && binding.override {
+		return binding.consumer
+	}
+
+	var foundConsumer *consumer
+	if client.ip != nil || "" != client.device {
+		foundConsumer = engine.consumerMatcher.find(client)
+	}
+
+	if foundConsumer == nil && binding != nil {
+		foundConsumer = binding.consumer
 	}
 
 	// return default consumer
@@ -211,13 +287,51 @@ func (engine *engine) getConsumerGroups(consumerIP *net.IP) []string {
 }
 
 func (engine *engine) getGroups(consumer *consumer) []string {
-	// return found consumer data if something was found
+	groups, _ := engine.getScheduledGroups(consumer, time.Now())
+	return groups
+}
+
+// the override for the consumer or any of the groups, if there is one
+func (engine *engine) findOverride(consumer *consumer, groups []string) *Override {
+	if engine.overrides == nil {
+		return nil
+	}
+	consumerName := ""
+	if consumer != nil && consumer.configConsumer != nil {
+		consumerName = consumer.configConsumer.Name
+	}
+	return engine.overrides.find(consumerName, groups)
+}
+
+// get the groups for the consumer at the given time along with the schedule that changed them (if any)
+func (engine *engine) getScheduledGroups(consumer *consumer, now time.Time) ([]string, *config.GudgeonSchedule) {
+	groups := []string{"default"}
+
+	// use found consumer data if something was found
 	if consumer != nil && len(consumer.groupNames) > 0 {
-		return consumer.groupNames
+		groups = consumer.groupNames
 	}
 
-	// return the default group in the event nothing else is available
-	return []string{"default"}
+	var schedule *config.GudgeonSchedule
+	if consumer != nil {
+		schedule = consumer.configConsumer.ActiveSchedule(now)
+	}
+	if schedule == nil {
+		return groups, nil
+	}
+
+	// the schedule can replace the groups or add to them
+	if schedule.Replace && len(schedule.Groups) > 0 {
+		return schedule.Groups, schedule
+	}
+	scheduled := make([]string, 0, len(groups)+len(schedule.Groups))
+	scheduled = append(scheduled, groups...)
+	for _, group := range schedule.Groups {
+		if !util.StringIn(group, scheduled) {
+			scheduled = append(scheduled, group)
+		}
+	}
+	return scheduled, schedule
 }
 
 func (engine *engine) getConsumerResolvers(consumerIP *net.IP) []string {
@@ -242,6 +356,26 @@ func (engine *engine) IsDomainRuleMatched(consumerIp *net.IP, domain string) (ru
 	return engine.domainRuleMatchedForConsumer(consumer, domain)
 }
 
+// explain the rules that match the domain for the lists of the consumer, including any active schedule
+func (engine *engine) ExplainWithConsumerName(consumerName string, domain string) *rule.Explanation {
+	consumer, found := engine.consumerMap[consumerName]
+	if !found {
+		consumer = engine.defaultConsumer
+	}
+	if consumer == nil {
+		return rule.Explain(engine.store, []*config.GudgeonList{}, domain)
+	}
+	if groups, schedule := engine.getScheduledGroups(consumer, time.Now()); schedule != nil {
+		return rule.Explain(engine.store, engine.listsForGroups(groups), domain)
+	}
+	return rule.Explain(engine.store, consumer.lists, domain)
+}
+
+// explain the rules that match the domain for the lists of the groups
+func (engine *engine) ExplainWithGroups(groups []string, domain string) *rule.Explanation {
+	return rule.Explain(engine.store, engine.listsForGroups(groups), domain)
+}
+
 func (engine *engine) domainRuleMatchForLists(lists []*config.GudgeonList, domain string) (rule.Match, *config.GudgeonList, string) {
 	// drop ending . if present from domain
 	if strings.HasSuffix(domain, ".") {
@@ -266,33 +400,149 @@ func (engine *engine) domainRuleMatchedForConsumer(consumer *consumer, domain st
 	if consumer == nil {
 		return rule.MatchNone, nil, ""
 	}
+	// an active schedule changes the groups and so the lists
+	if groups, schedule := engine.getScheduledGroups(consumer, time.Now()); schedule != nil {
+		return engine.domainRuleMatchForLists(engine.listsForGroups(groups), domain)
+	}
 	return engine.domainRuleMatchForLists(consumer.lists, domain)
 }
 
-func (engine *engine) domainRuleMatchedForGroups(groups []string, domain string) (rule.Match, *config.GudgeonList, string) {
-	if len(groups) < 1 {
-		return rule.MatchNone, nil, ""
+// select all resolver names from found groups, in the given order
+func (engine *engine) resolversForGroups(groups []string) []string {
+	// accumulate resolver names, up to a maximum of resolvers before having to append
+	resolverNames := make([]string, 0, len(engine.config.Resolvers))
+	for _, groupName := range groups {
+		// get resolvers from group
+		group, found := engine.groups[groupName]
+		if !found {
+			continue
+		}
+		resolverNames = append(resolverNames, group.configGroup.Resolvers...)
 	}
+	return resolverNames
+}
 
-	// select all lists from found groups
+// select all lists from found groups
+func (engine *engine) listsForGroups(groups []string) []*config.GudgeonList {
 	lists := make([]*config.GudgeonList, 0)
 	for _, g := range groups {
 		if group, found := engine.groups[g]; found {
 			lists = append(lists, group.lists...)
 		}
 	}
+	return lists
+}
+
+func (engine *engine) domainRuleMatchedForGroups(groups []string, domain string) (rule.Match, *config.GudgeonList, string) {
+	if len(groups) < 1 {
+		return rule.MatchNone, nil, ""
+	}
+
+	return engine.domainRuleMatchForLists(engine.listsForGroups(groups), domain)
+}
+
+// creates the response for a triggered policy, a nil response means that the request should be dropped
+func (engine *engine) policyResponse(policy *rule.RpzMatch, resolverNames []string, rCon *resolver.RequestContext, request *dns.Msg) *dns.Msg {
+	if policy.Action == rule.RpzDrop {
+		return nil
+	}
+
+	response := new(dns.Msg)
+	response.SetReply(request)
+
+	switch policy.Action {
+	case rule.RpzNxdomain:
+		response.Rcode = dns.RcodeNameError
+	case rule.RpzLocalData:
+		question := request.Question[0]
+		var cname *dns.CNAME
+		for _, record := range policy.Records {
+			if record.Header().Class != question.Qclass && question.Qclass != dns.ClassANY {
+				continue
+			}
+			if cnameRecord, ok := record.(*dns.CNAME); ok && question.Qtype != dns.TypeCNAME {
+				cname = dns.Copy(cnameRecord).(*dns.CNAME)
+				cname.Hdr.Name = question.Name
+				// a wildcard target is filled in with the name in the question
+				if strings.HasPrefix(cname.Target, "*.") {
+					cname.Target = strings.TrimSuffix(question.Name, ".") + cname.Target[1:]
+				}
+				break
+			}
+			if record.Header().Rrtype == question.Qtype || question.Qtype == dns.TypeANY {
+				answer := dns.Copy(record)
+				answer.Header().Name = question.Name
+				response.Answer = append(response.Answer, answer)
+			}
+		}
+
+		// local data cnames are resolved without evaluating policy again
+		if cname != nil {
+			response.Answer = []dns.RR{cname}
+			cnameRequest := request.Copy()
+			cnameRequest.Question[0].Name = cname.Target
+			cnameResponse, _, cnameResult := engine.HandleWithResolvers(resolverNames, rCon, cnameRequest)
+			if cnameResponse != nil {
+				response.Answer = append(response.Answer, cnameResponse.Answer...)
+			}
+			if cnameResult != nil {
+				cnameResult.Put()
+			}
+		}
+	}
 
-	return engine.domainRuleMatchForLists(lists, domain)
+	return response
 }
 
-// handles recursive resolution of cnames
-func (engine *engine) handleCnameResolution(resolvers []string, rCon *resolver.RequestContext, originalRequest *dns.Msg, originalResponse *dns.Msg) *dns.Msg {
+// record the triggered policy on the result
+func applyPolicyResult(policy *rule.RpzMatch, result *resolver.ResolutionResult) {
+	if policy.Action == rule.RpzPassthru {
+		result.Match = rule.MatchAllow
+	} else {
+		result.Match = rule.MatchBlock
+		result.Blocked = true
+	}
+	result.MatchList = policy.List
+	result.MatchRule = policy.Rule()
+}
+
+// checks the target of every cname that was followed against the given lists so that a domain that is
+// cloaked by a cname to a blocked domain is also blocked, returns the target that was blocked
+func (engine *engine) cnameRuleMatchForLists(lists []*config.GudgeonList, targets []string) (rule.Match, *config.GudgeonList, string, string) {
+	if len(lists) < 1 {
+		return rule.MatchNone, nil, "", ""
+	}
+
+	for _, target := range targets {
+		match, list, ruleText := engine.domainRuleMatchForLists(lists, target)
+		if match == rule.MatchAllow {
+			// an allowed target ends the chain check
+			return rule.MatchNone, nil, "", ""
+		}
+		if match == rule.MatchBlock {
+			return match, list, ruleText, target
+		}
+	}
+
+	return rule.MatchNone, nil, "", ""
+}
+
+// handles recursive resolution of cnames, the targets of the cnames in the response and in the responses for
+// the targets are returned, in order, so that they can be checked against the lists
+func (engine *engine) handleCnameResolution(resolvers []string, rCon *resolver.RequestContext, originalRequest *dns.Msg, originalResponse *dns.Msg) (*dns.Msg, []string) {
 	// scope provided finding response
 	var response *dns.Msg
 
 	// guard
 	if originalResponse == nil || len(originalResponse.Answer) < 1 || originalRequest == nil || len(originalRequest.Question) < 1 {
-		return nil
+		return nil, nil
+	}
+
+	targets := make([]string, 0)
+	for _, answer := range originalResponse.Answer {
+		if cname, ok := answer.(*dns.CNAME); ok && cname != nil {
+			targets = append(targets, cname.Target)
+		}
 	}
 
 	// if the (first) response is a CNAME then repeat the question but with the cname instead
@@ -302,12 +552,10 @@ func (engine *engine) handleCnameResolution(resolvers []string, rCon *resolver.R
 		newName := answer.(*dns.CNAME).Target
 		cnameRequest.Question[0].Name = newName
 
-		var cnameResponse *dns.Msg
-		if len(rCon.Groups) > 0 {
-			cnameResponse, _, _ = engine.HandleWithGroups(rCon.Groups, rCon, cnameRequest)
-		} else {
-			cnameResponse, _, _ = engine.HandleWithResolvers(resolvers, rCon, cnameRequest)
-		}
+		// the target is resolved without the lists, whether it is blocked is decided for the original question
+		cnameResponse, _, _, cnameTargets := engine.resolve(resolvers, rCon, cnameRequest)
+		targets = append(targets, cnameTargets...)
+
 		if cnameResponse != nil && !util.IsEmptyResponse(cnameResponse) {
 			// use response
 			response = cnameResponse
@@ -320,7 +568,7 @@ func (engine *engine) handleCnameResolution(resolvers []string, rCon *resolver.R
 		}
 	}
 
-	return response
+	return response, targets
 }
 
 func (engine *engine) invalidRequestHandler(request *dns.Msg) (bool, *dns.Msg) {
@@ -361,9 +609,16 @@ func (engine *engine) invalidRequestHandler(request *dns.Msg) (bool, *dns.Msg) {
 }
 
 func (engine *engine) HandleWithResolvers(resolverNames []string, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
+	response, rCon, result, _ := engine.resolve(resolverNames, rCon, request)
+	return response, rCon, result
+}
+
+// resolves the request and also returns the targets of the cnames that were followed
+func (engine *engine) resolve(resolverNames []string, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult, []string) {
 	// scope provided finding response
 	var (
 		response *dns.Msg
+		targets  []string
 		err      error
 	)
 
@@ -372,7 +627,7 @@ func (engine *engine) HandleWithResolvers(resolverNames []string, rCon *resolver
 
 	// handle a request that might not be valid by returning it immediately
 	if valid, response := engine.invalidRequestHandler(request); !valid {
-		return response, rCon, result
+		return response, rCon, result, nil
 	}
 
 	// we are only doing resolution if there are resolvers to resolve against, otherwise
@@ -383,10 +638,26 @@ func (engine *engine) HandleWithResolvers(resolverNames []string, rCon *resolver
 		if err != nil {
 			log.Errorf("Could not resolve <%s>: %s", request.Question[0].Name, err)
 		} else {
-			cnameResponse := engine.handleCnameResolution(resolverNames, rCon, request, response)
+			var cnameResponse *dns.Msg
+			cnameResponse, targets = engine.handleCnameResolution(resolverNames, rCon, request, response)
 			if !util.IsEmptyResponse(cnameResponse) {
 				response = cnameResponse
 			}
+
+			// block the response if any of the addresses in the answer are in a blocked network
+			if engine.ips != nil && rCon != nil && len(rCon.Groups) > 0 && (result == nil || result.Match != rule.MatchBlock) {
+				if match, list, network := engine.ips.FindMatch(engine.listsForGroups(rCon.Groups), util.GetAnswerAddresses(response)); match == rule.MatchBlock {
+					if result == nil {
+						result = &resolver.ResolutionResult{}
+					}
+					result.Match = match
+					result.MatchList = list
+					result.MatchRule = network
+					response = new(dns.Msg)
+					response.SetReply(request)
+					response.Rcode = dns.RcodeNameError
+				}
+			}
 		}
 	}
 
@@ -420,7 +691,7 @@ func (engine *engine) HandleWithResolvers(resolverNames []string, rCon *resolver
 	}
 
 	// return result
-	return response, rCon, result
+	return response, rCon, result, targets
 }
 
 func (engine *engine) HandleWithGroups(groups []string, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
@@ -454,21 +725,65 @@ func (engine *engine) HandleWithGroups(groups []string, rCon *resolver.RequestCo
 		return response, rCon, result
 	}
 
-	// accumulate resolver names, up to a maximum of resolvers before having to append
-	resolverNames := make([]string, 0, len(engine.config.Resolvers))
-
 	// get the resolver names for the groups, in the given order
-	for _, groupName := range groups {
-		// get resolvers from group
-		group, found := engine.groups[groupName]
-		if !found {
-			continue
+	resolverNames := engine.resolversForGroups(groups)
+
+	// response policy zones are not evaluated for allowed domains
+	var lists []*config.GudgeonList
+	checkPolicy := engine.policies != nil && match != rule.MatchAllow
+	if checkPolicy {
+		lists = engine.listsForGroups(groups)
+		if policy := engine.policies.FindMatch(lists, rCon.Address, request.Question[0].Name); policy != nil {
+			applyPolicyResult(policy, result)
+			if policy.Action != rule.RpzPassthru {
+				return engine.policyResponse(policy, resolverNames, rCon, request), rCon, result
+			}
+			// passthru skips any further policy
+			checkPolicy = false
 		}
+	}
 
-		resolverNames = append(resolverNames, group.configGroup.Resolvers...)
+	response, rCon, resolverResult, targets := engine.resolve(resolverNames, rCon, request)
+	if resolverResult == nil {
+		resolverResult = &resolver.ResolutionResult{}
+	}
+
+	// keep the result of list matching (unless an address in the answer was blocked)
+	if result.Match != rule.MatchNone && resolverResult.Match != rule.MatchBlock {
+		resolverResult.Match = result.Match
+		resolverResult.MatchList = result.MatchList
+		resolverResult.MatchRule = result.MatchRule
+	}
+
+	// block the whole response if any cname that was followed is for a blocked domain, an allowed question is
+	// answered even when it is a cname for a blocked domain
+	if result.Match != rule.MatchAllow && resolverResult.Match != rule.MatchBlock {
+		if lists == nil {
+			lists = engine.listsForGroups(groups)
+		}
+		if cnameMatch, cnameList, cnameRule, target := engine.cnameRuleMatchForLists(lists, targets); cnameMatch == rule.MatchBlock {
+			resolverResult.Match = cnameMatch
+			resolverResult.MatchList = cnameList
+			resolverResult.MatchRule = cnameRule
+			resolverResult.Message = "cname " + target
+			response = new(dns.Msg)
+			response.SetReply(request)
+			response.Rcode = dns.RcodeNameError
+			return response, rCon, resolverResult
+		}
 	}
 
-	return engine.HandleWithResolvers(resolverNames, rCon, request)
+	// policies can be triggered by the addresses in the answer
+	if checkPolicy && resolverResult.Match != rule.MatchBlock {
+		if policy := engine.policies.FindResponseMatch(lists, util.GetAnswerAddresses(response)); policy != nil {
+			applyPolicyResult(policy, resolverResult)
+			if policy.Action != rule.RpzPassthru {
+				response = engine.policyResponse(policy, resolverNames, rCon, request)
+			}
+		}
+	}
+
+	return response, rCon, resolverResult
 }
 
 func (engine *engine) HandleWithConsumerName(consumerName string, rCon *resolver.RequestContext, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
@@ -494,15 +809,46 @@ func (engine *engine) HandleWithConsumer(consumer *consumer, rCon *resolver.Requ
 	}
 
 	// get groups for consumer
-	groups := engine.getGroups(consumer)
+	groups, schedule := engine.getScheduledGroups(consumer, time.Now())
+
+	var (
+		response *dns.Msg
+		result   *resolver.ResolutionResult
+	)
 
-	// return group response
-	response, rCon, result := engine.HandleWithGroups(groups, rCon, request)
+	// temporary overrides take the place of the lists for the groups
+	override := engine.findOverride(consumer, groups)
+	if override != nil && OverrideBlock == override.Action {
+		response = new(dns.Msg)
+		response.SetReply(request)
+		response.Rcode = dns.RcodeNameError
+		result = &resolver.ResolutionResult{
+			Match: rule.MatchBlock,
+		}
+	} else if override != nil {
+		// resolving without groups skips every list
+		if rCon == nil {
+			rCon = &resolver.RequestContext{}
+		}
+		rCon.Groups = []string{}
+		response, rCon, result = engine.HandleWithResolvers(engine.resolversForGroups(groups), rCon, request)
+		result.Match = rule.MatchAllow
+	} else {
+		// return group response
+		response, rCon, result = engine.HandleWithGroups(groups, rCon, request)
+	}
+	if override != nil {
+		result.MatchRule = override.String()
+		result.Override = override.String()
+	}
 
 	// update/set
 	if consumer != nil && consumer.configConsumer != nil {
 		result.Consumer = consumer.configConsumer.Name
 	}
+	if schedule != nil {
+		result.Schedule = schedule.Name
+	}
 
 	return response, rCon, result
 }
@@ -564,6 +910,9 @@ func (engine *engine) Reverse(address string) string {
 		allGroups = append(allGroups, g.configGroup.Name)
 	}
 	response, _, _ := engine.HandleWithGroups(allGroups, &resolver.RequestContext{Protocol: "udp"}, m)
+	if response == nil {
+		return ""
+	}
 
 	// look for first pointer
 	for _, answer := range response.Answer {
@@ -579,19 +928,36 @@ func (engine *engine) Reverse(address string) string {
 }
 
 // entry point for external handler
-func (engine *engine) Handle(address *net.IP, protocol string, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
-	// get consumer
-	consumer := engine.getConsumerForIP(address)
+func (engine *engine) Handle(address *net.IP, listener net.Addr, protocol string, forwarded *ForwardedClient, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
+	// get consumer, the interface the request was received on can decide the consumer
+	client := engine.getClient(address, forwarded)
+	consumer := engine.getConsumerForListener(client, engine.bindingFor(listener))
 
-	// create context
+	// create context, a forwarded request is for the client the forwarder identified
 	rCon := resolver.DefaultRequestContext()
 	rCon.Protocol = protocol
+	if client.ip != nil {
+		rCon.Address = client.ip
+		address = &client.ip
+	}
 
-	// get results
-	response, rCon, result := engine.HandleWithConsumer(consumer, rCon, request)
+	// get results, clients over the rate limit are answered without resolving the query
+	var (
+		response *dns.Msg
+		result   *resolver.ResolutionResult
+		record   = true
+	)
+	if limited, limitResponse, limitResult, limitRecord := engine.handleRateLimit(client, consumer, request); limited {
+		response, result, record = limitResponse, limitResult, limitRecord
+	} else {
+		response, rCon, result = engine.HandleWithConsumer(consumer, rCon, request)
+	}
+	if result != nil && client.mac != nil {
+		result.ClientMac = client.mac.String()
+	}
 
 	// log them if recorder is active
-	if engine.recorder != nil {
+	if engine.recorder != nil && record {
 		finishedTime := time.Now()
 		engine.recorder.queue(address, request, response, rCon, result, &finishedTime)
 	}
@@ -608,6 +974,64 @@ func (engine *engine) Handle(address *net.IP, protocol string, request *dns.Msg)
 	return response, rCon, result
 }
 
+// checks the client against the rate limit of the consumer, when the query is over the limit the response is
+// refused, truncated, or nil (dropped) and only the queries that change the state of the client are recorded
+func (engine *engine) handleRateLimit(client clientInfo, consumer *consumer, request *dns.Msg) (bool, *dns.Msg, *resolver.ResolutionResult, bool) {
+	if engine.rateLimiter == nil || request == nil {
+		return false, nil, nil, false
+	}
+	limit := engine.rateLimitFor(consumer)
+	if limit == nil {
+		return false, nil, nil, false
+	}
+
+	key := consumer.configConsumer.Name
+	if config.RateLimitPerAddress == limit.Per {
+		if client.ip == nil {
+			return false, nil, nil, false
+		}
+		key = client.ip.String()
+	}
+
+	state := engine.rateLimiter.check(limit, key, consumer.configConsumer.Name, time.Now())
+	if engine.metrics != nil {
+		engine.metrics.Get(BannedClients).Set(int64(state.bans))
+	}
+	if "" == state.state {
+		return false, nil, nil, false
+	}
+
+	// queries over the limit are counted here because most of them are not recorded
+	if engine.metrics != nil {
+		engine.metrics.Get(RateLimitedQueries).Inc(1)
+		engine.metrics.Get(RateLimitedLifetimeQueries).Inc(1)
+	}
+	if RateLimitBanned == state.state && state.record {
+		log.Warnf("Client %s (consumer '%s') stayed over the rate limit and is banned for %s", key, consumer.configConsumer.Name, limit.BanFor)
+	}
+
+	result := &resolver.ResolutionResult{
+		Consumer:  consumer.configConsumer.Name,
+		RateLimit: state.state,
+	}
+
+	var response *dns.Msg
+	switch limit.Response {
+	case config.RateLimitDrop:
+		// no response
+	case config.RateLimitTruncate:
+		response = new(dns.Msg)
+		response.SetReply(request)
+		response.Truncated = true
+	default:
+		response = new(dns.Msg)
+		response.SetReply(request)
+		response.Rcode = dns.RcodeRefused
+	}
+
+	return true, response, result, state.record
+}
+
 func (engine *engine) CacheSize() int64 {
 	if engine.resolvers != nil && engine.resolvers.Cache() != nil {
 		return int64(engine.resolvers.Cache().Size())
@@ -615,6 +1039,27 @@ func (engine *engine) CacheSize() int64 {
 	return 0
 }
 
+func (engine *engine) CacheEntries(partition string, domain string) []*cache.Entry {
+	if engine.resolvers != nil && engine.resolvers.Cache() != nil {
+		return engine.resolvers.Cache().Entries(partition, domain)
+	}
+	return []*cache.Entry{}
+}
+
+func (engine *engine) CacheFlushDomain(partition string, domain string) int {
+	if engine.resolvers != nil && engine.resolvers.Cache() != nil {
+		return engine.resolvers.Cache().FlushDomain(partition, domain)
+	}
+	return 0
+}
+
+func (engine *engine) CacheFlushPartition(partition string) int {
+	if engine.resolvers != nil && engine.resolvers.Cache() != nil {
+		return engine.resolvers.Cache().FlushPartition(partition)
+	}
+	return 0
+}
+
 func (engine *engine) Metrics() Metrics {
 	return engine.metrics
 }
@@ -631,12 +1076,35 @@ func (engine *engine) Close() {
 			handle.Close()
 		}
 	}
+	// stop periodic snapshots and save the cache one last time before the resolvers (and cache) are closed
+	if engine.cacheSnapshotDone != nil {
+		engine.cacheSnapshotDone <- true
+		<-engine.cacheSnapshotDone
+		close(engine.cacheSnapshotDone)
+		engine.cacheSnapshotDone = nil
+		engine.saveCache()
+	}
+	// stop removing expired custom rules
+	if engine.custom != nil {
+		engine.custom.stop()
+	}
 	// close sources
 	log.Debugf("Closing resolvers...")
 	engine.resolvers.Close()
 	// close rule store
 	log.Debugf("Closing database store...")
 	engine.store.Close()
+	if engine.policies != nil {
+		engine.policies.Close()
+	}
+	if engine.ips != nil {
+		engine.ips.Close()
+	}
+	// remove the session directory now that nothing is using it
+	if "" != engine.session {
+		_ = os.RemoveAll(engine.Root())
+		engine.sessionLock.Remove()
+	}
 	// clear references
 	engine.db = nil
 	engine.qlog = nil
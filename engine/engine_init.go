@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/GeertJohan/go.rice"
 	"github.com/google/uuid"
@@ -131,6 +132,15 @@ func (engine *engine) bootstrap() error {
 	if err != nil {
 		log.Errorf("Could not create session directory path: %s", err)
 	}
+	// sessions without a running owner were left behind by a run that did not stop cleanly
+	if removed := util.ClearUnlocked(conf.SessionRoot()); removed > 0 {
+		log.Infof("Removed %d stale session directories", removed)
+	}
+	// the session is owned by this engine before it exists
+	engine.sessionLock, err = util.CreateLock(engine.Root() + util.LockSuffix)
+	if err != nil {
+		log.Errorf("Could not lock session directory: %s", err)
+	}
 	err = os.MkdirAll(engine.Root(), os.ModePerm)
 	if err != nil {
 		log.Errorf("Could not create engine root directory path: %s", err)
@@ -184,87 +194,33 @@ func (engine *engine) bootstrap() error {
 		}
 	}
 
-	// configure resolvers
-	engine.resolvers = resolver.NewResolverMap(conf, conf.Resolvers)
-
-	// use length of working groups to make list of active groups
-	groups := make([]*group, len(conf.Groups))
-	groupMap := make(map[string]*group)
-
-	// process groups
-	for idx, configGroup := range conf.Groups {
-		// create active group for group name
-		engineGroup := &group{
-			engine:      engine,
-			configGroup: configGroup,
-			lists:       assignedLists(configGroup.Lists, configGroup.SafeTags(), conf.Lists),
-		}
-
-		// add created engine group to list of groups
-		groups[idx] = engineGroup
+	// restore temporary overrides that have not expired
+	engine.overrides = newOverrides(engine.OverridePath())
 
-		// set default group on engine if found
-		if "default" == configGroup.Name {
-			engine.defaultGroup = engineGroup
-		}
-
-		// save group to group map for later reference
-		if "" != configGroup.Name {
-			groupMap[configGroup.Name] = engineGroup
+	// only keep rate limit buckets when a limit is configured
+	rateLimited := *conf.Network.RateLimit.Enabled
+	for _, consumer := range conf.Consumers {
+		if consumer != nil && consumer.RateLimit != nil && *consumer.RateLimit.Enabled {
+			rateLimited = true
 		}
 	}
+	if rateLimited {
+		engine.rateLimiter = newRateLimiter()
+	}
 
-	// attach groups to consumers
-	consumers := make([]*consumer, len(conf.Consumers))
-	consumerMap := make(map[string]*consumer)
-	for index, configConsumer := range conf.Consumers {
-		// create an active consumer
-		consumer := &consumer{
-			engine:         engine,
-			groupNames:     make([]string, 0),
-			resolverNames:  make([]string, 0),
-			configConsumer: configConsumer,
-			lists:          make([]*config.GudgeonList, 0),
-		}
-
-		// set as default consumer
-		if strings.EqualFold(configConsumer.Name, "default") {
-			engine.defaultConsumer = consumer
-		}
-
-		// link consumer to group when the consumer's group elements contains the group name
-		for _, group := range groups {
-			if util.StringIn(group.configGroup.Name, configConsumer.Groups) {
-				consumer.groupNames = append(consumer.groupNames, group.configGroup.Name)
-
-				// add resolvers from group too
-				if len(group.configGroup.Resolvers) > 0 {
-					consumer.resolverNames = append(consumer.resolverNames, group.configGroup.Resolvers...)
-				}
-
-				// add lists if they aren't already in the consumer lists
-				for _, newList := range group.lists {
-					listFound := false
-					for _, currentList := range consumer.lists {
-						if currentList.CanonicalName() == newList.CanonicalName() {
-							listFound = true
-							break
-						}
-					}
-					if !listFound {
-						consumer.lists = append(consumer.lists, newList)
-					}
-				}
-			}
-		}
+	// configure resolvers
+	engine.resolvers = resolver.NewResolverMap(conf, conf.Resolvers)
 
-		// add active consumer to list
-		consumers[index] = consumer
-		if configConsumer.Name != "" {
-			consumerMap[configConsumer.Name] = consumer
-		}
+	// restore the cache from the last snapshot and start snapshotting periodically
+	if *conf.Storage.CacheEnabled && *conf.Storage.CachePersist {
+		engine.loadCache()
+		engine.cacheSnapshotDone = make(chan bool)
+		go engine.cacheSnapshotWorker()
 	}
 
+	// build the groups and the consumers that use them
+	groupMap, consumers, consumerMap := engine.createConsumers(conf)
+
 	// load lists (from remote urls)
 	for _, list := range conf.Lists {
 		// get list path
@@ -296,6 +252,17 @@ func (engine *engine) bootstrap() error {
 	var listCounts []uint64
 	engine.store, listCounts = rule.CreateStore(engine.Root(), conf)
 
+	// response policy zones are loaded into their own store
+	var policyCounts []uint64
+	engine.policies, policyCounts = rule.CreateRpzStore(conf)
+
+	// ip lists are loaded into their own store
+	var ipCounts []uint64
+	engine.ips, ipCounts = rule.CreateIpStore(conf)
+	for idx := range listCounts {
+		listCounts[idx] += policyCounts[idx] + ipCounts[idx]
+	}
+
 	// use/set metrics if they are enabled
 	if engine.metrics != nil {
 		metrics := engine.metrics
@@ -369,6 +336,19 @@ func (engine *engine) bootstrap() error {
 	engine.groups = groupMap
 	engine.consumers = consumers
 	engine.consumerMap = consumerMap
+	engine.consumerMatcher = newConsumerMatcher(consumers)
+	engine.bindings = newInterfaceBindings(conf.Network.Interfaces, consumerMap)
+	if engine.consumerMatcher.matchesHostname() && (engine.recorder == nil || !*conf.QueryLog.ReverseLookup) {
+		log.Warnf("Consumer hostname matches use the names found by the query log and will not match while reverse lookups are disabled")
+	}
+	if engine.consumerMatcher.matchesMAC() || *conf.QueryLog.MacLookup {
+		engine.neighbors = newNeighbors()
+	}
+
+	// load the custom rules for each group into the store and remove them as they expire
+	engine.custom = newCustomRules(engine, engine.CustomRulesPath())
+	engine.custom.applyAll()
+	engine.custom.start()
 
 	// try and free memory
 	debug.FreeOSMemory()
@@ -376,3 +356,134 @@ func (engine *engine) bootstrap() error {
 	// done bootstrapping without errors
 	return nil
 }
+
+// create the active groups from the configuration and attach them to the consumers that use them, the default group
+// and consumer are set on the engine
+func (engine *engine) createConsumers(conf *config.GudgeonConfig) (map[string]*group, []*consumer, map[string]*consumer) {
+	// use length of working groups to make list of active groups
+	groups := make([]*group, len(conf.Groups))
+	groupMap := make(map[string]*group)
+
+	// process groups
+	for idx, configGroup := range conf.Groups {
+		// create active group for group name
+		engineGroup := &group{
+			engine:      engine,
+			configGroup: configGroup,
+			lists:       assignedLists(configGroup.Lists, configGroup.SafeTags(), conf.Lists),
+		}
+
+		// the custom lists of the group are checked along with the assigned lists
+		for _, listType := range []config.ListType{config.ALLOW, config.BLOCK} {
+			if customList := conf.GetCustomList(configGroup.Name, listType); customList != nil {
+				engineGroup.lists = append(engineGroup.lists, customList)
+			}
+		}
+
+		// add created engine group to list of groups
+		groups[idx] = engineGroup
+
+		// set default group on engine if found
+		if "default" == configGroup.Name {
+			engine.defaultGroup = engineGroup
+		}
+
+		// save group to group map for later reference
+		if "" != configGroup.Name {
+			groupMap[configGroup.Name] = engineGroup
+		}
+	}
+
+	// attach groups to consumers
+	consumers := make([]*consumer, len(conf.Consumers))
+	consumerMap := make(map[string]*consumer)
+	for index, configConsumer := range conf.Consumers {
+		// create an active consumer
+		consumer := &consumer{
+			engine:         engine,
+			groupNames:     make([]string, 0),
+			resolverNames:  make([]string, 0),
+			configConsumer: configConsumer,
+			lists:          make([]*config.GudgeonList, 0),
+		}
+
+		// set as default consumer
+		if strings.EqualFold(configConsumer.Name, "default") {
+			engine.defaultConsumer = consumer
+		}
+
+		// link consumer to group when the consumer's group elements contains the group name
+		for _, group := range groups {
+			if util.StringIn(group.configGroup.Name, configConsumer.Groups) {
+				consumer.groupNames = append(consumer.groupNames, group.configGroup.Name)
+
+				// add resolvers from group too
+				if len(group.configGroup.Resolvers) > 0 {
+					consumer.resolverNames = append(consumer.resolverNames, group.configGroup.Resolvers...)
+				}
+
+				// add lists if they aren't already in the consumer lists
+				for _, newList := range group.lists {
+					listFound := false
+					for _, currentList := range consumer.lists {
+						if currentList.CanonicalName() == newList.CanonicalName() {
+							listFound = true
+							break
+						}
+					}
+					if !listFound {
+						consumer.lists = append(consumer.lists, newList)
+					}
+				}
+			}
+		}
+
+		// add active consumer to list
+		consumers[index] = consumer
+		if configConsumer.Name != "" {
+			consumerMap[configConsumer.Name] = consumer
+		}
+	}
+
+	return groupMap, consumers, consumerMap
+}
+
+// restore unexpired entries from the cache snapshot for resolvers that have not changed
+func (engine *engine) loadCache() {
+	restored, err := engine.resolvers.LoadCache(engine.CacheSnapshotPath())
+	if err != nil {
+		log.Errorf("Could not restore cache: %s", err)
+		return
+	}
+	log.Infof("Restored %d cache entries", restored)
+}
+
+// write the current cache to the snapshot file
+func (engine *engine) saveCache() {
+	saved, err := engine.resolvers.SaveCache(engine.CacheSnapshotPath())
+	if err != nil {
+		log.Errorf("Could not save cache: %s", err)
+		return
+	}
+	log.Debugf("Saved %d cache entries", saved)
+}
+
+// periodically snapshot the cache until signaled to stop
+func (engine *engine) cacheSnapshotWorker() {
+	interval, err := util.ParseDuration(engine.config.Storage.CacheInterval)
+	if err != nil {
+		interval = 5 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			engine.saveCache()
+		case <-engine.cacheSnapshotDone:
+			engine.cacheSnapshotDone <- true
+			return
+		}
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/resolver"
 	"github.com/chrisruffalo/gudgeon/rule"
 	"github.com/chrisruffalo/gudgeon/testutil"
 	"github.com/chrisruffalo/gudgeon/util"
@@ -133,3 +137,266 @@ func TestReverseLookup(t *testing.T) {
 
 	engine.Shutdown()
 }
+
+func TestResponsePolicyZone(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/rpz.yml")
+	defer os.RemoveAll(config.Home)
+
+	engine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer engine.Shutdown()
+
+	data := []struct {
+		client  string
+		domain  string
+		qtype   uint16
+		dropped bool
+		rcode   int
+		answers []string
+		blocked bool
+		matched bool
+	}{
+		{"192.168.0.1", "good-answer.example.", dns.TypeA, false, dns.RcodeSuccess, []string{"192.0.2.2"}, false, false},
+		{"192.168.0.1", "nxdomain.example.", dns.TypeA, false, dns.RcodeNameError, []string{}, true, true},
+		{"192.168.0.1", "nodata.example.", dns.TypeA, false, dns.RcodeSuccess, []string{}, true, true},
+		{"192.168.0.1", "drop.example.", dns.TypeA, true, 0, nil, true, true},
+		{"192.168.0.1", "passthru.example.", dns.TypeA, false, dns.RcodeSuccess, []string{"192.0.2.4"}, false, true},
+		{"192.168.0.1", "local.example.", dns.TypeA, false, dns.RcodeSuccess, []string{"10.0.0.1"}, true, true},
+		{"192.168.0.1", "local.example.", dns.TypeAAAA, false, dns.RcodeSuccess, []string{"fd00::1"}, true, true},
+		{"192.168.0.1", "garden.example.", dns.TypeA, false, dns.RcodeSuccess, []string{"192.0.2.3"}, true, true},
+		{"192.168.0.1", "bad-answer.example.", dns.TypeA, false, dns.RcodeNameError, []string{}, true, true},
+		{"192.168.0.5", "good-answer.example.", dns.TypeA, true, 0, nil, true, true},
+	}
+
+	for _, d := range data {
+		request := new(dns.Msg)
+		request.SetQuestion(d.domain, d.qtype)
+
+		rCon := resolver.DefaultRequestContext()
+		rCon.Address = net.ParseIP(d.client)
+		response, _, result := engine.HandleWithGroups([]string{"default"}, rCon, request)
+
+		if d.dropped {
+			if response != nil {
+				t.Errorf("Expected request for '%s' from %s to be dropped", d.domain, d.client)
+			}
+		} else if response == nil {
+			t.Errorf("Expected a response for '%s' from %s", d.domain, d.client)
+			continue
+		} else {
+			if response.Rcode != d.rcode {
+				t.Errorf("Expected rcode %d for '%s' but got %d", d.rcode, d.domain, response.Rcode)
+			}
+			values := make([]string, 0)
+			for _, address := range util.GetAnswerAddresses(response) {
+				values = append(values, address.String())
+			}
+			if len(values) != len(d.answers) {
+				t.Errorf("Expected answers %v for '%s' but got %v", d.answers, d.domain, values)
+			} else {
+				for idx := range values {
+					if values[idx] != d.answers[idx] {
+						t.Errorf("Expected answers %v for '%s' but got %v", d.answers, d.domain, values)
+						break
+					}
+				}
+			}
+		}
+
+		if result == nil {
+			t.Errorf("Expected a result for '%s'", d.domain)
+			continue
+		}
+		if result.Blocked != d.blocked {
+			t.Errorf("Expected blocked=%t for '%s' but got %t", d.blocked, d.domain, result.Blocked)
+		}
+		if d.matched && (result.MatchList == nil || result.MatchList.ShortName() != "policy") {
+			t.Errorf("Expected match from policy list for '%s' but got %v (%s)", d.domain, result.MatchList, result.MatchRule)
+		}
+	}
+}
+
+func TestCnameCloaking(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/cloak.yml")
+	defer os.RemoveAll(config.Home)
+
+	engine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer engine.Shutdown()
+
+	data := []struct {
+		domain  string
+		rcode   int
+		answers []string
+		list    string
+		rule    string
+	}{
+		{"www.shop.example.", dns.RcodeSuccess, []string{"192.0.2.10"}, "", ""},
+		{"metrics.shop.example.", dns.RcodeNameError, []string{}, "trackers", "eulerian.net"},
+		{"allowed.shop.example.", dns.RcodeSuccess, []string{"192.0.2.21"}, "", ""},
+		// an allowed question is answered even though its target is blocked
+		{"partner.shop.example.", dns.RcodeSuccess, []string{"192.0.2.20"}, "allowed", "partner.shop.example"},
+	}
+
+	for _, d := range data {
+		request := new(dns.Msg)
+		request.SetQuestion(d.domain, dns.TypeA)
+
+		response, _, result := engine.HandleWithGroups([]string{"default"}, resolver.DefaultRequestContext(), request)
+		if response == nil {
+			t.Errorf("Expected a response for '%s'", d.domain)
+			continue
+		}
+		if response.Rcode != d.rcode {
+			t.Errorf("Expected rcode %d for '%s' but got %d", d.rcode, d.domain, response.Rcode)
+		}
+		values := make([]string, 0)
+		for _, address := range util.GetAnswerAddresses(response) {
+			values = append(values, address.String())
+		}
+		if len(values) != len(d.answers) || (len(values) > 0 && values[0] != d.answers[0]) {
+			t.Errorf("Expected answers %v for '%s' but got %v", d.answers, d.domain, values)
+		}
+
+		if "" == d.list {
+			if result != nil && result.MatchList != nil {
+				t.Errorf("Expected no list match for '%s' but got %s (%s)", d.domain, result.MatchList.ShortName(), result.MatchRule)
+			}
+			continue
+		}
+		if result == nil || result.MatchList == nil || result.MatchList.ShortName() != d.list || result.MatchRule != d.rule {
+			t.Errorf("Expected '%s' to match rule '%s' from list %s but got %v", d.domain, d.rule, d.list, result)
+		}
+	}
+}
+
+func TestIpListAnswers(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/iplist.yml")
+	defer os.RemoveAll(config.Home)
+
+	engine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer engine.Shutdown()
+
+	data := []struct {
+		domain string
+		rcode  int
+		rule   string
+	}{
+		{"good.example.", dns.RcodeSuccess, ""},
+		{"bad.example.", dns.RcodeNameError, "203.0.113.0/24"},
+		{"bogon.example.", dns.RcodeNameError, "0.0.0.0/8"},
+	}
+
+	for _, d := range data {
+		request := new(dns.Msg)
+		request.SetQuestion(d.domain, dns.TypeA)
+
+		response, _, result := engine.HandleWithGroups([]string{"default"}, resolver.DefaultRequestContext(), request)
+		if response == nil || result == nil {
+			t.Errorf("Expected a response and result for '%s'", d.domain)
+			continue
+		}
+		if response.Rcode != d.rcode {
+			t.Errorf("Expected rcode %d for '%s' but got %d", d.rcode, d.domain, response.Rcode)
+		}
+		if "" == d.rule {
+			if result.Match != rule.MatchNone {
+				t.Errorf("Expected no match for '%s' but got %s", d.domain, result.MatchRule)
+			}
+			continue
+		}
+		if result.Match != rule.MatchBlock || result.MatchList == nil || result.MatchList.ShortName() != "bad_networks" || result.MatchRule != d.rule {
+			t.Errorf("Expected '%s' to be blocked by '%s' but got %v", d.domain, d.rule, result)
+		}
+	}
+}
+
+func TestConsumerSchedules(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/schedule.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+
+	data := []struct {
+		ip       string
+		time     time.Time
+		groups   []string
+		schedule string
+	}{
+		// 2026-10-18 is a sunday
+		{"192.168.0.10", time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), []string{"default"}, ""},
+		{"192.168.0.10", time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC), []string{"default", "strict"}, "bedtime"},
+		{"192.168.0.10", time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), []string{"default", "strict"}, "bedtime"},
+		{"192.168.0.10", time.Date(2026, 10, 24, 12, 0, 0, 0, time.UTC), []string{"strict"}, "grounded"},
+		{"192.168.0.11", time.Date(2026, 10, 18, 22, 0, 0, 0, time.UTC), []string{"default"}, ""},
+	}
+
+	for _, d := range data {
+		consumer := testEngine.(*engine).getConsumerForIP(parseIP(d.ip))
+		groups, schedule := testEngine.(*engine).getScheduledGroups(consumer, d.time)
+		if len(groups) != len(d.groups) {
+			t.Errorf("Expected groups %v for %s at %s but got %v", d.groups, d.ip, d.time, groups)
+		} else {
+			for idx := range groups {
+				if groups[idx] != d.groups[idx] {
+					t.Errorf("Expected groups %v for %s at %s but got %v", d.groups, d.ip, d.time, groups)
+					break
+				}
+			}
+		}
+		if "" == d.schedule && schedule != nil {
+			t.Errorf("Expected no schedule for %s at %s but got %s", d.ip, d.time, schedule.Name)
+		} else if "" != d.schedule && (schedule == nil || schedule.Name != d.schedule) {
+			t.Errorf("Expected schedule %s for %s at %s but got %v", d.schedule, d.ip, d.time, schedule)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/override.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+
+	// custom rules are explained along with the configured lists
+	if _, err := testEngine.AddCustomRule("default", "allow", "*.blocked.example", 0); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+
+	explanation := testEngine.ExplainWithConsumerName("kids", "sub.blocked.example.")
+	if explanation.Match != rule.MatchAllow || explanation.Rule != "*.blocked.example" {
+		t.Errorf("Expected allow by '*.blocked.example' but got %d by '%s'", explanation.Match, explanation.Rule)
+	}
+	if len(explanation.Matches) != 2 {
+		t.Errorf("Expected 2 matching rules but got %d", len(explanation.Matches))
+	}
+	for _, match := range explanation.Matches {
+		if match.Rule == "blocked.example" && (match.Kind != rule.MatchKindParent || match.ListType != "block") {
+			t.Errorf("Expected a parent match from a block list but got %s from a %s list", match.Kind, match.ListType)
+		}
+	}
+
+	if explanation := testEngine.ExplainWithGroups([]string{"nogroup"}, "blocked.example"); explanation.Match != rule.MatchNone || len(explanation.Matches) != 0 {
+		t.Errorf("Expected no matches without lists")
+	}
+}
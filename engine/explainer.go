@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/rule"
+)
+
+// explains the rules that match a domain without starting an engine, nothing is written to the gudgeon home so it
+// can be used while gudgeon is running
+type Explainer interface {
+	ExplainWithConsumerName(consumerName string, domain string) *rule.Explanation
+	ExplainWithGroups(groups []string, domain string) *rule.Explanation
+	Close()
+}
+
+type explainer struct {
+	engine *engine
+}
+
+// load the lists that are already on disk and the custom rules into memory, lists are not downloaded and no
+// session, rule database, custom rules, or overrides are created or changed
+func NewExplainer(conf *config.GudgeonConfig) Explainer {
+	engine := &engine{
+		config: conf,
+	}
+	engine.groups, engine.consumers, engine.consumerMap = engine.createConsumers(conf)
+	engine.store, _ = rule.CreateMemoryStore(conf)
+
+	// the custom rules are only read, they are saved when they are changed
+	engine.custom = newCustomRules(engine, engine.CustomRulesPath())
+	engine.custom.applyAll()
+
+	return &explainer{engine: engine}
+}
+
+func (explainer *explainer) ExplainWithConsumerName(consumerName string, domain string) *rule.Explanation {
+	return explainer.engine.ExplainWithConsumerName(consumerName, domain)
+}
+
+func (explainer *explainer) ExplainWithGroups(groups []string, domain string) *rule.Explanation {
+	return explainer.engine.ExplainWithGroups(groups, domain)
+}
+
+func (explainer *explainer) Close() {
+	explainer.engine.store.Close()
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/rule"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+// every file under the directory with its size and modification time
+func snapshotFiles(t *testing.T, dir string) string {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Could not list files: %s", err)
+	}
+	return strings.Join(files, "\n")
+}
+
+func TestExplainerLeavesHomeAlone(t *testing.T) {
+	conf := testutil.TestConf(t, "testdata/override.yml")
+	defer os.RemoveAll(conf.Home)
+
+	// an engine leaves behind the rules it keeps between runs and the custom rules
+	testEngine, err := NewEngine(conf)
+	if err != nil {
+		t.Fatalf("Could not create engine: %s", err)
+	}
+	if _, err := testEngine.AddCustomRule("default", "block", "good.example", 0); err != nil {
+		t.Errorf("Could not add rule: %s", err)
+	}
+	testEngine.Shutdown()
+
+	before := snapshotFiles(t, conf.Home)
+
+	explainer := NewExplainer(conf)
+	expected := []struct {
+		domain string
+		match  rule.Match
+		rule   string
+	}{
+		{"blocked.example", rule.MatchBlock, "blocked.example"},
+		{"good.example", rule.MatchBlock, "good.example"},
+		{"other.example", rule.MatchNone, ""},
+	}
+	for _, e := range expected {
+		explanation := explainer.ExplainWithConsumerName("kids", e.domain)
+		if e.match != explanation.Match || e.rule != explanation.Rule {
+			t.Errorf("Expected match %d by '%s' for '%s' but got %d by '%s'", e.match, e.rule, e.domain, explanation.Match, explanation.Rule)
+		}
+	}
+	if explanation := explainer.ExplainWithGroups([]string{"nogroup"}, "blocked.example"); rule.MatchNone != explanation.Match {
+		t.Errorf("Expected no match for a group without lists")
+	}
+	explainer.Close()
+
+	if after := snapshotFiles(t, conf.Home); before != after {
+		t.Errorf("Expected explaining to leave the home directory alone but it changed from:\n%s\nto:\n%s", before, after)
+	}
+}
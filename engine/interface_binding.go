@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"net"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+// the consumer of the clients of a listening interface
+type interfaceBinding struct {
+	// nil when the interface listens on every address
+	ip       net.IP
+	port     int
+	consumer *consumer
+	override bool
+}
+
+func newInterfaceBindings(interfaces []*config.GudgeonInterface, consumerMap map[string]*consumer) []*interfaceBinding {
+	bindings := make([]*interfaceBinding, 0)
+	for _, iface := range interfaces {
+		if iface == nil || "" == iface.Consumer {
+			continue
+		}
+		consumer, found := consumerMap[iface.Consumer]
+		if !found {
+			continue
+		}
+		ip := net.ParseIP(iface.IP)
+		if ip != nil && ip.IsUnspecified() {
+			ip = nil
+		}
+		bindings = append(bindings, &interfaceBinding{
+			ip:       ip,
+			port:     iface.Port,
+			consumer: consumer,
+			override: iface.Override,
+		})
+	}
+	return bindings
+}
+
+// the binding for the local address that a request was received on, an interface on a single address is
+// used before an interface that listens on every address
+func (engine *engine) bindingFor(listener net.Addr) *interfaceBinding {
+	if len(engine.bindings) < 1 || listener == nil {
+		return nil
+	}
+
+	var ip net.IP
+	var port int
+	switch addr := listener.(type) {
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+	default:
+		return nil
+	}
+
+	var wildcard *interfaceBinding
+	for _, binding := range engine.bindings {
+		if binding.port != port {
+			continue
+		}
+		if binding.ip == nil {
+			if wildcard == nil {
+				wildcard = binding
+			}
+		} else if binding.ip.Equal(ip) {
+			return binding
+		}
+	}
+	return wildcard
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestInterfaceBindings(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/interface.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+
+	data := []struct {
+		client   string
+		listener net.Addr
+		consumer string
+	}{
+		// the groups of the interface are a consumer of their own
+		{"10.0.99.20", &net.UDPAddr{IP: net.ParseIP("10.0.99.1"), Port: 53}, "interface-10.0.99.1-53"},
+		// a matched consumer is used before the consumer of the interface
+		{"10.0.99.50", &net.UDPAddr{IP: net.ParseIP("10.0.99.1"), Port: 53}, "laptop"},
+		// unless the interface overrides matching
+		{"10.0.99.50", &net.TCPAddr{IP: net.ParseIP("10.0.1.1"), Port: 53}, "home"},
+		// an interface on every address matches the port
+		{"10.0.2.20", &net.UDPAddr{IP: net.ParseIP("10.0.2.1"), Port: 5353}, "home"},
+		{"10.0.2.20", &net.UDPAddr{IP: net.ParseIP("10.0.2.1"), Port: 53}, "default"},
+		{"10.0.2.20", nil, "default"},
+	}
+	for _, d := range data {
+		client := testEngine.(*engine).getClient(parseIP(d.client), nil)
+		consumer := testEngine.(*engine).getConsumerForListener(client, testEngine.(*engine).bindingFor(d.listener))
+		name := ""
+		if consumer != nil && consumer.configConsumer != nil {
+			name = consumer.configConsumer.Name
+		}
+		if name != d.consumer {
+			t.Errorf("Expected consumer '%s' for %s on %v but got '%s'", d.consumer, d.client, d.listener, name)
+		}
+	}
+}
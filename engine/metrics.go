@@ -38,6 +38,15 @@ const (
 	BlocksPerSecond        = "session-blocks-ps"
 	QueryTime              = "query-time"
 	QueryTimeAvg           = "query-time-avg"
+	// rate limiting
+	RateLimitedQueries         = "rate-limited-session-queries"
+	RateLimitedLifetimeQueries = "rate-limited-lifetime-queries"
+	BannedClients              = "banned-clients"
+	// response rate limiting, counted in log only mode as well
+	RRLDroppedResponses = "rrl-dropped-responses"
+	RRLSlippedResponses = "rrl-slipped-responses"
+	// requests from networks that are not allowed to use a listener
+	DeniedQueries = "denied-queries"
 	// cache entries
 	CurrentCacheEntries = "cache-entries"
 	// runtime metrics
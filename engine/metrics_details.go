@@ -13,22 +13,28 @@ type TopInfo struct {
 	Count uint64
 }
 
+// the client of a buffer entry is the hardware address when it is known
+const (
+	clientKey       = "CASE WHEN ClientMac != '' THEN ClientMac ELSE Address END"
+	bufferClientKey = "CASE WHEN b.ClientMac != '' THEN b.ClientMac ELSE b.Address END"
+)
+
 // statements that flush query log data into various metrics collection points/tables
 var _stmts = []string{
 	// insert into list metrics when a list has a match, on conflict update by one
 	"INSERT INTO list_metrics (Name, ShortName, Hits) SELECT MatchList, MatchListShort, 1 FROM buffer WHERE MatchListShort != '' ON CONFLICT(ShortName) DO UPDATE SET Hits = Hits + 1",
 	// insert into rule metrics when a rule is matched, on conflict update by one
 	"INSERT INTO rule_metrics (ListId, Rule, Hits) SELECT l.Id, b.MatchRule, 1 FROM buffer b JOIN list_metrics l ON b.MatchListShort = l.ShortName WHERE b.MatchRule != '' ON CONFLICT (ListId, Rule) DO UPDATE SET Hits = Hits + 1",
-	// insert into client metrics, on conflict update by one
-	"INSERT INTO client_metrics (Address, Count) SELECT Address, 1 FROM buffer WHERE true ON CONFLICT (Address) DO UPDATE SET Count = Count + 1",
+	// insert into client metrics, on conflict update by one, clients with a hardware address are counted by it so that they keep their count when their address changes
+	"INSERT INTO client_metrics (Address, Count) SELECT " + clientKey + ", 1 FROM buffer WHERE true ON CONFLICT (Address) DO UPDATE SET Count = Count + 1",
 	// insert into domain metrics, on conflict update by one
 	"INSERT INTO domain_metrics (DomainName, Count) SELECT RequestDomain, 1 FROM buffer WHERE true ON CONFLICT (DomainName) DO UPDATE SET Count = Count + 1",
 	// insert into query metrics, on conflict update by one
 	"INSERT INTO query_metrics (QueryType, Count) SELECT RequestType, 1 FROM buffer WHERE true ON CONFLICT (QueryType) DO UPDATE SET Count = Count + 1",
 	// insert new entries into the client_name table
-	"INSERT INTO client_names (Address, ClientName) SELECT DISTINCT Address, ClientName FROM buffer WHERE ClientName != '' ON CONFLICT(Address) DO NOTHING",
+	"INSERT INTO client_names (Address, ClientName) SELECT DISTINCT " + clientKey + ", ClientName FROM buffer WHERE ClientName != '' ON CONFLICT(Address) DO NOTHING",
 	//  update the client names in client name with the longest client name in the buffer or that already is in the client name field
-	"UPDATE client_names AS target SET (ClientName) = (SELECT ClientName FROM (SELECT b.ClientName, length(b.ClientName) as Length FROM buffer b WHERE b.ClientName != '' AND b.Address = target.Address UNION SELECT cn.ClientName, length(cn.ClientName) as Length FROM client_names cn WHERE cn.ClientName != '' AND cn.Address = target.Address ORDER BY Length DESC LIMIT 1)) WHERE true",
+	"UPDATE client_names AS target SET (ClientName) = (SELECT ClientName FROM (SELECT b.ClientName, length(b.ClientName) as Length FROM buffer b WHERE b.ClientName != '' AND " + bufferClientKey + " = target.Address UNION SELECT cn.ClientName, length(cn.ClientName) as Length FROM client_names cn WHERE cn.ClientName != '' AND cn.Address = target.Address ORDER BY Length DESC LIMIT 1)) WHERE true",
 }
 
 func (metrics *metrics) flush(tx *sql.Tx) {
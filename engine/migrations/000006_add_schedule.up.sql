@@ -0,0 +1,7 @@
+-- add column for the active consumer schedule
+ALTER TABLE buffer ADD COLUMN Schedule TEXT DEFAULT '';
+UPDATE buffer SET Schedule = '' WHERE Schedule = null;
+
+-- add query log column for the active consumer schedule
+ALTER TABLE qlog ADD COLUMN Schedule TEXT DEFAULT '';
+UPDATE qlog SET Schedule = '' WHERE Schedule = null;
@@ -0,0 +1,7 @@
+-- add column for the temporary override that decided the match
+ALTER TABLE buffer ADD COLUMN Override TEXT DEFAULT '';
+UPDATE buffer SET Override = '' WHERE Override = null;
+
+-- add query log column for the temporary override that decided the match
+ALTER TABLE qlog ADD COLUMN Override TEXT DEFAULT '';
+UPDATE qlog SET Override = '' WHERE Override = null;
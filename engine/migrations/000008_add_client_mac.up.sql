@@ -0,0 +1,7 @@
+-- add column for the hardware address of the client
+ALTER TABLE buffer ADD COLUMN ClientMac TEXT DEFAULT '';
+UPDATE buffer SET ClientMac = '' WHERE ClientMac = null;
+
+-- add query log column for the hardware address of the client
+ALTER TABLE qlog ADD COLUMN ClientMac TEXT DEFAULT '';
+UPDATE qlog SET ClientMac = '' WHERE ClientMac = null;
@@ -0,0 +1,7 @@
+-- add column for the rate limit state of the client
+ALTER TABLE buffer ADD COLUMN RateLimit TEXT DEFAULT '';
+UPDATE buffer SET RateLimit = '' WHERE RateLimit = null;
+
+-- add query log column for the rate limit state of the client
+ALTER TABLE qlog ADD COLUMN RateLimit TEXT DEFAULT '';
+UPDATE qlog SET RateLimit = '' WHERE RateLimit = null;
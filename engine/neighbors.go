@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// where the kernel lists the ipv4 neighbours (arp table)
+	arpTablePath = "/proc/net/arp"
+	// how long the table is used before it is read again
+	neighborRefresh = 30 * time.Second
+	// an address that is not in the table is looked for again after this long
+	neighborMissRefresh = 5 * time.Second
+)
+
+// the hardware addresses of the clients on the local networks, read from the arp table for ipv4 and from
+// the kernel over netlink for ipv6 (ndp)
+type neighbors struct {
+	arpPath string
+	netlink bool
+	// the addresses of the local interfaces, only clients on their networks can be neighbours
+	interfaceAddrs func() ([]net.Addr, error)
+
+	mux      sync.RWMutex
+	table    map[ipKey]net.HardwareAddr
+	loaded   time.Time
+	networks []*net.IPNet
+	// when the networks of the local interfaces were read
+	networksLoaded time.Time
+}
+
+func newNeighbors() *neighbors {
+	return &neighbors{
+		arpPath:        arpTablePath,
+		netlink:        true,
+		interfaceAddrs: net.InterfaceAddrs,
+		table:          make(map[ipKey]net.HardwareAddr),
+	}
+}
+
+// the hardware address of the client with the given address or nil if the client is not a neighbour
+func (neighbors *neighbors) lookup(ip net.IP) net.HardwareAddr {
+	if neighbors == nil {
+		return nil
+	}
+	key, ok := keyForIP(ip)
+	if !ok {
+		return nil
+	}
+	// a client that is not directly connected is never in the table so it does not cause the table to be read
+	if !neighbors.connected(ip) {
+		return nil
+	}
+
+	neighbors.mux.RLock()
+	mac, found := neighbors.table[key]
+	age := time.Since(neighbors.loaded)
+	neighbors.mux.RUnlock()
+
+	// new clients show up in the table after their first packet so a miss reads the table again (but not too often)
+	if age > neighborRefresh || (!found && age > neighborMissRefresh) {
+		neighbors.refresh()
+		neighbors.mux.RLock()
+		mac = neighbors.table[key]
+		neighbors.mux.RUnlock()
+	}
+
+	return mac
+}
+
+// true if the address is on the network of one of the local interfaces
+func (neighbors *neighbors) connected(ip net.IP) bool {
+	neighbors.mux.RLock()
+	networks := neighbors.networks
+	age := time.Since(neighbors.networksLoaded)
+	neighbors.mux.RUnlock()
+
+	if age > neighborRefresh {
+		networks = make([]*net.IPNet, 0)
+		addrs, err := neighbors.interfaceAddrs()
+		if err != nil {
+			log.Debugf("Reading interface addresses: %s", err)
+		}
+		for _, addr := range addrs {
+			if network, ok := addr.(*net.IPNet); ok && !network.IP.IsLoopback() {
+				networks = append(networks, network)
+			}
+		}
+		neighbors.mux.Lock()
+		neighbors.networks = networks
+		neighbors.networksLoaded = time.Now()
+		neighbors.mux.Unlock()
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (neighbors *neighbors) refresh() {
+	neighbors.mux.Lock()
+	defer neighbors.mux.Unlock()
+
+	// another lookup refreshed the table while this one was waiting
+	if time.Since(neighbors.loaded) < neighborMissRefresh {
+		return
+	}
+
+	table := make(map[ipKey]net.HardwareAddr)
+	if "" != neighbors.arpPath {
+		if err := readArpTable(neighbors.arpPath, table); err != nil {
+			log.Debugf("Reading arp table: %s", err)
+		}
+	}
+	if neighbors.netlink {
+		if err := readNetlinkNeighbors(syscall.AF_INET6, table); err != nil {
+			log.Debugf("Reading ipv6 neighbours: %s", err)
+		}
+	}
+	neighbors.table = table
+	neighbors.loaded = time.Now()
+}
+
+// reads the "IP address, HW type, Flags, HW address, Mask, Device" lines of the arp table
+func readArpTable(path string, table map[ipKey]net.HardwareAddr) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		// entries without a complete address have no flags
+		if "0x0" == fields[2] {
+			continue
+		}
+		addNeighbor(table, net.ParseIP(fields[0]), fields[3])
+	}
+	return scanner.Err()
+}
+
+func addNeighbor(table map[ipKey]net.HardwareAddr, ip net.IP, hardwareAddress string) {
+	key, ok := keyForIP(ip)
+	if !ok {
+		return
+	}
+	mac, err := net.ParseMAC(hardwareAddress)
+	if err != nil || len(mac) != 6 {
+		return
+	}
+	// incomplete entries can have an empty address
+	for _, b := range mac {
+		if b != 0 {
+			table[key] = mac
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"net"
+	"syscall"
+)
+
+const (
+	// NDA_DST and NDA_LLADDR from linux/neighbour.h
+	neighborAttrDestination = 1
+	neighborAttrLinkAddress = 2
+)
+
+// reads the neighbour table of the address family from the kernel
+func readNetlinkNeighbors(family int, table map[ipKey]net.HardwareAddr) error {
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, family)
+	if err != nil {
+		return err
+	}
+	messages, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if syscall.RTM_NEWNEIGH != message.Header.Type {
+			continue
+		}
+		// the neighbour header (ndmsg) is the same size as the route header so the route attribute parser can read
+		// the attributes that follow it
+		message.Header.Type = syscall.RTM_NEWROUTE
+		attributes, err := syscall.ParseNetlinkRouteAttr(&message)
+		if err != nil {
+			continue
+		}
+		var ip net.IP
+		var mac net.HardwareAddr
+		for _, attribute := range attributes {
+			switch attribute.Attr.Type {
+			case neighborAttrDestination:
+				ip = net.IP(attribute.Value)
+			case neighborAttrLinkAddress:
+				mac = net.HardwareAddr(attribute.Value)
+			}
+		}
+		addNeighbor(table, ip, mac.String())
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+import (
+	"fmt"
+	"net"
+)
+
+// only linux lists its neighbours over netlink, ipv6 clients are not found in the table elsewhere
+func readNetlinkNeighbors(family int, table map[ipKey]net.HardwareAddr) error {
+	return fmt.Errorf("Netlink is not available")
+}
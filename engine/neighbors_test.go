@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func testNeighbors() *neighbors {
+	neighbors := newNeighbors()
+	neighbors.arpPath = "testdata/arp"
+	neighbors.netlink = false
+	neighbors.interfaceAddrs = func() ([]net.Addr, error) {
+		_, network, _ := net.ParseCIDR("192.168.0.0/16")
+		return []net.Addr{network}, nil
+	}
+	return neighbors
+}
+
+func TestNeighborsArpTable(t *testing.T) {
+	neighbors := testNeighbors()
+
+	data := []struct {
+		ip  string
+		mac string
+	}{
+		{"192.168.0.10", "aa:bb:cc:00:00:10"},
+		{"192.168.0.11", "aa:bb:cc:00:00:11"},
+		{"::ffff:192.168.1.22", "11:22:33:44:55:66"},
+		// incomplete entries have no address
+		{"192.168.0.12", ""},
+		{"192.168.0.13", ""},
+	}
+	for _, d := range data {
+		mac := neighbors.lookup(net.ParseIP(d.ip))
+		if (mac == nil && "" != d.mac) || (mac != nil && mac.String() != d.mac) {
+			t.Errorf("Expected mac '%s' for %s but got '%s'", d.mac, d.ip, mac)
+		}
+	}
+
+	// an address that is not on a local network is not looked for in the table
+	neighbors = testNeighbors()
+	if mac := neighbors.lookup(net.ParseIP("10.0.0.5")); mac != nil || !neighbors.loaded.IsZero() {
+		t.Errorf("Expected the table to not be read for a client that is not directly connected")
+	}
+}
+
+func TestConsumerMAC(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/mac.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+	testEngine.(*engine).neighbors = testNeighbors()
+
+	data := []struct {
+		ip       string
+		consumer string
+	}{
+		// the hardware address is more specific than the network
+		{"192.168.0.10", "laptop"},
+		// the network is more specific than the vendor
+		{"192.168.0.11", "lan"},
+		{"192.168.0.13", "lan"},
+		{"192.168.1.21", "vendor"},
+		{"192.168.1.22", "default"},
+	}
+	for _, d := range data {
+		consumer := testEngine.(*engine).getConsumerForIP(parseIP(d.ip))
+		name := "default"
+		if consumer != nil && consumer.configConsumer != nil {
+			name = consumer.configConsumer.Name
+		}
+		if name != d.consumer {
+			t.Errorf("Expected consumer '%s' for %s but got '%s'", d.consumer, d.ip, name)
+		}
+	}
+}
+
+func TestConsumerForwarded(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/mac.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+	testEngine.(*engine).neighbors = testNeighbors()
+
+	// the forwarder itself is on the lan
+	forwarder := parseIP("192.168.0.11")
+	mac, _ := net.ParseMAC("aa:bb:cc:00:00:10")
+	vendorMac, _ := net.ParseMAC("aa:bb:cc:00:00:99")
+
+	data := []struct {
+		forwarded *ForwardedClient
+		consumer  string
+	}{
+		{nil, "lan"},
+		{&ForwardedClient{}, "lan"},
+		{&ForwardedClient{DeviceID: "living-room-tv"}, "tv"},
+		{&ForwardedClient{DeviceID: "kitchen-tv"}, "lan"},
+		{&ForwardedClient{MAC: mac}, "laptop"},
+		{&ForwardedClient{Address: net.ParseIP("10.0.0.5").To4()}, "default"},
+		{&ForwardedClient{Address: net.ParseIP("10.0.0.5").To4(), MAC: vendorMac}, "vendor"},
+		// the device is more specific than the hardware address
+		{&ForwardedClient{MAC: mac, DeviceID: "living-room-tv"}, "tv"},
+	}
+	for idx, d := range data {
+		client := testEngine.(*engine).getClient(forwarder, d.forwarded)
+		// the hardware address of the forwarder is not the address of the client
+		if d.forwarded != nil && d.forwarded.MAC == nil && client.mac != nil {
+			t.Errorf("Expected no hardware address to be looked up for forwarded client %d", idx)
+		}
+		consumer := testEngine.(*engine).getConsumerForClient(client)
+		name := "default"
+		if consumer != nil && consumer.configConsumer != nil {
+			name = consumer.configConsumer.Name
+		}
+		if name != d.consumer {
+			t.Errorf("Expected consumer '%s' for forwarded client %d but got '%s'", d.consumer, idx, name)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// override scopes, from most to least specific
+	OverrideConsumer = "consumer"
+	OverrideGroup    = "group"
+	OverrideAll      = "all"
+
+	// override actions
+	OverridePause = "pause"
+	OverrideBlock = "block"
+)
+
+// a temporary change to blocking for a consumer, a group, or everything
+type Override struct {
+	Scope   string    `json:"scope"`
+	Name    string    `json:"name"`
+	Action  string    `json:"action"`
+	Expires time.Time `json:"expires"`
+}
+
+// the text that is recorded as the reason for a match
+func (override *Override) String() string {
+	if OverrideAll == override.Scope {
+		return fmt.Sprintf("%s (%s)", override.Action, override.Scope)
+	}
+	return fmt.Sprintf("%s (%s %s)", override.Action, override.Scope, override.Name)
+}
+
+func (override *Override) key() string {
+	return override.Scope + ":" + override.Name
+}
+
+// overrides are kept in a file in the data directory so that they survive a reload or restart
+type overrides struct {
+	filePath string
+	entries  map[string]*Override
+	mux      sync.RWMutex
+}
+
+func newOverrides(filePath string) *overrides {
+	overrides := &overrides{
+		filePath: filePath,
+		entries:  make(map[string]*Override),
+	}
+
+	bytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return overrides
+	} else if err != nil {
+		log.Errorf("Could not read overrides: %s", err)
+		return overrides
+	}
+
+	loaded := make([]*Override, 0)
+	if err := json.Unmarshal(bytes, &loaded); err != nil {
+		log.Errorf("Could not read overrides: %s", err)
+		return overrides
+	}
+
+	now := time.Now()
+	for _, override := range loaded {
+		if override != nil && override.Expires.After(now) {
+			overrides.entries[override.key()] = override
+		}
+	}
+
+	return overrides
+}
+
+// write the unexpired overrides to the file, must be called while holding the lock
+func (overrides *overrides) save() {
+	list := overrides.active(time.Now())
+
+	bytes, err := json.Marshal(list)
+	if err != nil {
+		log.Errorf("Could not save overrides: %s", err)
+		return
+	}
+
+	if _, err := os.Stat(path.Dir(overrides.filePath)); os.IsNotExist(err) {
+		if err = os.MkdirAll(path.Dir(overrides.filePath), os.ModePerm); err != nil {
+			log.Errorf("Could not create overrides directory: %s", err)
+			return
+		}
+	}
+
+	if err := ioutil.WriteFile(overrides.filePath, bytes, 0644); err != nil {
+		log.Errorf("Could not save overrides: %s", err)
+	}
+}
+
+// the unexpired overrides, must be called while holding the lock
+func (overrides *overrides) active(now time.Time) []*Override {
+	list := make([]*Override, 0, len(overrides.entries))
+	for _, override := range overrides.entries {
+		if override.Expires.After(now) {
+			list = append(list, override)
+		}
+	}
+	return list
+}
+
+func (overrides *overrides) set(override *Override) {
+	overrides.mux.Lock()
+	defer overrides.mux.Unlock()
+	overrides.entries[override.key()] = override
+	overrides.save()
+}
+
+func (overrides *overrides) clear(scope string, name string) bool {
+	overrides.mux.Lock()
+	defer overrides.mux.Unlock()
+	key := (&Override{Scope: scope, Name: name}).key()
+	if _, found := overrides.entries[key]; !found {
+		return false
+	}
+	delete(overrides.entries, key)
+	overrides.save()
+	return true
+}
+
+func (overrides *overrides) list() []*Override {
+	overrides.mux.RLock()
+	defer overrides.mux.RUnlock()
+	return overrides.active(time.Now())
+}
+
+// find the most specific unexpired override for the consumer and groups
+func (overrides *overrides) find(consumer string, groups []string) *Override {
+	overrides.mux.RLock()
+	defer overrides.mux.RUnlock()
+
+	if len(overrides.entries) < 1 {
+		return nil
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, len(groups)+2)
+	keys = append(keys, OverrideConsumer+":"+consumer)
+	for _, group := range groups {
+		keys = append(keys, OverrideGroup+":"+group)
+	}
+	keys = append(keys, OverrideAll+":")
+
+	for _, key := range keys {
+		if override, found := overrides.entries[key]; found && override.Expires.After(now) {
+			return override
+		}
+	}
+
+	return nil
+}
+
+// suspend blocking (pause) or block everything (block) for a consumer, group, or everything (all) for the given duration
+func (engine *engine) SetOverride(scope string, name string, action string, duration time.Duration) (*Override, error) {
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	name = strings.ToLower(strings.TrimSpace(name))
+	action = strings.ToLower(strings.TrimSpace(action))
+
+	switch scope {
+	case OverrideConsumer:
+		if _, found := engine.consumerMap[name]; !found {
+			return nil, fmt.Errorf("No consumer named '%s'", name)
+		}
+	case OverrideGroup:
+		if _, found := engine.groups[name]; !found {
+			return nil, fmt.Errorf("No group named '%s'", name)
+		}
+	case OverrideAll:
+		name = ""
+	default:
+		return nil, fmt.Errorf("Override scope must be one of '%s', '%s', or '%s'", OverrideConsumer, OverrideGroup, OverrideAll)
+	}
+
+	if OverridePause != action && OverrideBlock != action {
+		return nil, fmt.Errorf("Override action must be one of '%s' or '%s'", OverridePause, OverrideBlock)
+	}
+
+	if duration <= 0 {
+		return nil, fmt.Errorf("Override duration must be greater than zero")
+	}
+
+	override := &Override{
+		Scope:   scope,
+		Name:    name,
+		Action:  action,
+		Expires: time.Now().Add(duration),
+	}
+	engine.overrides.set(override)
+
+	return override, nil
+}
+
+// remove an override before it expires
+func (engine *engine) ClearOverride(scope string, name string) bool {
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	name = strings.ToLower(strings.TrimSpace(name))
+	if OverrideAll == scope {
+		name = ""
+	}
+	return engine.overrides.clear(scope, name)
+}
+
+func (engine *engine) Overrides() []*Override {
+	return engine.overrides.list()
+}
+
+func (engine *engine) OverridePath() string {
+	return path.Join(engine.config.DataRoot(), "overrides.json")
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/resolver"
+	"github.com/chrisruffalo/gudgeon/rule"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestOverrides(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/override.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+
+	check := func(engine Engine, consumer string, domain string, rcode int, match rule.Match, override string) {
+		request := new(dns.Msg)
+		request.SetQuestion(domain, dns.TypeA)
+		response, _, result := engine.HandleWithConsumerName(consumer, resolver.DefaultRequestContext(), request)
+		if response == nil || result == nil {
+			t.Errorf("Expected response for '%s' from %s", domain, consumer)
+			return
+		}
+		if response.Rcode != rcode || result.Match != match || result.Override != override {
+			t.Errorf("Expected rcode %d, match %d, and override '%s' for '%s' from %s but got rcode %d, match %d, and override '%s'", rcode, match, override, domain, consumer, response.Rcode, result.Match, result.Override)
+		}
+	}
+
+	// no overrides
+	check(testEngine, "kids", "blocked.example.", dns.RcodeNameError, rule.MatchBlock, "")
+	check(testEngine, "default", "good.example.", dns.RcodeSuccess, rule.MatchNone, "")
+
+	// invalid overrides
+	if _, err := testEngine.SetOverride("consumer", "nobody", OverridePause, time.Minute); err == nil {
+		t.Errorf("Expected error for an unknown consumer")
+	}
+	if _, err := testEngine.SetOverride("everyone", "", OverridePause, time.Minute); err == nil {
+		t.Errorf("Expected error for an unknown scope")
+	}
+	if _, err := testEngine.SetOverride("all", "", "allow", time.Minute); err == nil {
+		t.Errorf("Expected error for an unknown action")
+	}
+
+	// pause the kids consumer and block everything else
+	if _, err := testEngine.SetOverride("consumer", "kids", OverridePause, 10*time.Minute); err != nil {
+		t.Errorf("Could not set override: %s", err)
+	}
+	if _, err := testEngine.SetOverride("all", "", OverrideBlock, 10*time.Minute); err != nil {
+		t.Errorf("Could not set override: %s", err)
+	}
+	check(testEngine, "kids", "blocked.example.", dns.RcodeSuccess, rule.MatchAllow, "pause (consumer kids)")
+	check(testEngine, "default", "good.example.", dns.RcodeNameError, rule.MatchBlock, "block (all)")
+
+	// overrides survive a reload
+	testEngine.Shutdown()
+	testEngine, err = NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not recreate engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+	if len(testEngine.Overrides()) != 2 {
+		t.Errorf("Expected 2 overrides after reload but got %d", len(testEngine.Overrides()))
+	}
+	check(testEngine, "kids", "blocked.example.", dns.RcodeSuccess, rule.MatchAllow, "pause (consumer kids)")
+
+	// clearing overrides restores the lists
+	if !testEngine.ClearOverride("consumer", "kids") || !testEngine.ClearOverride("all", "") {
+		t.Errorf("Expected overrides to be cleared")
+	}
+	check(testEngine, "kids", "blocked.example.", dns.RcodeNameError, rule.MatchBlock, "")
+
+	// overrides expire
+	if _, err := testEngine.SetOverride("group", "default", OverridePause, time.Millisecond); err != nil {
+		t.Errorf("Could not set override: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	check(testEngine, "kids", "blocked.example.", dns.RcodeNameError, rule.MatchBlock, "")
+	if len(testEngine.Overrides()) != 0 {
+		t.Errorf("Expected no overrides after expiration")
+	}
+}
@@ -20,7 +20,7 @@ import (
 // lit of valid sort names (lower case for ease of use with util.StringIn)
 var validSorts = []string{"address", "connectiontype", "requestdomain", "requesttype", "blocked", "blockedlist", "blockedrule", "created"}
 
-const bufferFlushStmt = "INSERT INTO qlog (Address, Consumer, ClientName, RequestDomain, RequestType, ResponseText, Rcode, Cached, Blocked, Match, MatchList, MatchRule, ServiceTime, Created, EndTime) SELECT Address, Consumer, ClientName, RequestDomain, RequestType, ResponseText, Rcode, Cached, Blocked, Match, MatchList, MatchRule, ServiceTime, Created, EndTime FROM buffer WHERE true"
+const bufferFlushStmt = "INSERT INTO qlog (Address, ClientMac, Consumer, Schedule, ClientName, RequestDomain, RequestType, ResponseText, Rcode, Cached, Blocked, Match, MatchList, MatchRule, Override, RateLimit, ServiceTime, Created, EndTime) SELECT Address, ClientMac, Consumer, Schedule, ClientName, RequestDomain, RequestType, ResponseText, Rcode, Cached, Blocked, Match, MatchList, MatchRule, Override, RateLimit, ServiceTime, Created, EndTime FROM buffer WHERE true"
 
 // allows a dependency injection-way of defining a reverse lookup function, takes a string address (should be an IP) and returns a string that contains the domain name result
 type ReverseLookupFunction = func(address string) string
@@ -31,6 +31,7 @@ type ReverseLookupFunction = func(address string) string
 type QueryLogQuery struct {
 	// query on fields
 	Address        string
+	ClientMac      string
 	ClientName     string
 	ConnectionType string
 	RequestDomain  string
@@ -42,6 +43,8 @@ type QueryLogQuery struct {
 	Match     *rule.Match
 	MatchList string
 	MatchRule string
+	// "limited" or "banned" for queries answered by the rate limiter
+	RateLimit string
 	// query on created time
 	After  *time.Time
 	Before *time.Time
@@ -186,8 +189,17 @@ func (qlog *qlog) log(info *InfoRecord) {
 
 		fields["clientName"] = info.ClientName
 		fields["address"] = info.Address
+		if info.ClientMac != "" {
+			fields["mac"] = info.ClientMac
+		}
 		fields["protocol"] = rCon.Protocol
 		fields["consumer"] = info.Consumer
+		if info.Schedule != "" {
+			fields["schedule"] = info.Schedule
+		}
+		if info.RateLimit != "" {
+			fields["rateLimit"] = info.RateLimit
+		}
 		fields["requestDomain"] = info.RequestDomain
 		fields["requestType"] = info.RequestType
 		fields["cached"] = false
@@ -214,6 +226,10 @@ func (qlog *qlog) log(info *InfoRecord) {
 					fields["matchType"] = "ALLOWED"
 				}
 
+				if result.Override != "" {
+					fields["override"] = result.Override
+				}
+
 				if result.Cached {
 					fields["resolver"] = result.Resolver
 					fields["cached"] = "true"
@@ -248,6 +264,10 @@ func (qlog *qlog) log(info *InfoRecord) {
 			delete(fields, "cached")
 			delete(fields, "source")
 			delete(fields, "answer")
+			delete(fields, "schedule")
+			delete(fields, "override")
+			delete(fields, "mac")
+			delete(fields, "rateLimit")
 			qlog.fieldPool.Put(fields)
 		}
 	}
@@ -274,10 +294,22 @@ func (qlog *qlog) log(info *InfoRecord) {
 				builder.WriteString("|")
 			}
 			builder.WriteString(info.Address)
+			if info.ClientMac != "" {
+				builder.WriteString("|")
+				builder.WriteString(info.ClientMac)
+			}
 			builder.WriteString("/")
 			builder.WriteString(rCon.Protocol)
 			builder.WriteString("|")
 			builder.WriteString(info.Consumer)
+			if info.Schedule != "" {
+				builder.WriteString("@")
+				builder.WriteString(info.Schedule)
+			}
+			if info.RateLimit != "" {
+				builder.WriteString("|")
+				builder.WriteString(strings.ToUpper(info.RateLimit))
+			}
 			builder.WriteString("] q:[")
 			builder.WriteString(info.RequestDomain)
 			builder.WriteString("|")
@@ -362,7 +394,7 @@ func (qlog *qlog) QueryFunc(query *QueryLogQuery, accumulator QueryAccumulator)
 	}
 
 	// select entries from qlog
-	selectStmt := "SELECT Address, ClientName, Consumer, RequestDomain, RequestType, ResponseText, Rcode, Blocked, Match, MatchList, MatchRule, Cached, ServiceTime, Created, EndTime FROM qlog"
+	selectStmt := "SELECT Address, ClientMac, ClientName, Consumer, Schedule, RequestDomain, RequestType, ResponseText, Rcode, Blocked, Match, MatchList, MatchRule, Override, RateLimit, Cached, ServiceTime, Created, EndTime FROM qlog"
 	countStmt := "SELECT COUNT(*) FROM qlog"
 
 	// so we can dynamically build the where clause
@@ -381,6 +413,11 @@ func (qlog *qlog) QueryFunc(query *QueryLogQuery, accumulator QueryAccumulator)
 		orValues = append(orValues, "%"+query.Address+"%")
 	}
 
+	if "" != query.ClientMac {
+		orClauses = append(orClauses, "ClientMac like ?")
+		orValues = append(orValues, "%"+query.ClientMac+"%")
+	}
+
 	if "" != query.ClientName {
 		orClauses = append(orClauses, "ClientName like ?")
 		orValues = append(orValues, "%"+query.ClientName+"%")
@@ -416,6 +453,11 @@ func (qlog *qlog) QueryFunc(query *QueryLogQuery, accumulator QueryAccumulator)
 		whereValues = append(whereValues, query.MatchRule)
 	}
 
+	if "" != query.RateLimit {
+		whereClauses = append(whereClauses, "RateLimit = ?")
+		whereValues = append(whereValues, query.RateLimit)
+	}
+
 	if query.Cached != nil {
 		whereClauses = append(whereClauses, "Cached = ?")
 		whereValues = append(whereValues, query.Cached)
@@ -501,7 +543,7 @@ func (qlog *qlog) QueryFunc(query *QueryLogQuery, accumulator QueryAccumulator)
 	// scan each row and get results
 	info := &InfoRecord{}
 	for rows.Next() {
-		err = rows.Scan(&info.Address, &info.ClientName, &info.Consumer, &info.RequestDomain, &info.RequestType, &info.ResponseText, &info.Rcode, &info.Blocked, &info.Match, &info.MatchList, &info.MatchRule, &info.Cached, &info.ServiceMilliseconds, &info.Created, &info.Finished)
+		err = rows.Scan(&info.Address, &info.ClientMac, &info.ClientName, &info.Consumer, &info.Schedule, &info.RequestDomain, &info.RequestType, &info.ResponseText, &info.Rcode, &info.Blocked, &info.Match, &info.MatchList, &info.MatchRule, &info.Override, &info.RateLimit, &info.Cached, &info.ServiceMilliseconds, &info.Created, &info.Finished)
 		if err != nil {
 			log.Errorf("Scanning qlog results: %s", err)
 			continue
@@ -530,14 +572,18 @@ func (qlog *qlog) Query(query *QueryLogQuery) ([]*InfoRecord, uint64) {
 				Created:             info.Created,
 				Finished:            info.Finished,
 				MatchRule:           info.MatchRule,
+				Override:            info.Override,
+				RateLimit:           info.RateLimit,
 				MatchList:           info.MatchList,
 				Blocked:             info.Blocked,
 				RequestContext:      info.RequestContext,
 				Address:             info.Address,
+				ClientMac:           info.ClientMac,
 				Cached:              info.Cached,
 				ClientName:          info.ClientName,
 				ConnectionType:      info.ConnectionType,
 				Consumer:            info.Consumer,
+				Schedule:            info.Schedule,
 				Match:               info.Match,
 				MatchListShort:      info.MatchListShort,
 				Rcode:               info.Rcode,
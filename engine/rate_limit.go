@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+const (
+	// recorded with queries that were answered by the rate limiter
+	RateLimitLimited = "limited"
+	RateLimitBanned  = "banned"
+
+	// a client that goes this long without being limited starts over when it goes over the limit again
+	rateLimitStreakGap = time.Second
+	// buckets that have not been used for this long are full again and are removed
+	rateLimitIdle = 5 * time.Minute
+)
+
+// a client that stayed over the rate limit and is refused until the ban expires
+type Ban struct {
+	// the address of the client or the name of the consumer, depending on the limit
+	Client   string    `json:"client"`
+	Per      string    `json:"per"`
+	Consumer string    `json:"consumer"`
+	Started  time.Time `json:"started"`
+	Expires  time.Time `json:"expires"`
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// when the client went over the limit and when it was last over it
+	overSince   time.Time
+	lastLimited time.Time
+}
+
+// the result of checking a query against the rate limit
+type rateLimitState struct {
+	// empty when the query is allowed, otherwise RateLimitLimited or RateLimitBanned
+	state string
+	// true for the first query of a streak over the limit and the query that started a ban, other queries
+	// over the limit are not recorded so that a client can't flood the query log
+	record bool
+	// the number of clients that are banned
+	bans int
+}
+
+// token buckets and bans, in memory and keyed by address or consumer
+type rateLimiter struct {
+	mux       sync.Mutex
+	buckets   map[string]*tokenBucket
+	bans      map[string]*Ban
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		bans:      make(map[string]*Ban),
+		lastSweep: time.Now(),
+	}
+}
+
+func rateLimitKey(per string, client string) string {
+	return per + ":" + client
+}
+
+// take a token from the bucket of the client, banning the client if it has been over the limit for too long
+func (limiter *rateLimiter) check(limit *config.GudgeonRateLimit, client string, consumer string, now time.Time) rateLimitState {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+	state := limiter.take(limit, client, consumer, now)
+	state.bans = len(limiter.bans)
+	return state
+}
+
+// must be called while holding the lock
+func (limiter *rateLimiter) take(limit *config.GudgeonRateLimit, client string, consumer string, now time.Time) rateLimitState {
+	limiter.sweep(now)
+
+	key := rateLimitKey(limit.Per, client)
+	if ban, found := limiter.bans[key]; found {
+		if ban.Expires.After(now) {
+			return rateLimitState{state: RateLimitBanned}
+		}
+		delete(limiter.bans, key)
+	}
+
+	bucket, found := limiter.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		limiter.buckets[key] = bucket
+	}
+
+	// refill for the time since the last query
+	bucket.tokens += now.Sub(bucket.updated).Seconds() * limit.Rate
+	if bucket.tokens > float64(limit.Burst) {
+		bucket.tokens = float64(limit.Burst)
+	}
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return rateLimitState{}
+	}
+
+	// a new streak starts when the client was under the limit for a while
+	result := rateLimitState{state: RateLimitLimited}
+	if now.Sub(bucket.lastLimited) > rateLimitStreakGap {
+		bucket.overSince = now
+		result.record = true
+	}
+	bucket.lastLimited = now
+
+	if banAfter := limit.BanAfterDuration(); banAfter > 0 && now.Sub(bucket.overSince) >= banAfter {
+		limiter.bans[key] = &Ban{
+			Client:   client,
+			Per:      limit.Per,
+			Consumer: consumer,
+			Started:  now,
+			Expires:  now.Add(limit.BanForDuration()),
+		}
+		delete(limiter.buckets, key)
+		return rateLimitState{state: RateLimitBanned, record: true}
+	}
+
+	return result
+}
+
+// remove idle buckets and expired bans, must be called while holding the lock
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+	limiter.lastSweep = now
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.updated) > rateLimitIdle {
+			delete(limiter.buckets, key)
+		}
+	}
+	for key, ban := range limiter.bans {
+		if !ban.Expires.After(now) {
+			delete(limiter.bans, key)
+		}
+	}
+}
+
+// the bans that have not expired, oldest first
+func (limiter *rateLimiter) list() []*Ban {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(limiter.bans))
+	for _, ban := range limiter.bans {
+		if ban.Expires.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Started.Before(bans[j].Started)
+	})
+	return bans
+}
+
+// remove the ban (for an address or a consumer), the client starts over with a full bucket
+func (limiter *rateLimiter) unban(client string) bool {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+
+	client = strings.TrimSpace(client)
+	found := false
+	for _, per := range []string{config.RateLimitPerAddress, config.RateLimitPerConsumer} {
+		key := rateLimitKey(per, client)
+		if _, banned := limiter.bans[key]; banned {
+			delete(limiter.bans, key)
+			found = true
+		}
+		delete(limiter.buckets, key)
+	}
+	return found
+}
+
+func (limiter *rateLimiter) banned() int {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+	return len(limiter.bans)
+}
+
+// the rate limit for the consumer, nil when the clients of the consumer are not limited
+func (engine *engine) rateLimitFor(consumer *consumer) *config.GudgeonRateLimit {
+	limit := engine.config.Network.RateLimit
+	if consumer != nil && consumer.configConsumer != nil && consumer.configConsumer.RateLimit != nil {
+		limit = consumer.configConsumer.RateLimit
+	}
+	if limit == nil || limit.Enabled == nil || !*limit.Enabled {
+		return nil
+	}
+	return limit
+}
+
+// the bans that have not expired
+func (engine *engine) Bans() []*Ban {
+	if engine.rateLimiter == nil {
+		return []*Ban{}
+	}
+	return engine.rateLimiter.list()
+}
+
+// remove the ban for an address or consumer before it expires
+func (engine *engine) Unban(client string) bool {
+	if engine.rateLimiter == nil {
+		return false
+	}
+	unbanned := engine.rateLimiter.unban(client)
+	if unbanned && engine.metrics != nil {
+		engine.metrics.Get(BannedClients).Set(int64(engine.rateLimiter.banned()))
+	}
+	return unbanned
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestRateLimiterBan(t *testing.T) {
+	limit := &config.GudgeonRateLimit{Rate: 2, Burst: 4, BanAfter: "3s", BanFor: "1m"}
+	limit.VerifyAndInit(nil)
+
+	limiter := newRateLimiter()
+	now := time.Now()
+
+	// the burst is allowed and then the client is limited
+	for idx := 0; idx < 4; idx++ {
+		if state := limiter.check(limit, "10.0.0.1", "default", now); "" != state.state {
+			t.Errorf("Expected query %d to be allowed but it was %s", idx, state.state)
+		}
+	}
+	if state := limiter.check(limit, "10.0.0.1", "default", now); RateLimitLimited != state.state || !state.record {
+		t.Errorf("Expected the first query over the limit to be limited and recorded")
+	}
+	if state := limiter.check(limit, "10.0.0.1", "default", now); RateLimitLimited != state.state || state.record {
+		t.Errorf("Expected the second query over the limit to be limited and not recorded")
+	}
+
+	// another client has its own bucket
+	if state := limiter.check(limit, "10.0.0.2", "default", now); "" != state.state {
+		t.Errorf("Expected another client to be allowed")
+	}
+
+	// the bucket refills at the rate
+	now = now.Add(500 * time.Millisecond)
+	if state := limiter.check(limit, "10.0.0.1", "default", now); "" != state.state {
+		t.Errorf("Expected a query after the bucket refilled to be allowed but it was %s", state.state)
+	}
+
+	// staying over the limit bans the client
+	var state rateLimitState
+	for idx := 0; idx < 40 && RateLimitBanned != state.state; idx++ {
+		now = now.Add(100 * time.Millisecond)
+		for query := 0; query < 5; query++ {
+			state = limiter.check(limit, "10.0.0.1", "default", now)
+			if RateLimitBanned == state.state {
+				break
+			}
+		}
+	}
+	if RateLimitBanned != state.state || !state.record || state.bans != 1 {
+		t.Errorf("Expected the client to be banned after staying over the limit")
+	}
+	if bans := limiter.list(); len(bans) != 1 || "10.0.0.1" != bans[0].Client || "default" != bans[0].Consumer {
+		t.Errorf("Expected a ban for the client but got %v", bans)
+	}
+
+	// banned clients are refused even with a full bucket until the ban expires or they are unbanned
+	if state := limiter.check(limit, "10.0.0.1", "default", now.Add(30*time.Second)); RateLimitBanned != state.state || state.record {
+		t.Errorf("Expected a banned client to stay banned")
+	}
+	if !limiter.unban("10.0.0.1") || limiter.unban("10.0.0.1") {
+		t.Errorf("Expected the client to be unbanned once")
+	}
+	if state := limiter.check(limit, "10.0.0.1", "default", now.Add(30*time.Second)); "" != state.state {
+		t.Errorf("Expected an unbanned client to be allowed but it was %s", state.state)
+	}
+}
+
+func TestRateLimitHandle(t *testing.T) {
+	config := testutil.TestConf(t, "testdata/ratelimit.yml")
+	defer os.RemoveAll(config.Home)
+
+	testEngine, err := NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not create engine: %s", err)
+		return
+	}
+	defer testEngine.Shutdown()
+
+	query := func(ip string) *dns.Msg {
+		request := new(dns.Msg)
+		request.SetQuestion("good.example.", dns.TypeA)
+		response, _, _ := testEngine.Handle(parseIP(ip), nil, "udp", nil, request)
+		return response
+	}
+
+	data := []struct {
+		ip string
+		// the rcode of the response for each query, -1 when the query is dropped
+		rcodes []int
+	}{
+		{"10.0.0.1", []int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeRefused, dns.RcodeRefused}},
+		{"10.0.0.2", []int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeRefused}},
+		// clients of the consumer share a bucket
+		{"192.168.1.10", []int{dns.RcodeSuccess, dns.RcodeSuccess}},
+		{"192.168.1.11", []int{-1, -1}},
+		// the consumer is not limited
+		{"192.168.0.1", []int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeSuccess}},
+	}
+	for _, d := range data {
+		for idx, rcode := range d.rcodes {
+			response := query(d.ip)
+			if rcode < 0 {
+				if response != nil {
+					t.Errorf("Expected query %d from %s to be dropped", idx, d.ip)
+				}
+				continue
+			}
+			if response == nil || response.Rcode != rcode {
+				t.Errorf("Expected rcode %d for query %d from %s but got %v", rcode, idx, d.ip, response)
+			}
+		}
+	}
+
+	if metric := testEngine.Metrics(); metric != nil && metric.Get(RateLimitedQueries).Value() != 5 {
+		t.Errorf("Expected 5 rate limited queries but got %d", metric.Get(RateLimitedQueries).Value())
+	}
+	if len(testEngine.Bans()) != 0 {
+		t.Errorf("Expected no bans when bans are disabled")
+	}
+}
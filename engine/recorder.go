@@ -32,7 +32,7 @@ const (
 	_shrinkPragma = "PRAGMA shrink_memory;"
 
 	// single instance of insert statement used for inserting into the "buffer"
-	bufferInsertStatement = "INSERT INTO buffer (Address, ClientName, Consumer, RequestDomain, RequestType, ResponseText, Rcode, Blocked, Match, MatchList, MatchListShort, MatchRule, Cached, ServiceTime, Created, EndTime) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	bufferInsertStatement = "INSERT INTO buffer (Address, ClientMac, ClientName, Consumer, Schedule, RequestDomain, RequestType, ResponseText, Rcode, Blocked, Match, MatchList, MatchListShort, MatchRule, Override, RateLimit, Cached, ServiceTime, Created, EndTime) VALUES ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 )
 
 // coordinates all recording functions/features
@@ -70,6 +70,8 @@ type recorder struct {
 type InfoRecord struct {
 	// client address
 	Address string
+	// client hardware address, when the client is a neighbour
+	ClientMac string
 
 	// hold the information but aren't serialized
 	Request        *dns.Msg                   `json:"-"`
@@ -79,6 +81,7 @@ type InfoRecord struct {
 
 	// generated/calculated values
 	Consumer       string
+	Schedule       string
 	ClientName     string
 	ConnectionType string
 	RequestDomain  string
@@ -95,6 +98,12 @@ type InfoRecord struct {
 	MatchListShort string
 	MatchRule      string
 
+	// the temporary override that decided the match
+	Override string
+
+	// "limited" or "banned" when the query was answered by the rate limiter
+	RateLimit string
+
 	// cached in resolver cache store
 	Cached bool
 
@@ -109,6 +118,8 @@ type InfoRecord struct {
 func (record *InfoRecord) clear() {
 	// not set/overwritten and so need to be forced/cleared here
 	record.Consumer = ""
+	record.ClientMac = ""
+	record.Schedule = ""
 	record.ConnectionType = ""
 	record.RequestDomain = ""
 	record.RequestType = ""
@@ -119,6 +130,8 @@ func (record *InfoRecord) clear() {
 	record.MatchList = ""
 	record.MatchListShort = ""
 	record.MatchRule = ""
+	record.Override = ""
+	record.RateLimit = ""
 	record.Cached = false
 	// unconditionally set when received or conditioned, no need to overwrite here
 	//record.Address
@@ -216,6 +229,21 @@ func (recorder *recorder) queue(address *net.IP, request *dns.Msg, response *dns
 	recorder.infoQueue <- msg
 }
 
+// the name of the client that was already found by an earlier lookup, never looks the name up itself
+func (recorder *recorder) knownName(address string) string {
+	if recorder.cache != nil {
+		if value, found := recorder.cache.Get(address); found {
+			if name, ok := value.(string); ok && "" != name {
+				return name
+			}
+		}
+	}
+	if recorder.mdnsCache != nil {
+		return ReadCachedHostname(recorder.mdnsCache, address)
+	}
+	return ""
+}
+
 func (recorder *recorder) reverseLookup(info *InfoRecord) string {
 	if !*recorder.conf.QueryLog.ReverseLookup {
 		return ""
@@ -285,6 +313,8 @@ func (recorder *recorder) condition(info *InfoRecord) {
 
 	if info.Result != nil {
 		info.Consumer = info.Result.Consumer
+		info.ClientMac = info.Result.ClientMac
+		info.Schedule = info.Result.Schedule
 
 		if info.Result.Blocked {
 			info.Blocked = true
@@ -302,6 +332,8 @@ func (recorder *recorder) condition(info *InfoRecord) {
 			}
 			info.MatchRule = info.Result.MatchRule
 		}
+		info.Override = info.Result.Override
+		info.RateLimit = info.Result.RateLimit
 	}
 
 	if info.RequestContext != nil {
@@ -486,8 +518,10 @@ func (recorder *recorder) buffer(info *InfoRecord) {
 	// insert into buffer table
 	_, err = recorder.tx.Stmt(recorder.stmt).Exec(
 		info.Address,
+		info.ClientMac,
 		info.ClientName,
 		info.Consumer,
+		info.Schedule,
 		info.RequestDomain,
 		info.RequestType,
 		info.ResponseText,
@@ -497,6 +531,8 @@ func (recorder *recorder) buffer(info *InfoRecord) {
 		info.MatchList,
 		info.MatchListShort,
 		info.MatchRule,
+		info.Override,
+		info.RateLimit,
 		info.Cached,
 		info.ServiceMilliseconds,
 		info.Created,
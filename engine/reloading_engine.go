@@ -5,10 +5,12 @@ import (
 	"github.com/chrisruffalo/gudgeon/events"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/chrisruffalo/gudgeon/cache"
 	"github.com/chrisruffalo/gudgeon/config"
 	"github.com/chrisruffalo/gudgeon/resolver"
 	"github.com/chrisruffalo/gudgeon/rule"
@@ -54,9 +56,11 @@ func NewReloadingEngine(confPath string, conf *config.GudgeonConfig) (Engine, er
 				}
 			}
 
-			reloading.swap(conf)
-
-			log.Infof("Configuration updated from: '%s'", confPath)
+			if reloading.swap(conf) {
+				log.Infof("Configuration updated from: '%s'", confPath)
+				// let the listeners and the web ui follow the new configuration
+				events.Send("config:changed", &events.Message{"config": conf})
+			}
 		}
 
 		// subscribe for new change events / ensure still subscribed
@@ -70,7 +74,7 @@ func NewReloadingEngine(confPath string, conf *config.GudgeonConfig) (Engine, er
 
 // wait to swap engine until all rlocked processes have completed
 // and then lock during the swap and release to resume normal operations
-func (rEngine *reloadingEngine) swap(config *config.GudgeonConfig) {
+func (rEngine *reloadingEngine) swap(config *config.GudgeonConfig) bool {
 	// lock engine
 	rEngine.mux.Lock()
 	defer rEngine.mux.Unlock()
@@ -87,13 +91,15 @@ func (rEngine *reloadingEngine) swap(config *config.GudgeonConfig) {
 	// if engine fails then have no engine
 	if err != nil {
 		log.Errorf("Could not reload engine, keeping current engine (cause: %s)", err)
-		return
+		return false
 	}
 
 	// use new engine after build (if no errors happened)
 	rEngine.current = newEngine
 
 	log.Debugf("Using new engine...")
+
+	return true
 }
 
 func (engine *reloadingEngine) IsDomainRuleMatched(consumer *net.IP, domain string) (rule.Match, *config.GudgeonList, string) {
@@ -105,6 +111,24 @@ func (engine *reloadingEngine) IsDomainRuleMatched(consumer *net.IP, domain stri
 	return rule.MatchNone, nil, ""
 }
 
+func (engine *reloadingEngine) ExplainWithConsumerName(consumerName string, domain string) *rule.Explanation {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.ExplainWithConsumerName(consumerName, domain)
+	}
+	return rule.Explain(nil, nil, domain)
+}
+
+func (engine *reloadingEngine) ExplainWithGroups(groups []string, domain string) *rule.Explanation {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.ExplainWithGroups(groups, domain)
+	}
+	return rule.Explain(nil, nil, domain)
+}
+
 func (engine *reloadingEngine) Resolve(domainName string) (string, error) {
 	if engine.current != nil {
 		engine.mux.RLock()
@@ -123,11 +147,11 @@ func (engine *reloadingEngine) Reverse(address string) string {
 	return ""
 }
 
-func (engine *reloadingEngine) Handle(address *net.IP, protocol string, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
+func (engine *reloadingEngine) Handle(address *net.IP, listener net.Addr, protocol string, forwarded *ForwardedClient, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
 	if engine.current != nil {
 		engine.mux.RLock()
 		defer engine.mux.RUnlock()
-		return engine.current.Handle(address, protocol, request)
+		return engine.current.Handle(address, listener, protocol, forwarded, request)
 	}
 	return nil, nil, nil
 }
@@ -177,6 +201,105 @@ func (engine *reloadingEngine) CacheSize() int64 {
 	return int64(0)
 }
 
+func (engine *reloadingEngine) CacheEntries(partition string, domain string) []*cache.Entry {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.CacheEntries(partition, domain)
+	}
+	return []*cache.Entry{}
+}
+
+func (engine *reloadingEngine) CacheFlushDomain(partition string, domain string) int {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.CacheFlushDomain(partition, domain)
+	}
+	return 0
+}
+
+func (engine *reloadingEngine) CacheFlushPartition(partition string) int {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.CacheFlushPartition(partition)
+	}
+	return 0
+}
+
+func (engine *reloadingEngine) SetOverride(scope string, name string, action string, duration time.Duration) (*Override, error) {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.SetOverride(scope, name, action, duration)
+	}
+	return nil, fmt.Errorf("No engine available")
+}
+
+func (engine *reloadingEngine) ClearOverride(scope string, name string) bool {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.ClearOverride(scope, name)
+	}
+	return false
+}
+
+func (engine *reloadingEngine) Overrides() []*Override {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.Overrides()
+	}
+	return []*Override{}
+}
+
+func (engine *reloadingEngine) Bans() []*Ban {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.Bans()
+	}
+	return []*Ban{}
+}
+
+func (engine *reloadingEngine) Unban(client string) bool {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.Unban(client)
+	}
+	return false
+}
+
+func (engine *reloadingEngine) AddCustomRule(group string, ruleType string, rule string, duration time.Duration) (*CustomRule, error) {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.AddCustomRule(group, ruleType, rule, duration)
+	}
+	return nil, fmt.Errorf("No engine available")
+}
+
+func (engine *reloadingEngine) RemoveCustomRule(group string, ruleType string, rule string) (bool, error) {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.RemoveCustomRule(group, ruleType, rule)
+	}
+	return false, fmt.Errorf("No engine available")
+}
+
+func (engine *reloadingEngine) CustomRules(group string) []*CustomRule {
+	if engine.current != nil {
+		engine.mux.RLock()
+		defer engine.mux.RUnlock()
+		return engine.current.CustomRules(group)
+	}
+	return []*CustomRule{}
+}
+
 func (engine *reloadingEngine) QueryLog() QueryLog {
 	if engine.current != nil {
 		engine.mux.RLock()
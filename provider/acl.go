@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+// the networks that can use a listener, checked before a request reaches the engine
+type acl struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	// refuse requests that are not allowed instead of dropping them
+	refuse bool
+}
+
+// nil when every client is allowed
+func newACL(allow []string, deny []string, denyResponse string) *acl {
+	if len(allow) < 1 && len(deny) < 1 {
+		return nil
+	}
+	return &acl{
+		allow:  parseNetworks("Allowed network", allow),
+		deny:   parseNetworks("Denied network", deny),
+		refuse: config.DenyDrop != denyResponse,
+	}
+}
+
+// denied networks are checked first, when there are no allowed networks everything that is not denied is allowed
+func (acl *acl) allowed(address *net.IP) bool {
+	if acl == nil {
+		return true
+	}
+	if address == nil {
+		return false
+	}
+	if inNetworks(acl.deny, address) {
+		return false
+	}
+	return len(acl.allow) < 1 || inNetworks(acl.allow, address)
+}
+
+// parses addresses and networks, entries that can't be parsed are skipped
+func parseNetworks(description string, entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if "" == entry {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Warnf("%s '%s' is not an address or network and will not be used", description, entry)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Warnf("%s '%s' is not an address or network and will not be used", description, entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func inNetworks(networks []*net.IPNet, address *net.IP) bool {
+	if address == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(*address) {
+			return true
+		}
+	}
+	return false
+}
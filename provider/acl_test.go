@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+func TestACL(t *testing.T) {
+	if newACL(nil, nil, config.DenyRefused) != nil {
+		t.Errorf("Expected no access control list without allowed or denied networks")
+	}
+
+	data := []struct {
+		allow   []string
+		deny    []string
+		ip      string
+		allowed bool
+	}{
+		{nil, nil, "203.0.113.1", true},
+		{[]string{"192.168.0.0/16", "::1"}, nil, "192.168.1.10", true},
+		{[]string{"192.168.0.0/16", "::1"}, nil, "::1", true},
+		{[]string{"192.168.0.0/16", "::1"}, nil, "203.0.113.1", false},
+		{nil, []string{"203.0.113.0/24"}, "203.0.113.1", false},
+		{nil, []string{"203.0.113.0/24"}, "192.168.1.10", true},
+		// denied networks are checked first
+		{[]string{"192.168.0.0/16"}, []string{"192.168.5.0/24"}, "192.168.5.10", false},
+		{[]string{"192.168.0.0/16"}, []string{"192.168.5.0/24"}, "192.168.6.10", true},
+	}
+	for _, d := range data {
+		ip := net.ParseIP(d.ip)
+		if newACL(d.allow, d.deny, config.DenyRefused).allowed(&ip) != d.allowed {
+			t.Errorf("Expected allowed=%t for %s with allow %v and deny %v", d.allowed, d.ip, d.allow, d.deny)
+		}
+	}
+
+	if newACL(nil, []string{"10.0.0.0/8"}, config.DenyDrop).refuse {
+		t.Errorf("Expected requests to be dropped")
+	}
+	if !newACL(nil, []string{"10.0.0.0/8"}, config.DenyRefused).refuse {
+		t.Errorf("Expected requests to be refused")
+	}
+}
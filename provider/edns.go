@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"github.com/miekg/dns"
+)
+
+// the response gets an opt record of its own when the request has one (and never keeps the one from upstream) and
+// udp responses are truncated (with tc set) to the size the client can take, the response is copied before it is
+// changed because the engine can still be recording it
+func fitResponse(request *dns.Msg, response *dns.Msg, protocol string, udpSize int) *dns.Msg {
+	clientOpt := request.IsEdns0()
+	if udpSize < dns.MinMsgSize {
+		udpSize = dns.MinMsgSize
+	}
+
+	// a client without edns0 can only take 512 bytes over udp
+	size := dns.MinMsgSize
+	if clientOpt != nil {
+		size = int(clientOpt.UDPSize())
+		if size > udpSize {
+			size = udpSize
+		}
+		if size < dns.MinMsgSize {
+			size = dns.MinMsgSize
+		}
+	}
+
+	tooLarge := "udp" == protocol && response.Len() > size
+	if clientOpt == nil && response.IsEdns0() == nil && !tooLarge {
+		return response
+	}
+
+	response = response.Copy()
+	extra := response.Extra[:0]
+	for _, record := range response.Extra {
+		if _, isOpt := record.(*dns.OPT); !isOpt {
+			extra = append(extra, record)
+		}
+	}
+	response.Extra = extra
+
+	if clientOpt != nil {
+		// only version 0 is supported
+		if clientOpt.Version() != 0 {
+			response.Rcode = dns.RcodeBadVers
+			response.Answer = nil
+			response.Ns = nil
+			response.Extra = nil
+		}
+		response.SetEdns0(uint16(udpSize), clientOpt.Do())
+	}
+
+	if "udp" == protocol {
+		response.Truncate(size)
+	}
+
+	return response
+}
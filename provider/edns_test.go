@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func largeResponse(request *dns.Msg, records int) *dns.Msg {
+	response := new(dns.Msg)
+	response.SetReply(request)
+	for idx := 0; idx < records; idx++ {
+		record, _ := dns.NewRR(fmt.Sprintf("%s 60 IN TXT \"%040d\"", request.Question[0].Name, idx))
+		response.Answer = append(response.Answer, record)
+	}
+	return response
+}
+
+func TestFitResponse(t *testing.T) {
+	plain := new(dns.Msg)
+	plain.SetQuestion("large.example.", dns.TypeTXT)
+
+	edns := plain.Copy()
+	edns.SetEdns0(4096, true)
+
+	smallEdns := plain.Copy()
+	smallEdns.SetEdns0(800, false)
+
+	data := []struct {
+		name      string
+		request   *dns.Msg
+		records   int
+		protocol  string
+		truncated bool
+		maxSize   int
+		opt       bool
+	}{
+		{"small response", plain, 1, "udp", false, dns.MinMsgSize, false},
+		{"no edns0 over udp", plain, 40, "udp", true, dns.MinMsgSize, false},
+		{"no edns0 over tcp", plain, 40, "tcp", false, dns.MaxMsgSize, false},
+		{"edns0 larger than the server", edns, 40, "udp", true, 1232, true},
+		{"edns0 smaller than the server", smallEdns, 40, "udp", true, 800, true},
+		{"edns0 with room", edns, 10, "udp", false, 1232, true},
+		{"edns0 over tcp", edns, 40, "tcp", false, dns.MaxMsgSize, true},
+	}
+	for _, d := range data {
+		response := largeResponse(d.request, d.records)
+		// an opt record from upstream is never passed on
+		response.SetEdns0(4096, false)
+		answers := len(response.Answer)
+
+		fitted := fitResponse(d.request, response, d.protocol, 1232)
+		if fitted.Truncated != d.truncated {
+			t.Errorf("%s: expected truncated=%t", d.name, d.truncated)
+		}
+		if fitted.Len() > d.maxSize {
+			t.Errorf("%s: expected at most %d bytes but got %d", d.name, d.maxSize, fitted.Len())
+		}
+		opt := fitted.IsEdns0()
+		if d.opt && (opt == nil || opt.UDPSize() != 1232) {
+			t.Errorf("%s: expected an opt record advertising 1232", d.name)
+		} else if !d.opt && opt != nil {
+			t.Errorf("%s: expected no opt record", d.name)
+		}
+		if len(response.Answer) != answers {
+			t.Errorf("%s: expected the original response to not be changed", d.name)
+		}
+	}
+
+	// the do bit is echoed
+	if opt := fitResponse(edns, largeResponse(edns, 1), "udp", 1232).IsEdns0(); opt == nil || !opt.Do() {
+		t.Errorf("Expected the do bit to be echoed")
+	}
+
+	// only version 0 is supported
+	badVersion := edns.Copy()
+	badVersion.IsEdns0().SetVersion(1)
+	if fitted := fitResponse(badVersion, largeResponse(badVersion, 1), "udp", 1232); fitted.Rcode != dns.RcodeBadVers || len(fitted.Answer) > 0 {
+		t.Errorf("Expected badvers for edns0 version 1")
+	}
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/engine"
+)
+
+const (
+	// dnsmasq --add-mac, the hardware address of the client as raw bytes, text, or base64
+	edns0MACOption = 65001
+	// the id of the device (dnsmasq --add-cpe-id and forwarders like the nextdns client)
+	edns0DeviceIDOption = 65073
+	edns0CPEIDOption    = 65074
+)
+
+// the client that the forwarder sent the request for, from the edns0 options of the request, or nil if the
+// forwarder did not identify the client
+func forwardedClient(request *dns.Msg) *engine.ForwardedClient {
+	if request == nil {
+		return nil
+	}
+	opt := request.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	var forwarded *engine.ForwardedClient
+	get := func() *engine.ForwardedClient {
+		if forwarded == nil {
+			forwarded = &engine.ForwardedClient{}
+		}
+		return forwarded
+	}
+	for _, option := range opt.Option {
+		switch option := option.(type) {
+		case *dns.EDNS0_SUBNET:
+			if option.Address == nil {
+				continue
+			}
+			get().Subnet = normalizeIP(option.Address)
+			get().SubnetBits = int(option.SourceNetmask)
+			// only a subnet that is a single address identifies the client
+			if 8*len(normalizeIP(option.Address)) == int(option.SourceNetmask) {
+				get().Address = normalizeIP(option.Address)
+			}
+		case *dns.EDNS0_LOCAL:
+			switch option.Code {
+			case edns0MACOption:
+				if mac := parseForwardedMAC(option.Data); mac != nil {
+					get().MAC = mac
+				}
+			case edns0DeviceIDOption, edns0CPEIDOption:
+				if id := strings.TrimSpace(string(option.Data)); "" != id {
+					get().DeviceID = id
+				}
+			}
+		}
+	}
+	return forwarded
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// dnsmasq sends the hardware address as six bytes unless it is told to send it as text or base64
+func parseForwardedMAC(data []byte) net.HardwareAddr {
+	if len(data) == 6 {
+		return net.HardwareAddr(data)
+	}
+	text := strings.TrimSpace(string(data))
+	if mac, err := net.ParseMAC(text); err == nil && len(mac) == 6 {
+		return mac
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding} {
+		if decoded, err := encoding.DecodeString(text); err == nil && len(decoded) == 6 {
+			return net.HardwareAddr(decoded)
+		}
+	}
+	return nil
+}
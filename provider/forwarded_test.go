@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/engine"
+	"github.com/chrisruffalo/gudgeon/resolver"
+)
+
+func TestTrustedForwarders(t *testing.T) {
+	trusted := parseNetworks("Trusted forwarder", []string{"192.168.0.1", "10.0.0.0/8", "fd00::1", "not an address"})
+	if len(trusted) != 3 {
+		t.Errorf("Expected 3 trusted forwarders but got %d", len(trusted))
+	}
+
+	data := []struct {
+		ip      string
+		trusted bool
+	}{
+		{"192.168.0.1", true},
+		{"192.168.0.2", false},
+		{"10.20.30.40", true},
+		{"fd00::1", true},
+		{"fd00::2", false},
+	}
+	for _, d := range data {
+		ip := net.ParseIP(d.ip)
+		if inNetworks(trusted, &ip) != d.trusted {
+			t.Errorf("Expected trusted=%t for %s", d.trusted, d.ip)
+		}
+	}
+	if inNetworks(trusted, nil) {
+		t.Errorf("A request without an address should not be trusted")
+	}
+}
+
+func TestForwardedClient(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	request := new(dns.Msg)
+	request.SetQuestion("example.com.", dns.TypeA)
+	if forwardedClient(request) != nil {
+		t.Errorf("A request without edns0 should not have a forwarded client")
+	}
+
+	request.SetEdns0(4096, false)
+	opt := request.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.168.5.0")})
+	if subnet := forwardedClient(request); subnet == nil || subnet.Address != nil || !subnet.Subnet.Equal(net.ParseIP("192.168.5.0")) || subnet.SubnetBits != 24 {
+		t.Errorf("A client subnet that is not a single address should be forwarded without identifying a client")
+	}
+
+	opt.Option = []dns.EDNS0{
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: net.ParseIP("192.168.5.10")},
+		&dns.EDNS0_LOCAL{Code: edns0MACOption, Data: []byte(mac)},
+		&dns.EDNS0_LOCAL{Code: edns0DeviceIDOption, Data: []byte("living-room-tv")},
+	}
+	forwarded := forwardedClient(request)
+	if forwarded == nil {
+		t.Errorf("Expected a forwarded client")
+		return
+	}
+	if !forwarded.Address.Equal(net.ParseIP("192.168.5.10")) {
+		t.Errorf("Expected forwarded address 192.168.5.10 but got %s", forwarded.Address)
+	}
+	if forwarded.MAC.String() != mac.String() {
+		t.Errorf("Expected forwarded mac %s but got %s", mac, forwarded.MAC)
+	}
+	if forwarded.DeviceID != "living-room-tv" {
+		t.Errorf("Expected forwarded device 'living-room-tv' but got '%s'", forwarded.DeviceID)
+	}
+
+	// dnsmasq can also send the hardware address as text or base64
+	for _, data := range [][]byte{[]byte(mac.String()), []byte(base64.StdEncoding.EncodeToString(mac))} {
+		opt.Option = []dns.EDNS0{&dns.EDNS0_LOCAL{Code: edns0MACOption, Data: data}}
+		forwarded := forwardedClient(request)
+		if forwarded == nil || forwarded.MAC.String() != mac.String() {
+			t.Errorf("Expected forwarded mac %s from '%s'", mac, data)
+		}
+	}
+}
+
+// answers every request and keeps the client that the provider said the request was forwarded for
+type forwardingEngine struct {
+	engine.Engine
+	forwarded *engine.ForwardedClient
+}
+
+func (e *forwardingEngine) Handle(address *net.IP, listener net.Addr, protocol string, forwarded *engine.ForwardedClient, request *dns.Msg) (*dns.Msg, *resolver.RequestContext, *resolver.ResolutionResult) {
+	e.forwarded = forwarded
+	response := new(dns.Msg)
+	response.SetReply(request)
+	return response, nil, nil
+}
+
+type testWriter struct {
+	dns.ResponseWriter
+	remote net.Addr
+}
+
+func (writer *testWriter) RemoteAddr() net.Addr {
+	return writer.remote
+}
+
+func (writer *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+
+func (writer *testWriter) WriteMsg(msg *dns.Msg) error {
+	return nil
+}
+
+func TestUntrustedClientSubnetIgnored(t *testing.T) {
+	testEngine := &forwardingEngine{}
+	p := NewProvider(testEngine).(*provider)
+	p.trusted = parseNetworks("Trusted forwarder", []string{"192.168.0.1"})
+
+	request := new(dns.Msg)
+	request.SetQuestion("example.com.", dns.TypeA)
+	request.SetEdns0(4096, false)
+	opt := request.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("10.100.0.0")})
+
+	// any client can add a client subnet but only the one from a trusted forwarder is used
+	p.handle(nil, &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 5353}}, request)
+	if testEngine.forwarded != nil {
+		t.Errorf("Expected the client subnet of an untrusted client to be ignored")
+	}
+
+	p.handle(nil, &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 5353}}, request)
+	if testEngine.forwarded == nil || !testEngine.forwarded.Subnet.Equal(net.ParseIP("10.100.0.0")) || testEngine.forwarded.SubnetBits != 24 {
+		t.Errorf("Expected the client subnet of a trusted forwarder to be used")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -17,25 +18,49 @@ import (
 	"github.com/chrisruffalo/gudgeon/engine"
 )
 
+// a server and the access control list it checks requests against
+type listener struct {
+	server *dns.Server
+	acl    *acl
+}
+
 type provider struct {
-	engine  engine.Engine
-	servers []*dns.Server
+	// guards everything that changes when the configuration is updated
+	mux sync.RWMutex
+
+	engine engine.Engine
+	// listeners from the configured interfaces, keyed by protocol and address, and from systemd activation
+	listeners map[string]*listener
+	activated []*listener
+	// forwarders that are trusted to identify the clients they send requests for
+	trusted []*net.IPNet
+	// response rate limiting for udp, nil when it is not enabled
+	rrl *rrl
+	// the edns0 buffer size advertised to clients and the largest udp response
+	udpSize int
+	// the lists of the network, used for listeners from systemd
+	networkACL *acl
 }
 
 type Provider interface {
 	Host(config *config.GudgeonConfig, engine engine.Engine) error
-	//UpdateConfig(config *GudgeonConfig) error
-	//UpdateEngine(engine *engine.Engine) error
+	UpdateConfig(config *config.GudgeonConfig) error
+	UpdateEngine(engine engine.Engine) error
 	Shutdown() error
 }
 
 func NewProvider(engine engine.Engine) Provider {
 	provider := new(provider)
 	provider.engine = engine
-	provider.servers = make([]*dns.Server, 0)
+	provider.listeners = make(map[string]*listener)
+	provider.activated = make([]*listener, 0)
 	return provider
 }
 
+func listenerKey(netType string, addr string) string {
+	return netType + "://" + addr
+}
+
 func defaultServer() *dns.Server {
 	return &dns.Server{
 		ReadTimeout:  3 * time.Second,
@@ -43,22 +68,37 @@ func defaultServer() *dns.Server {
 	}
 }
 
-func (provider *provider) serve(netType string, addr string) *dns.Server {
+// bind the address before the server is started so that a listener is only kept when it could be opened
+func (provider *provider) serve(netType string, addr string, acl *acl) (*listener, error) {
 	server := defaultServer()
 	server.Addr = addr
 	server.Net = netType
+	l := &listener{server: server, acl: acl}
+	server.Handler = provider.handler(l)
+
+	var err error
+	if "udp" == netType {
+		server.PacketConn, err = net.ListenPacket(netType, addr)
+	} else {
+		server.Listener, err = net.Listen(netType, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	log.Infof("DNS on %s at address: %s", strings.ToUpper(netType), addr)
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			log.Errorf("Failed starting %s server: %s", netType, err.Error())
+		if err := server.ActivateAndServe(); err != nil {
+			log.Errorf("Failed serving %s on %s: %s", netType, addr, err.Error())
 		}
 	}()
-	return server
+	return l, nil
 }
 
-func (provider *provider) listen(listener net.Listener, packetConn net.PacketConn) *dns.Server {
+func (provider *provider) listen(streamListener net.Listener, packetConn net.PacketConn, acl *acl) *listener {
 	server := defaultServer()
+	l := &listener{server: server, acl: acl}
+	server.Handler = provider.handler(l)
 	if packetConn != nil {
 		if t, ok := packetConn.(*net.UDPConn); ok && t != nil {
 			log.Infof("Listen to udp on address: %s", t.LocalAddr().String())
@@ -66,9 +106,9 @@ func (provider *provider) listen(listener net.Listener, packetConn net.PacketCon
 			log.Info("Listen on unspecified datagram")
 		}
 		server.PacketConn = packetConn
-	} else if listener != nil {
-		log.Infof("Listen to tcp on stream: %s", listener.Addr().String())
-		server.Listener = listener
+	} else if streamListener != nil {
+		log.Infof("Listen to tcp on stream: %s", streamListener.Addr().String())
+		server.Listener = streamListener
 	}
 
 	go func() {
@@ -76,16 +116,31 @@ func (provider *provider) listen(listener net.Listener, packetConn net.PacketCon
 			log.Errorf("Failed to listen: %s", err.Error())
 		}
 	}()
-	return server
+	return l
+}
+
+// each listener checks requests against its own access control list, which can change with the configuration
+func (provider *provider) handler(l *listener) dns.Handler {
+	return dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+		provider.mux.RLock()
+		acl := l.acl
+		provider.mux.RUnlock()
+		provider.handle(acl, writer, request)
+	})
 }
 
-func (provider *provider) handle(writer dns.ResponseWriter, request *dns.Msg) {
+func (provider *provider) handle(acl *acl, writer dns.ResponseWriter, request *dns.Msg) {
 	// define response
 	var (
 		address  *net.IP
 		response *dns.Msg
 	)
 
+	// use the same settings for the whole request even if the configuration is updated
+	provider.mux.RLock()
+	currentEngine, trusted, rrl, udpSize := provider.engine, provider.trusted, provider.rrl, provider.udpSize
+	provider.mux.RUnlock()
+
 	// get consumer ip from request
 	protocol := ""
 	if ip, ok := writer.RemoteAddr().(*net.UDPAddr); ok {
@@ -97,10 +152,30 @@ func (provider *provider) handle(writer dns.ResponseWriter, request *dns.Msg) {
 		protocol = "tcp"
 	}
 
+	// requests from networks that can't use the listener never reach the engine
+	if !acl.allowed(address) {
+		provider.countMetric(engine.DeniedQueries)
+		if acl.refuse {
+			response = new(dns.Msg)
+			response.SetReply(request)
+			response.Rcode = dns.RcodeRefused
+			if err := writer.WriteMsg(response); err != nil {
+				log.Errorf("Writing response: %s", err)
+			}
+		}
+		return
+	}
+
+	// a trusted forwarder can say which client the request is for
+	var forwarded *engine.ForwardedClient
+	if inNetworks(trusted, address) {
+		forwarded = forwardedClient(request)
+	}
+
 	// if an engine is available actually provide some resolution
-	if provider.engine != nil {
+	if currentEngine != nil {
 		// make query and get information back for metrics/logging
-		response, _, _ = provider.engine.Handle(address, protocol, request)
+		response, _, _ = currentEngine.Handle(address, writer.LocalAddr(), protocol, forwarded, request)
 	} else {
 		// when no engine defined return that there was a server failure
 		response = new(dns.Msg)
@@ -111,6 +186,30 @@ func (provider *provider) handle(writer dns.ResponseWriter, request *dns.Msg) {
 		log.Errorf("No engine to process request")
 	}
 
+	// a nil response means that the request should be dropped without an answer
+	if response == nil {
+		return
+	}
+
+	// the source of a udp request can be spoofed so udp responses are rate limited
+	if rrl != nil && "udp" == protocol && address != nil {
+		switch rrl.check(*address, response, time.Now()) {
+		case rrlDrop:
+			provider.countMetric(engine.RRLDroppedResponses)
+			if !rrl.config.LogOnly {
+				return
+			}
+		case rrlSlip:
+			provider.countMetric(engine.RRLSlippedResponses)
+			if !rrl.config.LogOnly {
+				response = slipResponse(response)
+			}
+		}
+	}
+
+	// negotiate edns0 and fit udp responses to the client
+	response = fitResponse(request, response, protocol, udpSize)
+
 	// write response to response writer
 	err := writer.WriteMsg(response)
 	if err != nil {
@@ -124,6 +223,18 @@ func (provider *provider) handle(writer dns.ResponseWriter, request *dns.Msg) {
 	}
 }
 
+func (provider *provider) countMetric(name string) {
+	provider.mux.RLock()
+	currentEngine := provider.engine
+	provider.mux.RUnlock()
+	if currentEngine == nil {
+		return
+	}
+	if metrics := currentEngine.Metrics(); metrics != nil {
+		metrics.Get(name).Inc(1)
+	}
+}
+
 func (provider *provider) Host(config *config.GudgeonConfig, engine engine.Engine) error {
 	// get network config
 	netConf := config.Network
@@ -149,56 +260,157 @@ func (provider *provider) Host(config *config.GudgeonConfig, engine engine.Engin
 		return nil
 	}
 
+	provider.mux.Lock()
 	if engine != nil {
 		provider.engine = engine
 	}
+	provider.updateSettings(netConf)
 
-	// global dns handle function
-	dns.HandleFunc(".", provider.handle)
-
-	// open interface connections
+	// open interface connections, systemd only offers them at startup and they are kept until shutdown
 	if *systemdConf.Enabled && len(fileSockets) > 0 {
 		for _, f := range fileSockets {
 			// check that the port that systemd is offering is in the range of ports accepted for dns by systemd
 			for _, port := range *systemdConf.DnsPorts {
 				// check if udp
 				if pc, err := net.FilePacketConn(f); err == nil && strings.HasSuffix(pc.LocalAddr().String(), fmt.Sprintf(":%d", port)) {
-					provider.servers = append(provider.servers, provider.listen(nil, pc))
+					provider.activated = append(provider.activated, provider.listen(nil, pc, provider.networkACL))
 					_ = f.Close()
 				} else if pc, err := net.FileListener(f); err == nil && strings.HasSuffix(pc.Addr().String(), fmt.Sprintf(":%d", port)) { // then check if tcp
-					provider.servers = append(provider.servers, provider.listen(pc, nil))
+					provider.activated = append(provider.activated, provider.listen(pc, nil, provider.networkACL))
 					_ = f.Close()
 				}
 			}
 		}
 	}
+	provider.mux.Unlock()
 
-	if len(interfaces) > 0 {
-		for _, iface := range interfaces {
+	// start the servers for the interfaces
+	return provider.UpdateConfig(config)
+}
 
-			addr := fmt.Sprintf("%s:%d", iface.IP, iface.Port)
-			if *iface.TCP {
-				provider.servers = append(provider.servers, provider.serve("tcp", addr))
+// settings that come from the network configuration, must be called while holding the lock
+func (provider *provider) updateSettings(netConf *config.GudgeonNetwork) {
+	provider.trusted = parseNetworks("Trusted forwarder", netConf.TrustedForwarders)
+	// keep the responses counted so far unless the limits changed
+	if provider.rrl == nil || !reflect.DeepEqual(provider.rrl.config, netConf.RRL) {
+		provider.rrl = newRRL(netConf.RRL)
+	}
+	provider.udpSize = netConf.UDPSize
+
+	// listeners from systemd use the lists of the network
+	provider.networkACL = newACL(netConf.Allow, netConf.Deny, netConf.DenyResponse)
+	for _, l := range provider.activated {
+		l.acl = provider.networkACL
+	}
+}
+
+// compare the configured interfaces with the listeners that are running, servers for interfaces that were removed are
+// shut down before servers for new interfaces are started and listeners that are still configured keep running with
+// the access control list of the new configuration
+func (provider *provider) UpdateConfig(config *config.GudgeonConfig) error {
+	if config == nil || config.Network == nil {
+		return fmt.Errorf("No network configuration to update listeners from")
+	}
+	netConf := config.Network
+
+	// the access control list for each protocol and address that should be served
+	wanted := make(map[string]*acl)
+	addresses := make([][2]string, 0, 2*len(netConf.Interfaces))
+	for _, iface := range netConf.Interfaces {
+		addr := fmt.Sprintf("%s:%d", iface.IP, iface.Port)
+		ifaceACL := newACL(iface.Allow, iface.Deny, iface.DenyResponse)
+		for _, netType := range []string{"tcp", "udp"} {
+			if ("tcp" == netType && !*iface.TCP) || ("udp" == netType && !*iface.UDP) {
+				continue
 			}
-			if *iface.UDP {
-				provider.servers = append(provider.servers, provider.serve("udp", addr))
+			key := listenerKey(netType, addr)
+			if _, found := wanted[key]; !found {
+				addresses = append(addresses, [2]string{netType, addr})
 			}
+			wanted[key] = ifaceACL
 		}
 	}
 
+	provider.mux.Lock()
+	provider.updateSettings(netConf)
+	removed := make([]*dns.Server, 0)
+	for key, l := range provider.listeners {
+		if acl, found := wanted[key]; found {
+			l.acl = acl
+		} else {
+			removed = append(removed, l.server)
+			delete(provider.listeners, key)
+		}
+	}
+	provider.mux.Unlock()
+
+	// the removed servers need to let go of their addresses before a new server can use them
+	shutdownServers(removed, 10*time.Second)
+
+	provider.mux.Lock()
+	defer provider.mux.Unlock()
+	failed := make([]string, 0)
+	for _, address := range addresses {
+		key := listenerKey(address[0], address[1])
+		if _, found := provider.listeners[key]; !found {
+			l, err := provider.serve(address[0], address[1], wanted[key])
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s\n", err))
+				continue
+			}
+			provider.listeners[key] = l
+		}
+	}
+
+	// the listeners that could not be opened are tried again with the next configuration
+	if len(failed) > 0 {
+		return fmt.Errorf("Could not listen on every interface:\n%s", strings.Join(failed, ""))
+	}
+
+	return nil
+}
+
+// use a different engine for new requests, requests that are being handled finish with the old engine
+func (provider *provider) UpdateEngine(engine engine.Engine) error {
+	provider.mux.Lock()
+	defer provider.mux.Unlock()
+	provider.engine = engine
 	return nil
 }
 
 func (provider *provider) Shutdown() error {
-	// set with a 60 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	provider.mux.Lock()
+	servers := make([]*dns.Server, 0, len(provider.listeners)+len(provider.activated))
+	for key, l := range provider.listeners {
+		servers = append(servers, l.server)
+		delete(provider.listeners, key)
+	}
+	for _, l := range provider.activated {
+		servers = append(servers, l.server)
+	}
+	provider.activated = make([]*listener, 0)
+	provider.mux.Unlock()
+
+	shutdownServers(servers, 10*time.Second)
+
+	return nil
+}
+
+// shut down the servers at the same time and wait for all of them to stop
+func shutdownServers(servers []*dns.Server, timeout time.Duration) {
+	if len(servers) < 1 {
+		return
+	}
+
+	// set with a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	// start a waitgroup
 	wg := &sync.WaitGroup{}
 
 	// shutdown sources
-	for _, server := range provider.servers {
+	for _, server := range servers {
 		// stop each server separately
 		if server != nil {
 			// add newly started go function to wg
@@ -218,6 +430,4 @@ func (provider *provider) Shutdown() error {
 
 	// wait for group to be done
 	wg.Wait()
-
-	return nil
 }
@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"net"
 	"testing"
 	"time"
 
@@ -130,3 +131,107 @@ func TestProviderLocalResolution(t *testing.T) {
 		source.Close()
 	}
 }
+
+func TestProviderUpdateConfig(t *testing.T) {
+	config := testutil.TestConf(t, "./testdata/provider-test.yml")
+
+	// prepare engine with config options
+	engine, err := engine.NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not build engine: %s", err)
+		return
+	}
+
+	// create a new provider and start hosting
+	p := NewProvider(engine)
+	err = p.Host(config, engine)
+	if err != nil {
+		t.Errorf("Creating test provider: %s", err)
+		return
+	}
+	defer p.Shutdown()
+	time.Sleep(time.Second)
+
+	ask := func(address string) error {
+		m := new(dns.Msg)
+		m.SetQuestion("google.com.", dns.TypeA)
+		client := &dns.Client{Net: "tcp", Timeout: time.Second}
+		_, _, err := client.Exchange(m, address)
+		return err
+	}
+	if err := ask("127.0.0.1:25353"); err != nil {
+		t.Errorf("Could not query the configured listener: %s", err)
+	}
+
+	// add a listener on another port
+	original := p.(*provider).listeners[listenerKey("tcp", "127.0.0.1:25353")]
+	added := *config.Network.Interfaces[0]
+	added.Port = 25354
+	config.Network.Interfaces = append(config.Network.Interfaces, &added)
+	if err := p.UpdateConfig(config); err != nil {
+		t.Errorf("Could not update the provider: %s", err)
+	}
+	time.Sleep(time.Second)
+	if err := ask("127.0.0.1:25354"); err != nil {
+		t.Errorf("Could not query the added listener: %s", err)
+	}
+	if unchanged := p.(*provider).listeners[listenerKey("tcp", "127.0.0.1:25353")]; unchanged != original {
+		t.Errorf("Expected the unchanged listener to keep running")
+	}
+
+	// remove the original listener
+	config.Network.Interfaces = config.Network.Interfaces[1:]
+	if err := p.UpdateConfig(config); err != nil {
+		t.Errorf("Could not update the provider: %s", err)
+	}
+	if err := ask("127.0.0.1:25353"); err == nil {
+		t.Errorf("Expected the removed listener to be shut down")
+	}
+	if err := ask("127.0.0.1:25354"); err != nil {
+		t.Errorf("Could not query the remaining listener: %s", err)
+	}
+	if len(p.(*provider).listeners) != 2 {
+		t.Errorf("Expected a tcp and a udp listener but found %d", len(p.(*provider).listeners))
+	}
+}
+
+func TestProviderListenFailure(t *testing.T) {
+	config := testutil.TestConf(t, "./testdata/provider-test.yml")
+
+	// prepare engine with config options
+	engine, err := engine.NewEngine(config)
+	if err != nil {
+		t.Errorf("Could not build engine: %s", err)
+		return
+	}
+
+	// something else already has the tcp port
+	taken, err := net.Listen("tcp", "127.0.0.1:25355")
+	if err != nil {
+		t.Errorf("Could not take the port: %s", err)
+		return
+	}
+	defer taken.Close()
+
+	config.Network.Interfaces[0].Port = 25355
+	p := NewProvider(engine)
+	defer p.Shutdown()
+	if err := p.Host(config, engine); err == nil {
+		t.Errorf("Expected an error for the port that is taken")
+	}
+	if _, found := p.(*provider).listeners[listenerKey("tcp", "127.0.0.1:25355")]; found {
+		t.Errorf("Expected the listener that could not be opened to not be kept")
+	}
+	if _, found := p.(*provider).listeners[listenerKey("udp", "127.0.0.1:25355")]; !found {
+		t.Errorf("Expected the udp listener to be kept")
+	}
+
+	// the listener is opened once the port is free
+	_ = taken.Close()
+	if err := p.UpdateConfig(config); err != nil {
+		t.Errorf("Could not update the provider: %s", err)
+	}
+	if _, found := p.(*provider).listeners[listenerKey("tcp", "127.0.0.1:25355")]; !found {
+		t.Errorf("Expected the tcp listener to be opened")
+	}
+}
@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+const (
+	// what happens to a response after it is checked
+	rrlSend = iota
+	rrlDrop
+	rrlSlip
+
+	// kinds of responses, errors are counted for a network without the name
+	rrlAnswer   = "answer"
+	rrlNxdomain = "nxdomain"
+	rrlError    = "error"
+)
+
+type rrlBucket struct {
+	// credit for responses, negative while the network is over the limit
+	balance float64
+	updated time.Time
+	// limited responses since the network went over the limit, used for slip
+	limited int
+}
+
+// response rate limiting counts identical responses (the same name, type, and kind of response) to a network of
+// clients so that spoofed queries can't be used to send a flood of responses to a target
+type rrl struct {
+	config *config.GudgeonRRL
+
+	mux       sync.Mutex
+	buckets   map[string]*rrlBucket
+	lastSweep time.Time
+}
+
+func newRRL(conf *config.GudgeonRRL) *rrl {
+	if conf == nil || conf.Enabled == nil || !*conf.Enabled {
+		return nil
+	}
+	return &rrl{
+		config:    conf,
+		buckets:   make(map[string]*rrlBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// the network of the client, the name, the type, and the kind of the response
+func (rrl *rrl) key(address net.IP, response *dns.Msg) (string, float64) {
+	var network net.IP
+	if ip4 := address.To4(); ip4 != nil {
+		network = ip4.Mask(net.CIDRMask(rrl.config.IPv4PrefixLength, 8*net.IPv4len))
+	} else {
+		network = address.Mask(net.CIDRMask(rrl.config.IPv6PrefixLength, 8*net.IPv6len))
+	}
+
+	kind, rate := rrlAnswer, rrl.config.ResponsesPerSecond
+	switch response.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		kind, rate = rrlNxdomain, rrl.config.NxdomainsPerSecond
+	default:
+		kind, rate = rrlError, rrl.config.ErrorsPerSecond
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(network.String())
+	builder.WriteString("|")
+	builder.WriteString(kind)
+	if rrlError != kind && len(response.Question) > 0 {
+		builder.WriteString("|")
+		builder.WriteString(strings.ToLower(response.Question[0].Name))
+		builder.WriteString("|")
+		builder.WriteString(strconv.Itoa(int(response.Question[0].Qtype)))
+	}
+	return builder.String(), float64(rate)
+}
+
+// debit the response from the bucket for it and decide if it should be sent, dropped, or sent truncated (slip)
+func (rrl *rrl) check(address net.IP, response *dns.Msg, now time.Time) int {
+	key, rate := rrl.key(address, response)
+
+	rrl.mux.Lock()
+	defer rrl.mux.Unlock()
+
+	rrl.sweep(now)
+
+	bucket, found := rrl.buckets[key]
+	if !found {
+		bucket = &rrlBucket{balance: rate, updated: now}
+		rrl.buckets[key] = bucket
+	}
+
+	// credit for the time since the last response, the balance can't go over one second of responses or under
+	// the window so a network has to stop for a while before it isn't limited
+	bucket.balance += now.Sub(bucket.updated).Seconds() * rate
+	if bucket.balance > rate {
+		bucket.balance = rate
+	}
+	bucket.updated = now
+	bucket.balance--
+	if floor := -rate * float64(rrl.config.Window); bucket.balance < floor {
+		bucket.balance = floor
+	}
+
+	if bucket.balance >= 0 {
+		if bucket.limited > 0 {
+			log.Debugf("RRL stopped limiting %s after %d responses", key, bucket.limited)
+			bucket.limited = 0
+		}
+		return rrlSend
+	}
+
+	bucket.limited++
+	if bucket.limited == 1 {
+		if rrl.config.LogOnly {
+			log.Infof("RRL would limit responses for %s", key)
+		} else {
+			log.Infof("RRL limiting responses for %s", key)
+		}
+	}
+	if slip := *rrl.config.Slip; slip > 0 && bucket.limited%slip == 0 {
+		return rrlSlip
+	}
+	return rrlDrop
+}
+
+// remove buckets that have been full for a while, must be called while holding the lock
+func (rrl *rrl) sweep(now time.Time) {
+	if now.Sub(rrl.lastSweep) < time.Minute {
+		return
+	}
+	rrl.lastSweep = now
+	idle := time.Duration(rrl.config.Window+1) * time.Second
+	for key, bucket := range rrl.buckets {
+		if now.Sub(bucket.updated) > idle {
+			delete(rrl.buckets, key)
+		}
+	}
+}
+
+// a truncated response with no records tells a real client to ask again over tcp
+func slipResponse(response *dns.Msg) *dns.Msg {
+	slipped := new(dns.Msg)
+	slipped.Id = response.Id
+	slipped.Response = true
+	slipped.Opcode = response.Opcode
+	slipped.RecursionDesired = response.RecursionDesired
+	slipped.RecursionAvailable = response.RecursionAvailable
+	slipped.Rcode = response.Rcode
+	slipped.Question = response.Question
+	slipped.Truncated = true
+	return slipped
+}
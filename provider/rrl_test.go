@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+func rrlResponse(name string, rcode int) *dns.Msg {
+	request := new(dns.Msg)
+	request.SetQuestion(name, dns.TypeA)
+	response := new(dns.Msg)
+	response.SetReply(request)
+	response.Rcode = rcode
+	if dns.RcodeSuccess == rcode {
+		record, _ := dns.NewRR(name + " 60 IN A 192.0.2.1")
+		response.Answer = append(response.Answer, record)
+	}
+	return response
+}
+
+func TestRRL(t *testing.T) {
+	conf := &config.GudgeonRRL{ResponsesPerSecond: 2, Window: 2}
+	conf.VerifyAndInit()
+	limiter := newRRL(conf)
+	if limiter == nil {
+		t.Errorf("Expected rrl to be enabled")
+		return
+	}
+
+	now := time.Now()
+	client := net.ParseIP("203.0.113.10")
+	neighbor := net.ParseIP("203.0.113.20")
+	other := net.ParseIP("198.51.100.10")
+	answer := rrlResponse("example.com.", dns.RcodeSuccess)
+
+	// the rate is sent and then every other limited response slips
+	expected := []int{rrlSend, rrlSend, rrlDrop, rrlSlip, rrlDrop, rrlSlip}
+	for idx, action := range expected {
+		if got := limiter.check(client, answer, now); got != action {
+			t.Errorf("Expected action %d for response %d but got %d", action, idx, got)
+		}
+	}
+
+	// clients in the same network share the limit, other networks and other names do not
+	if got := limiter.check(neighbor, answer, now); rrlSend == got {
+		t.Errorf("Expected a client in the same network to be limited")
+	}
+	if got := limiter.check(other, answer, now); rrlSend != got {
+		t.Errorf("Expected a client in another network to be sent a response")
+	}
+	if got := limiter.check(client, rrlResponse("other.example.com.", dns.RcodeSuccess), now); rrlSend != got {
+		t.Errorf("Expected a response for another name to be sent")
+	}
+
+	// errors are counted for the network without the name
+	limiter.check(other, rrlResponse("a.example.com.", dns.RcodeServerFailure), now)
+	limiter.check(other, rrlResponse("b.example.com.", dns.RcodeServerFailure), now)
+	if got := limiter.check(other, rrlResponse("c.example.com.", dns.RcodeServerFailure), now); rrlSend == got {
+		t.Errorf("Expected errors for different names to share a limit")
+	}
+
+	// a network over the limit has to make up for the responses it was sent
+	if got := limiter.check(client, answer, now.Add(time.Second)); rrlSend == got {
+		t.Errorf("Expected the network to still be limited after a second")
+	}
+	if got := limiter.check(client, answer, now.Add(5*time.Second)); rrlSend != got {
+		t.Errorf("Expected the network to be sent responses after the window")
+	}
+
+	slipped := slipResponse(answer)
+	if !slipped.Truncated || len(slipped.Answer) > 0 || slipped.Id != answer.Id || len(slipped.Question) != 1 {
+		t.Errorf("Expected a truncated response with only the question")
+	}
+}
+
+func TestRRLDisabled(t *testing.T) {
+	conf := &config.GudgeonRRL{}
+	disabled := false
+	conf.Enabled = &disabled
+	conf.VerifyAndInit()
+	if newRRL(conf) != nil || newRRL(nil) != nil {
+		t.Errorf("Expected rrl to be disabled")
+	}
+}
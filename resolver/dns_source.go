@@ -30,6 +30,12 @@ const requestBuffer = 1
 // how long to wait before timing out the connection
 var defaultDeadline = 350 * time.Millisecond
 
+// the edns0 buffer size asked for from upstream over udp
+const upstreamUDPSize = 1232
+
+// how long a truncated response can take to be asked for again over tcp
+var tcpRetryTimeout = 2 * time.Second
+
 var validProtocols = []string{"udp", "tcp", "tcp-tls"}
 
 type dnsSource struct {
@@ -115,8 +121,9 @@ func (dnsSource *dnsSource) handle(co *dns.Conn, request *dns.Msg) (*dns.Msg, er
 		return nil, err
 	}
 
-	// read response with deadline
+	// read response with deadline, with room for the buffer size asked for
 	_ = co.SetReadDeadline(time.Now().Add(defaultDeadline))
+	co.UDPSize = upstreamUDPSize
 	response, err := co.ReadMsg()
 
 	if response != nil && response.MsgHdr.Id != request.MsgHdr.Id {
@@ -158,12 +165,26 @@ func (dnsSource *dnsSource) Answer(rCon *RequestContext, context *ResolutionCont
 		return nil, nil
 	}
 
-	// forward message without interference
-	response, err := dnsSource.query(request)
+	// forward message without interference, except to ask for responses as large as can be read over udp
+	upstreamRequest := request
+	if "udp" == dnsSource.network {
+		upstreamRequest = withUDPSize(request, upstreamUDPSize)
+	}
+	response, err := dnsSource.query(upstreamRequest)
 	if err != nil {
 		return nil, err
 	}
 
+	// a truncated response is asked for again over tcp
+	if response != nil && response.Truncated && "udp" == dnsSource.network {
+		client := &dns.Client{Net: "tcp", Timeout: tcpRetryTimeout}
+		if tcpResponse, _, tcpErr := client.Exchange(request, dnsSource.remoteAddress); tcpErr != nil {
+			log.Debugf("Retrying truncated response over tcp to %s: %s", dnsSource.remoteAddress, tcpErr)
+		} else if tcpResponse != nil {
+			response = tcpResponse
+		}
+	}
+
 	// do not set reply here (doesn't seem to matter, leaving this comment so nobody decides to do it in the future without cause)
 	// response.SetReply(request)
 
@@ -176,6 +197,21 @@ func (dnsSource *dnsSource) Answer(rCon *RequestContext, context *ResolutionCont
 	return response, nil
 }
 
+// a copy of the request that advertises exactly the given edns0 buffer size, the buffer for reading the response
+// is the same size so a client that advertises more can't get an upstream response that doesn't fit it
+func withUDPSize(request *dns.Msg, size uint16) *dns.Msg {
+	if opt := request.IsEdns0(); opt != nil && opt.UDPSize() == size {
+		return request
+	}
+	request = request.Copy()
+	if opt := request.IsEdns0(); opt != nil {
+		opt.SetUDPSize(size)
+	} else {
+		request.SetEdns0(size, false)
+	}
+	return request
+}
+
 func (dnsSource *dnsSource) Close() {
 	dnsSource.pool.Shutdown()
 }
@@ -1,9 +1,13 @@
 package resolver
 
 import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
 	"github.com/chrisruffalo/gudgeon/testutil"
 	log "github.com/sirupsen/logrus"
-	"testing"
 
 	"github.com/miekg/dns"
 )
@@ -109,3 +113,139 @@ func BenchmarkDnsSourceResolution(b *testing.B) {
 
 	source.Close()
 }
+
+// a local server that truncates every udp response so that the source has to ask again over tcp
+func TestDnsSourceTruncatedRetry(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Could not listen on udp: %s", err)
+		return
+	}
+	port := packetConn.LocalAddr().(*net.UDPAddr).Port
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		_ = packetConn.Close()
+		t.Errorf("Could not listen on tcp: %s", err)
+		return
+	}
+
+	// udp sizes asked for by the source
+	udpSizes := make(chan uint16, 1)
+	handler := dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+		response := new(dns.Msg)
+		response.SetReply(request)
+		if _, isUDP := writer.RemoteAddr().(*net.UDPAddr); isUDP {
+			if opt := request.IsEdns0(); opt != nil {
+				udpSizes <- opt.UDPSize()
+			}
+			response.Truncated = true
+		} else {
+			for idx := 0; idx < 40; idx++ {
+				record, _ := dns.NewRR(fmt.Sprintf("%s 60 IN TXT \"%040d\"", request.Question[0].Name, idx))
+				response.Answer = append(response.Answer, record)
+			}
+		}
+		_ = writer.WriteMsg(response)
+	})
+	udpServer := &dns.Server{PacketConn: packetConn, Handler: handler}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+	go func() { _ = udpServer.ActivateAndServe() }()
+	go func() { _ = tcpServer.ActivateAndServe() }()
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	source := NewSource(fmt.Sprintf("127.0.0.1:%d", port))
+	defer source.Close()
+
+	request := new(dns.Msg)
+	request.SetQuestion("large.example.", dns.TypeTXT)
+	response, err := source.Answer(nil, nil, request)
+	if err != nil || response == nil {
+		t.Errorf("Expected a response: %s", err)
+		return
+	}
+	if response.Truncated || len(response.Answer) != 40 {
+		t.Errorf("Expected the full response from tcp but got %d answers (truncated=%t)", len(response.Answer), response.Truncated)
+	}
+	select {
+	case size := <-udpSizes:
+		if size != upstreamUDPSize {
+			t.Errorf("Expected the source to ask for a udp size of %d but got %d", upstreamUDPSize, size)
+		}
+	default:
+		t.Errorf("Expected the udp request to have an opt record")
+	}
+	if request.IsEdns0() != nil {
+		t.Errorf("Expected the request to not be changed")
+	}
+}
+
+func TestDnsSourceLargeClientUDPSize(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Could not listen on udp: %s", err)
+		return
+	}
+	port := packetConn.LocalAddr().(*net.UDPAddr).Port
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		_ = packetConn.Close()
+		t.Errorf("Could not listen on tcp: %s", err)
+		return
+	}
+
+	// the upstream fills as much of the buffer the request advertises as it can
+	udpSizes := make(chan uint16, 1)
+	handler := dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+		response := new(dns.Msg)
+		response.SetReply(request)
+		for idx := 0; idx < 60; idx++ {
+			record, _ := dns.NewRR(fmt.Sprintf("%s 60 IN TXT \"%040d\"", request.Question[0].Name, idx))
+			response.Answer = append(response.Answer, record)
+		}
+		if _, isUDP := writer.RemoteAddr().(*net.UDPAddr); isUDP {
+			size := dns.MinMsgSize
+			if opt := request.IsEdns0(); opt != nil {
+				size = int(opt.UDPSize())
+				udpSizes <- opt.UDPSize()
+			}
+			response.Truncate(size)
+		}
+		_ = writer.WriteMsg(response)
+	})
+	udpServer := &dns.Server{PacketConn: packetConn, Handler: handler}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+	go func() { _ = udpServer.ActivateAndServe() }()
+	go func() { _ = tcpServer.ActivateAndServe() }()
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	source := NewSource(fmt.Sprintf("127.0.0.1:%d", port))
+	defer source.Close()
+
+	// a client that can take a larger response than the source can read
+	request := new(dns.Msg)
+	request.SetQuestion("large.example.", dns.TypeTXT)
+	request.SetEdns0(4096, false)
+	response, err := source.Answer(nil, nil, request)
+	if err != nil || response == nil {
+		t.Errorf("Expected a response: %s", err)
+		return
+	}
+	if response.Truncated || len(response.Answer) != 60 {
+		t.Errorf("Expected the full response from tcp but got %d answers (truncated=%t)", len(response.Answer), response.Truncated)
+	}
+	select {
+	case size := <-udpSizes:
+		if size != upstreamUDPSize {
+			t.Errorf("Expected the source to ask for a udp size of %d but got %d", upstreamUDPSize, size)
+		}
+	default:
+		t.Errorf("Expected the udp request to have an opt record")
+	}
+	if request.IsEdns0().UDPSize() != 4096 {
+		t.Errorf("Expected the request to not be changed")
+	}
+}
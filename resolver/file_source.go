@@ -27,7 +27,7 @@ func (source *fileSource) watchAndLoad() {
 		log.Infof("Loading new source from: '%s'", source.path)
 		source.Load(source.path)
 		// notify of source change
-		events.Send("souce:change", &events.Message{"source": source.Name()})
+		events.Send("source:change", &events.Message{"source": source.Name()})
 	})
 }
 
@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// an address that a public domain should not resolve to because it could be used to reach
+// something on the local network or the local machine (dns rebinding)
+func isRebindAddress(ip net.IP) bool {
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified())
+}
+
+// only answers from dns servers (plain or over tls) are checked, local sources are trusted and
+// answers from other resolvers are checked by those resolvers
+func isUpstreamSource(source Source) bool {
+	switch typed := source.(type) {
+	case *dnsSource:
+		return true
+	case *multiSource:
+		for _, child := range typed.sources {
+			if isUpstreamSource(child) {
+				return true
+			}
+		}
+	case *lbSource:
+		for _, child := range typed.sources {
+			if isUpstreamSource(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove the A/AAAA records that point to rebind addresses from the response, returns the number of records removed
+func stripRebindAnswers(response *dns.Msg) int {
+	if response == nil {
+		return 0
+	}
+
+	stripped := 0
+	filter := func(records []dns.RR) []dns.RR {
+		kept := records[:0]
+		for _, record := range records {
+			var ip net.IP
+			switch typed := record.(type) {
+			case *dns.A:
+				ip = typed.A
+			case *dns.AAAA:
+				ip = typed.AAAA
+			}
+			if isRebindAddress(ip) {
+				stripped++
+				continue
+			}
+			kept = append(kept, record)
+		}
+		return kept
+	}
+	response.Answer = filter(response.Answer)
+	response.Extra = filter(response.Extra)
+
+	return stripped
+}
+
+// strip rebind addresses from an upstream response unless the question is for an allowed domain,
+// when every address in the answer is removed the response is left empty and the next source is tried
+func (resolver *resolver) protectFromRebind(source Source, request *dns.Msg, response *dns.Msg) {
+	if response == nil || len(request.Question) < 1 || !isUpstreamSource(source) {
+		return
+	}
+
+	qname := strings.ToLower(request.Question[0].Name)
+	if len(resolver.rebindAllow) > 0 && domainMatches(qname, resolver.rebindAllow) {
+		return
+	}
+
+	if stripped := stripRebindAnswers(response); stripped > 0 {
+		log.Infof("Removed %d private address(es) from the answer for '%s' given by '%s'", stripped, request.Question[0].Name, source.Name())
+	}
+}
@@ -0,0 +1,110 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/util"
+)
+
+// answers every question with the same (private) address
+func rebindServer(t *testing.T) (*dns.Server, string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen for test server: %s", err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+		response := new(dns.Msg)
+		response.SetReply(request)
+		record, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A 192.168.1.1", request.Question[0].Name))
+		response.Answer = append(response.Answer, record)
+		record, _ = dns.NewRR(fmt.Sprintf("%s 60 IN A 192.0.2.1", request.Question[0].Name))
+		response.Answer = append(response.Answer, record)
+		_ = writer.WriteMsg(response)
+	})}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	return server, conn.LocalAddr().String()
+}
+
+func TestRebindAddresses(t *testing.T) {
+	data := []struct {
+		address string
+		rebind  bool
+	}{
+		{"192.168.1.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"192.0.2.1", false},
+		{"8.8.8.8", false},
+		{"2001:db8::1", false},
+	}
+	for _, d := range data {
+		if isRebindAddress(net.ParseIP(d.address)) != d.rebind {
+			t.Errorf("Expected rebind=%t for %s", d.rebind, d.address)
+		}
+	}
+}
+
+func TestRebindProtection(t *testing.T) {
+	server, address := rebindServer(t)
+	defer server.Shutdown()
+
+	resolverConfig := &config.GudgeonResolver{
+		Name:             "upstream",
+		Sources:          []string{address},
+		RebindProtection: true,
+		RebindAllow:      []string{"*.lan", "plex.direct"},
+	}
+	resolver := newResolver(resolverConfig)
+	defer resolver.Close()
+
+	data := []struct {
+		domain    string
+		addresses int
+	}{
+		{"evil.example.", 1},
+		{"nas.lan.", 2},
+		{"abc.plex.direct.", 2},
+	}
+
+	for _, d := range data {
+		request := new(dns.Msg)
+		request.SetQuestion(d.domain, dns.TypeA)
+		response, err := resolver.Answer(nil, nil, request)
+		if err != nil || response == nil {
+			t.Errorf("Could not resolve '%s': %v", d.domain, err)
+			continue
+		}
+		addresses := util.GetAnswerAddresses(response)
+		if len(addresses) != d.addresses {
+			t.Errorf("Expected %d addresses for '%s' but got %v", d.addresses, d.domain, addresses)
+		}
+		for _, address := range addresses {
+			if d.addresses == 1 && isRebindAddress(address) {
+				t.Errorf("Expected private address to be removed for '%s'", d.domain)
+			}
+		}
+	}
+
+	// without protection all of the answers are kept
+	resolverConfig.RebindProtection = false
+	unprotected := newResolver(resolverConfig)
+	defer unprotected.Close()
+	request := new(dns.Msg)
+	request.SetQuestion("evil.example.", dns.TypeA)
+	if response, _ := unprotected.Answer(nil, nil, request); len(util.GetAnswerAddresses(response)) != 2 {
+		t.Errorf("Expected unprotected resolver to keep all answers")
+	}
+}
@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -24,6 +25,7 @@ type RequestContext struct {
 	Started  time.Time // when the request starts
 	Protocol string    // the protocol that the request came in with
 	Groups   []string  // the groups that belong to the original requester
+	Address  net.IP    // the address of the original requester, if known
 
 	// pool reference for returning
 	pool *sync.Pool
@@ -40,6 +42,7 @@ func DefaultRequestContext() *RequestContext {
 func (context *RequestContext) Put() {
 	// clear values that won't be set
 	context.Groups = make([]string, 0)
+	context.Address = nil
 	// return to pool for reuse
 	if context.pool != nil {
 		context.pool.Put(context)
@@ -106,6 +109,10 @@ type resolver struct {
 	skip    []string
 	search  []string
 	sources []Source
+
+	// rebind protection for answers from upstream sources
+	rebindProtection bool
+	rebindAllow      []string
 }
 
 type Resolver interface {
@@ -139,6 +146,9 @@ func newSharedSourceResolver(configuredResolver *config.GudgeonResolver, configu
 		skip:    configuredResolver.SkipDomains,
 		search:  configuredResolver.Search,
 		sources: make([]Source, 0, len(configuredResolver.Sources)),
+
+		rebindProtection: configuredResolver.RebindProtection,
+		rebindAllow:      configuredResolver.RebindAllow,
 	}
 
 	// add literal hostfile source first source if hosts is configured
@@ -198,6 +208,11 @@ func (resolver *resolver) answer(rCon *RequestContext, context *ResolutionContex
 			continue
 		}
 
+		// remove answers from upstream sources that could be used to rebind to a private address
+		if resolver.rebindProtection {
+			resolver.protectFromRebind(source, request, response)
+		}
+
 		// if the response is not empty and the response is not explicitly NXDOMAIN go on to the next source
 		if !util.IsEmptyResponse(response) {
 			//  update the used resolver
@@ -278,7 +293,9 @@ func domainMatches(questionDomain string, domainsToCheck []string) bool {
 			domain := strings.ToLower(domainsToCheck[idx])
 			// domains that contain a * are glob matches
 			if strings.Contains(domain, "*") {
-				return glob.Glob(domain, questionDomain)
+				if glob.Glob(domain, questionDomain) {
+					return true
+				}
 			} else if domain == questionDomain || strings.HasSuffix(questionDomain, "."+domain) {
 				return true
 			}
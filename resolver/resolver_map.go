@@ -5,9 +5,12 @@ import (
 	"github.com/chrisruffalo/gudgeon/events"
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/twmb/murmur3"
+
 	"github.com/chrisruffalo/gudgeon/cache"
 	"github.com/chrisruffalo/gudgeon/config"
 	"github.com/chrisruffalo/gudgeon/rule"
@@ -19,6 +22,9 @@ type resolverMap struct {
 	// all-resolver response cache
 	cache cache.Cache
 
+	// version of each resolver's configuration, used to decide if a persisted cache partition can be restored
+	versions map[string]string
+
 	// resolver name -> resolver instance map
 	resolvers map[string]Resolver
 
@@ -34,16 +40,20 @@ type ResolverMap interface {
 	AnswerMultiResolvers(rCon *RequestContext, resolverNames []string, request *dns.Msg) (*dns.Msg, *ResolutionResult, error)
 	answerWithContext(rCon *RequestContext, resolverName string, context *ResolutionContext, request *dns.Msg) (*dns.Msg, *ResolutionResult, error)
 	Cache() cache.Cache
+	SaveCache(filePath string) (int, error)
+	LoadCache(filePath string) (int, error)
 	Close()
 }
 
 // returned as part of resolution to get data what actually resolved the query
 type ResolutionResult struct {
-	Cached   bool
-	Consumer string
-	Source   string
-	Resolver string
-	Message  string // errors/panics/context hints
+	Cached    bool
+	Consumer  string
+	ClientMac string // the hardware address of the client when it is a neighbour
+	Schedule  string // the consumer schedule that was active
+	Source    string
+	Resolver  string
+	Message   string // errors/panics/context hints
 
 	// reporting on blocks
 	Blocked bool
@@ -52,6 +62,10 @@ type ResolutionResult struct {
 	Match     rule.Match          // allowed or blocked
 	MatchList *config.GudgeonList // name of blocked list
 	MatchRule string              // name of actual rule
+	Override  string              // the temporary override that decided the match
+
+	// "limited" or "banned" when the query was answered by the rate limiter
+	RateLimit string
 
 	// object reuse
 	pool *sync.Pool
@@ -100,10 +114,13 @@ func NewResolverMap(config *config.GudgeonConfig, configuredResolvers []*config.
 		}
 	}
 
+	// version each resolver so that cache partitions are only carried across unchanged resolvers
+	resolverMap.versions = resolverVersions(config, configuredResolvers)
+
 	// subscribe to source change events and clear cache when it happens
 	// in future we want to have a more segmented/partitioned cache but
 	// for now, blow the whole thing away to see immediate results
-	resolverMap.sourceHandler = events.Listen("souce:change", func(message *events.Message) {
+	resolverMap.sourceHandler = events.Listen("source:change", func(message *events.Message) {
 		if resolverMap.cache != nil {
 			resolverMap.cache.Clear()
 			log.Debugf("Cache flushed due to source change")
@@ -118,8 +135,9 @@ func (resolverMap *resolverMap) result(context *ResolutionContext) *ResolutionRe
 		return nil
 	}
 
-	// set results
+	// set results, clearing anything left over from the last use
 	result := resolverMap.pool.Get().(*ResolutionResult)
+	*result = ResolutionResult{}
 	result.Cached = context.Cached
 	result.Source = context.SourceUsed
 	result.Resolver = context.ResolverUsed
@@ -196,6 +214,66 @@ func (resolverMap *resolverMap) Cache() cache.Cache {
 	return resolverMap.cache
 }
 
+func (resolverMap *resolverMap) SaveCache(filePath string) (int, error) {
+	if resolverMap.cache == nil {
+		return 0, nil
+	}
+	return resolverMap.cache.Save(filePath, resolverMap.versions)
+}
+
+func (resolverMap *resolverMap) LoadCache(filePath string) (int, error) {
+	if resolverMap.cache == nil {
+		return 0, nil
+	}
+	return resolverMap.cache.Load(filePath, resolverMap.versions)
+}
+
+// creates a version string for each resolver from the configuration of the resolver, the configured
+// sources it uses, and the resolvers it uses as sources so that a change to any of them changes the version
+func resolverVersions(conf *config.GudgeonConfig, configuredResolvers []*config.GudgeonResolver) map[string]string {
+	resolverConfigs := make(map[string]*config.GudgeonResolver, len(configuredResolvers))
+	for _, resolverConfig := range configuredResolvers {
+		if resolverConfig != nil && "" != resolverConfig.Name {
+			resolverConfigs[resolverConfig.Name] = resolverConfig
+		}
+	}
+	sourceConfigs := make(map[string]*config.GudgeonSource, len(conf.Sources))
+	for _, sourceConfig := range conf.Sources {
+		if sourceConfig != nil {
+			sourceConfigs[sourceConfig.Name] = sourceConfig
+		}
+	}
+
+	var describe func(builder *strings.Builder, name string, visited map[string]bool)
+	describe = func(builder *strings.Builder, name string, visited map[string]bool) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		if sourceConfig, found := sourceConfigs[name]; found {
+			builder.WriteString(fmt.Sprintf("source:%v;", *sourceConfig))
+			for _, spec := range sourceConfig.Specs {
+				describe(builder, spec, visited)
+			}
+		}
+		if resolverConfig, found := resolverConfigs[name]; found {
+			builder.WriteString(fmt.Sprintf("resolver:%v;", *resolverConfig))
+			for _, spec := range resolverConfig.Sources {
+				describe(builder, spec, visited)
+			}
+		}
+	}
+
+	versions := make(map[string]string, len(resolverConfigs))
+	for name := range resolverConfigs {
+		builder := &strings.Builder{}
+		describe(builder, name, make(map[string]bool))
+		versions[name] = strconv.FormatUint(murmur3.StringSum64(builder.String()), 16)
+	}
+
+	return versions
+}
+
 func (resolverMap *resolverMap) Close() {
 	for _, resolver := range resolverMap.resolvers {
 		resolver.Close()
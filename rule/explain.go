@@ -0,0 +1,226 @@
+package rule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/util"
+)
+
+// how a rule matched the domain
+const (
+	MatchKindExact  = "exact"
+	MatchKindParent = "parent"
+	MatchKindGlob   = "glob"
+	MatchKindRegex  = "regex"
+)
+
+// a single rule that matches a domain
+type RuleMatch struct {
+	// the list that the rule was loaded from
+	List     string `json:"list"`
+	ListType string `json:"listType"`
+	// the rule as it appeared in the list
+	Rule      string `json:"rule"`
+	Kind      string `json:"kind"`
+	Important bool   `json:"important"`
+	Exception bool   `json:"exception"`
+
+	// the list (or companion list) that the rule was found in
+	list *config.GudgeonList
+	text string
+}
+
+// every rule that matches a domain and how the final decision was reached
+type Explanation struct {
+	Domain  string       `json:"domain"`
+	Matches []*RuleMatch `json:"matches"`
+	Match   Match        `json:"match"`
+	List    string       `json:"list"`
+	Rule    string       `json:"rule"`
+	Steps   []string     `json:"steps"`
+}
+
+// a store that can report each stage of the lookup that reached its decision
+type tracingStore interface {
+	traceMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string, []string)
+}
+
+// the stages that a lookup went through, stages are only recorded when a match is being explained
+type matchTrace struct {
+	prefix string
+	steps  []string
+}
+
+// the kind of rules being checked, the same stages are checked for important rules first
+func (trace *matchTrace) scope(prefix string) {
+	if trace != nil {
+		trace.prefix = prefix
+	}
+}
+
+func (trace *matchTrace) add(stage string, lists []*config.GudgeonList, match Match, list *config.GudgeonList, rule string) {
+	if trace == nil {
+		return
+	}
+	checked := fmt.Sprintf("Checked the %s%s of %s", trace.prefix, stage, pluralLists(len(lists)))
+	_, list, rule = attribute(match, list, rule)
+	switch match {
+	case MatchAllow:
+		trace.steps = append(trace.steps, fmt.Sprintf("%s: allowed by rule '%s' from list '%s'", checked, rule, list.CanonicalName()))
+	case MatchBlock:
+		trace.steps = append(trace.steps, fmt.Sprintf("%s: blocked by rule '%s' from list '%s'", checked, rule, list.CanonicalName()))
+	default:
+		trace.steps = append(trace.steps, checked+": no match")
+	}
+}
+
+func newRuleMatch(list *config.GudgeonList, rule string, kind string) *RuleMatch {
+	return &RuleMatch{
+		Rule: rule,
+		Kind: kind,
+		list: list,
+		text: rule,
+	}
+}
+
+// the kind of match for a complex rule
+func complexRuleKind(rule ComplexRule) string {
+	if _, ok := rule.(*regexMatchRule); ok {
+		return MatchKindRegex
+	}
+	return MatchKindGlob
+}
+
+// find every rule in each list that matches the domain or one of its parent domains by asking the store about each
+// list and each name separately, stores that only keep hashes can still report which name matched
+func probeMatches(store Store, lists []*config.GudgeonList, domain string) []*RuleMatch {
+	matches := make([]*RuleMatch, 0)
+	names := util.DomainList(domain)
+	for _, list := range lists {
+		single := []*config.GudgeonList{list}
+		for idx, name := range names {
+			match, matchList, rule := store.FindMatch(single, name)
+			// a parent of the name matched and it will be found on its own turn, and companions
+			// that the store checks along with the list are found when they are probed themselves
+			if MatchNone == match || matchList != list || rule != name {
+				continue
+			}
+			kind := MatchKindExact
+			if idx > 0 {
+				kind = MatchKindParent
+			}
+			matches = append(matches, newRuleMatch(matchList, rule, kind))
+		}
+	}
+	return matches
+}
+
+// the list that a companion list belongs to and how the rules in the companion were marked
+func companionOrigin(list *config.GudgeonList) (*config.GudgeonList, bool, bool) {
+	important := false
+	exception := false
+	for list != nil && list.Parent() != nil {
+		if list == list.Parent().Exceptions() {
+			exception = true
+		} else if list == list.Parent().Important() {
+			important = true
+		}
+		list = list.Parent()
+	}
+	return list, important, exception
+}
+
+func pluralRules(count int) string {
+	if count == 1 {
+		return "1 rule"
+	}
+	return fmt.Sprintf("%d rules", count)
+}
+
+func pluralLists(count int) string {
+	if count == 1 {
+		return "1 list"
+	}
+	return fmt.Sprintf("%d lists", count)
+}
+
+// explain every rule in the given lists that matches the domain along with each stage of the lookup that leads to the decision
+func Explain(store Store, lists []*config.GudgeonList, domain string) *Explanation {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	explanation := &Explanation{
+		Domain:  domain,
+		Matches: make([]*RuleMatch, 0),
+		Steps:   make([]string, 0),
+	}
+	if store == nil || "" == domain {
+		explanation.Steps = append(explanation.Steps, "No rules can match without a rule store and a domain")
+		return explanation
+	}
+
+	// companion lists are loaded from the same source so they are checked along with their lists
+	allLists := withCompanions(lists)
+	order := make(map[*config.GudgeonList]int, len(allLists))
+	for idx, list := range allLists {
+		if origin, _, _ := companionOrigin(list); origin != nil {
+			if _, found := order[origin]; !found {
+				order[origin] = idx
+			}
+		}
+	}
+
+	// report the matches in the order of the lists with the list that the rule came from
+	for _, match := range store.Explain(allLists, domain) {
+		origin, isImportant, isException := companionOrigin(match.list)
+		if origin == nil {
+			continue
+		}
+		match.List = origin.CanonicalName()
+		match.ListType = string(config.BLOCKSTRING)
+		if config.ALLOW == origin.ParsedType() {
+			match.ListType = string(config.ALLOWSTRING)
+		}
+		match.Important = isImportant
+		match.Exception = isException
+		_, _, match.Rule = attribute(MatchNone, match.list, match.text)
+		explanation.Matches = append(explanation.Matches, match)
+	}
+	sort.SliceStable(explanation.Matches, func(i, j int) bool {
+		first, _, _ := companionOrigin(explanation.Matches[i].list)
+		second, _, _ := companionOrigin(explanation.Matches[j].list)
+		return order[first] < order[second]
+	})
+
+	explanation.Steps = append(explanation.Steps, fmt.Sprintf("Found %s in %s that match '%s'", pluralRules(len(explanation.Matches)), pluralLists(len(lists)), domain))
+
+	// the decision comes from the lookup the store itself does so that the explanation can't disagree with it
+	var match Match
+	var matchList *config.GudgeonList
+	var rule string
+	if tracing, ok := store.(tracingStore); ok {
+		var steps []string
+		match, matchList, rule, steps = tracing.traceMatch(lists, domain)
+		explanation.Steps = append(explanation.Steps, steps...)
+	} else {
+		match, matchList, rule = store.FindMatch(lists, domain)
+	}
+	explanation.Match = match
+	explanation.Rule = rule
+	if matchList != nil {
+		explanation.List = matchList.CanonicalName()
+	}
+
+	switch match {
+	case MatchAllow:
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("Allowed by rule '%s' from list '%s'", rule, explanation.List))
+	case MatchBlock:
+		explanation.Steps = append(explanation.Steps, fmt.Sprintf("Blocked by rule '%s' from list '%s'", rule, explanation.List))
+	default:
+		explanation.Steps = append(explanation.Steps, "No rule decides, the domain is not blocked")
+	}
+
+	return explanation
+}
@@ -0,0 +1,84 @@
+package rule
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestExplain(t *testing.T) {
+	for _, storeType := range []string{"memory", "hash", "bloom+hash", "sqlite", "trie", "bloom+mmap"} {
+		tmpDir := testutil.TempDir()
+
+		blockPath := path.Join(tmpDir, "block.txt")
+		if err := ioutil.WriteFile(blockPath, []byte(strings.Join(adblockTestList, "\n")), 0644); err != nil {
+			t.Errorf("Could not write list: %s", err)
+			continue
+		}
+		allowPath := path.Join(tmpDir, "allow.txt")
+		if err := ioutil.WriteFile(allowPath, []byte("ads.com\n/^ads[0-9]+\\.ads\\.com$/\n"), 0644); err != nil {
+			t.Errorf("Could not write list: %s", err)
+			continue
+		}
+
+		blockList := &config.GudgeonList{Name: "adblock", Type: "block", Source: blockPath}
+		blockList.VerifyAndInit()
+		allowList := &config.GudgeonList{Name: "allowed", Type: "allow", Source: allowPath}
+		allowList.VerifyAndInit()
+		conf := &config.GudgeonConfig{
+			Home:    tmpDir,
+			Storage: &config.GudgeonStorage{RuleStorage: storeType},
+			Lists:   []*config.GudgeonList{blockList, allowList},
+		}
+		store, _ := CreateStore(tmpDir, conf)
+
+		expected := []struct {
+			domain  string
+			match   Match
+			rule    string
+			matches []string
+			// the stage of the lookup that decides, when it is the same for every store
+			stage string
+		}{
+			{"other.com", MatchNone, "", []string{}, ""},
+			{"fine.bad.com.", MatchAllow, "@@fine.bad.com$important", []string{"adblock/bad.com/parent", "adblock/bad.com$important/parent", "adblock/@@fine.bad.com$important/exact"}, "Checked the important adblock exceptions of 1 list"},
+			{"ads1.ads.com", MatchAllow, "^ads[0-9]+\\.ads\\.com$", []string{"adblock/ads.com/parent", "allowed/^ads[0-9]+\\.ads\\.com$/regex", "allowed/ads.com/parent"}, "Checked the glob and regex allow rules of 2 lists"},
+			{"a.glob.com", MatchBlock, "*.glob.com", []string{"adblock/*.glob.com/glob"}, ""},
+		}
+
+		for _, e := range expected {
+			explanation := Explain(store, conf.Lists, e.domain)
+			if e.match != explanation.Match || e.rule != explanation.Rule {
+				t.Errorf("Store %s expected match %d by '%s' for '%s' but got %d by '%s'", storeType, e.match, e.rule, e.domain, explanation.Match, explanation.Rule)
+			}
+			found := make([]string, 0, len(explanation.Matches))
+			for _, match := range explanation.Matches {
+				found = append(found, match.List+"/"+match.Rule+"/"+match.Kind)
+			}
+			if strings.Join(e.matches, ",") != strings.Join(found, ",") {
+				t.Errorf("Store %s expected matches %v for '%s' but got %v", storeType, e.matches, e.domain, found)
+			}
+			// the last stage that was checked is the one that decided
+			if len(explanation.Steps) < 3 {
+				t.Errorf("Store %s expected the stages of the lookup for '%s' but got %v", storeType, e.domain, explanation.Steps)
+				continue
+			}
+			decided := explanation.Steps[len(explanation.Steps)-2]
+			verdict := ": no match"
+			if MatchNone != e.match {
+				verdict = " by rule '" + e.rule + "' from list '" + explanation.List + "'"
+			}
+			if !strings.HasSuffix(decided, verdict) || !strings.HasPrefix(decided, e.stage) {
+				t.Errorf("Store %s expected '%s' to be decided by the stage '%s...%s' but got '%s'", storeType, e.domain, e.stage, verdict, decided)
+			}
+		}
+
+		store.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
@@ -0,0 +1,156 @@
+package rule
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/events"
+)
+
+type IpStore interface {
+	// find the list and network that matches any of the given addresses, allow lists are checked before block lists
+	FindMatch(lists []*config.GudgeonList, addresses []net.IP) (Match, *config.GudgeonList, string)
+
+	Close()
+}
+
+type ipStore struct {
+	nets     map[string]*netMap
+	handlers []*events.Handle
+	mux      sync.RWMutex
+}
+
+// load the addresses and networks in the given file, one per line, returns the number of networks loaded
+func loadIpList(listPath string) (*netMap, uint64) {
+	nets := newNetMap()
+
+	file, err := os.Open(listPath)
+	if err != nil {
+		log.Errorf("Could not open ip list file: %s", err)
+		return nets, 0
+	}
+	defer file.Close()
+
+	count := uint64(0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// remove comments
+		if idx := strings.IndexAny(line, "#;!"); idx > -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 1 {
+			continue
+		}
+		network := parseNet(fields[0])
+		if network == nil {
+			log.Debugf("Skipping invalid address or network '%s' in %s", fields[0], listPath)
+			continue
+		}
+		if nets.add(network, network.String()) {
+			count++
+		}
+	}
+
+	return nets, count
+}
+
+// create a store for all of the ip lists in the configuration, the counts are in the same order as the lists
+func CreateIpStore(conf *config.GudgeonConfig) (IpStore, []uint64) {
+	store := &ipStore{
+		nets:     make(map[string]*netMap),
+		handlers: make([]*events.Handle, 0),
+	}
+
+	outputCount := make([]uint64, 0, len(conf.Lists))
+	for _, list := range conf.Lists {
+		if !list.IsIPList() {
+			outputCount = append(outputCount, 0)
+			continue
+		}
+
+		nets, count := loadIpList(conf.PathToList(list))
+		store.nets[list.ShortName()] = nets
+		outputCount = append(outputCount, count)
+
+		// locally scoped variable for list watching
+		watchList := list
+
+		// reload the list when the file changes
+		events.Send("file:watch:start", &events.Message{"path": conf.PathToList(watchList)})
+		handle := events.Listen("file:"+conf.PathToList(watchList), func(message *events.Message) {
+			nets, count := loadIpList(conf.PathToList(watchList))
+			store.mux.Lock()
+			store.nets[watchList.ShortName()] = nets
+			store.mux.Unlock()
+			// send message that a list value changed
+			events.Send("store:list:changed", &events.Message{
+				"listName":      watchList.CanonicalName(),
+				"listShortName": watchList.ShortName(),
+				"count":         count,
+			})
+			// watch file again
+			events.Send("file:watch:start", &events.Message{"path": conf.PathToList(watchList)})
+		})
+		if handle != nil {
+			store.handlers = append(store.handlers, handle)
+		}
+	}
+
+	return store, outputCount
+}
+
+func (store *ipStore) findMatchForType(listType config.ListType, lists []*config.GudgeonList, addresses []net.IP) (*config.GudgeonList, string) {
+	for _, list := range lists {
+		if !list.IsIPList() || listType != list.ParsedType() {
+			continue
+		}
+		nets, found := store.nets[list.ShortName()]
+		if !found {
+			continue
+		}
+		for _, address := range addresses {
+			if network, ok := nets.find(address).(string); ok {
+				return list, network
+			}
+		}
+	}
+	return nil, ""
+}
+
+func (store *ipStore) FindMatch(lists []*config.GudgeonList, addresses []net.IP) (Match, *config.GudgeonList, string) {
+	if len(addresses) < 1 {
+		return MatchNone, nil, ""
+	}
+
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	if list, network := store.findMatchForType(config.ALLOW, lists, addresses); list != nil {
+		return MatchAllow, list, network
+	}
+	if list, network := store.findMatchForType(config.BLOCK, lists, addresses); list != nil {
+		return MatchBlock, list, network
+	}
+
+	return MatchNone, nil, ""
+}
+
+func (store *ipStore) Close() {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	for _, handle := range store.handlers {
+		if handle != nil {
+			handle.Close()
+		}
+	}
+	store.handlers = make([]*events.Handle, 0)
+	store.nets = make(map[string]*netMap)
+}
@@ -0,0 +1,66 @@
+package rule
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestIpStore(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	blockList := &config.GudgeonList{Name: "bad ranges", Kind: "ip", Source: "testdata/ip-block.list"}
+	blockList.VerifyAndInit()
+	allowList := &config.GudgeonList{Name: "good ranges", Type: "allow", Kind: "IP", Source: "testdata/ip-allow.list"}
+	allowList.VerifyAndInit()
+	domainList := &config.GudgeonList{Name: "domains", Source: "testdata/ip-block.list"}
+	domainList.VerifyAndInit()
+
+	conf := &config.GudgeonConfig{
+		Home:  tmpDir,
+		Lists: []*config.GudgeonList{domainList, blockList, allowList},
+	}
+
+	store, counts := CreateIpStore(conf)
+	defer store.Close()
+	if len(counts) != 3 || counts[0] != 0 || counts[1] != 4 || counts[2] != 1 {
+		t.Errorf("Expected to load 4 blocked and 1 allowed networks but loaded %v", counts)
+	}
+
+	data := []struct {
+		addresses []string
+		match     Match
+		list      *config.GudgeonList
+		rule      string
+	}{
+		{[]string{}, MatchNone, nil, ""},
+		{[]string{"192.0.2.1"}, MatchBlock, blockList, "192.0.2.0/24"},
+		{[]string{"203.0.113.1", "192.0.2.1"}, MatchBlock, blockList, "192.0.2.0/24"},
+		{[]string{"192.0.2.200"}, MatchAllow, allowList, "192.0.2.128/25"},
+		{[]string{"198.51.100.7"}, MatchBlock, blockList, "198.51.100.7/32"},
+		{[]string{"198.51.100.8"}, MatchNone, nil, ""},
+		{[]string{"2001:db8::1"}, MatchBlock, blockList, "2001:db8::/32"},
+		{[]string{"2001:db9::1"}, MatchNone, nil, ""},
+		{[]string{"10.20.30.40"}, MatchBlock, blockList, "10.0.0.0/8"},
+	}
+
+	for _, d := range data {
+		addresses := make([]net.IP, 0, len(d.addresses))
+		for _, address := range d.addresses {
+			addresses = append(addresses, net.ParseIP(address))
+		}
+		match, list, rule := store.FindMatch(conf.Lists, addresses)
+		if match != d.match || list != d.list || rule != d.rule {
+			t.Errorf("Expected match %d from %v (%s) for %v but got %d from %v (%s)", d.match, d.list, d.rule, d.addresses, match, list, rule)
+		}
+	}
+
+	// ip lists do not match unless they are part of the given lists
+	if match, _, _ := store.FindMatch([]*config.GudgeonList{domainList}, []net.IP{net.ParseIP("192.0.2.1")}); match != MatchNone {
+		t.Errorf("Expected no match from a domain list")
+	}
+}
@@ -0,0 +1,77 @@
+package rule
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// networks are kept as 16 byte keys in a map for each prefix length so that the longest prefix can be found first
+type netMap struct {
+	prefixes []int
+	nets     map[int]map[string]interface{}
+}
+
+func newNetMap() *netMap {
+	return &netMap{
+		prefixes: make([]int, 0),
+		nets:     make(map[int]map[string]interface{}),
+	}
+}
+
+// add a value for the network, the first value added for a network wins, returns false if the network was already present
+func (nets *netMap) add(network *net.IPNet, value interface{}) bool {
+	ones, bits := network.Mask.Size()
+	// ipv4 addresses are kept as ipv4-in-ipv6 so the prefix is shifted
+	if bits == net.IPv4len*8 {
+		ones += (net.IPv6len - net.IPv4len) * 8
+	}
+	if _, found := nets.nets[ones]; !found {
+		nets.nets[ones] = make(map[string]interface{})
+		nets.prefixes = append(nets.prefixes, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(nets.prefixes)))
+	}
+	key := string(network.IP.To16().Mask(net.CIDRMask(ones, net.IPv6len*8)))
+	if _, found := nets.nets[ones][key]; found {
+		return false
+	}
+	nets.nets[ones][key] = value
+	return true
+}
+
+// find the value for the most specific network that contains the address
+func (nets *netMap) find(address net.IP) interface{} {
+	address = address.To16()
+	if address == nil {
+		return nil
+	}
+	for _, ones := range nets.prefixes {
+		if value, found := nets.nets[ones][string(address.Mask(net.CIDRMask(ones, net.IPv6len*8)))]; found {
+			return value
+		}
+	}
+	return nil
+}
+
+// parse an address or a network in cidr notation, a single address is a network with a full length prefix
+func parseNet(text string) *net.IPNet {
+	text = strings.TrimSpace(text)
+	if "" == text {
+		return nil
+	}
+	if strings.Contains(text, "/") {
+		_, network, err := net.ParseCIDR(text)
+		if err != nil {
+			return nil
+		}
+		return network
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+}
@@ -1,15 +1,20 @@
 package rule
 
 import (
+	"sync"
+
 	"github.com/chrisruffalo/gudgeon/config"
 	"github.com/chrisruffalo/gudgeon/events"
-	"sync"
+	"github.com/chrisruffalo/gudgeon/util"
 )
 
 type reloadingStore struct {
 	handlers []*events.Handle
 	delegate Store
 	mux      sync.RWMutex
+
+	// held while the rules that are kept between runs are in use
+	lock *util.FileLock
 }
 
 func (reloadingStore *reloadingStore) Init(sessionRoot string, config *config.GudgeonConfig, lists []*config.GudgeonList) {
@@ -44,6 +49,15 @@ func (reloadingStore *reloadingStore) Finalize(sessionRoot string, lists []*conf
 	}
 }
 
+// the lock is held from clearing the list until it is finalized
+func (reloadingStore *reloadingStore) replace(sessionRoot string, conf *config.GudgeonConfig, list *config.GudgeonList, rules []string) {
+	if reloadingStore.delegate != nil {
+		reloadingStore.mux.Lock()
+		replaceRules(reloadingStore.delegate, sessionRoot, conf, list, rules)
+		reloadingStore.mux.Unlock()
+	}
+}
+
 func (reloadingStore *reloadingStore) FindMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string) {
 	if reloadingStore.delegate != nil {
 		reloadingStore.mux.RLock()
@@ -53,6 +67,25 @@ func (reloadingStore *reloadingStore) FindMatch(lists []*config.GudgeonList, dom
 	return MatchNone, nil, ""
 }
 
+func (reloadingStore *reloadingStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	if reloadingStore.delegate != nil {
+		reloadingStore.mux.RLock()
+		defer reloadingStore.mux.RUnlock()
+		return reloadingStore.delegate.Explain(lists, domain)
+	}
+	return []*RuleMatch{}
+}
+
+func (reloadingStore *reloadingStore) traceMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string, []string) {
+	if tracing, ok := reloadingStore.delegate.(tracingStore); ok {
+		reloadingStore.mux.RLock()
+		defer reloadingStore.mux.RUnlock()
+		return tracing.traceMatch(lists, domain)
+	}
+	match, list, rule := reloadingStore.FindMatch(lists, domain)
+	return match, list, rule, []string{}
+}
+
 func (reloadingStore *reloadingStore) Close() {
 	if reloadingStore.delegate != nil {
 		reloadingStore.mux.Lock()
@@ -63,6 +96,7 @@ func (reloadingStore *reloadingStore) Close() {
 			}
 		}
 		reloadingStore.delegate.Close()
+		reloadingStore.lock.Release()
 		reloadingStore.mux.Unlock()
 	}
 }
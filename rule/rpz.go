@@ -0,0 +1,386 @@
+package rule
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/events"
+)
+
+// the action that a response policy zone rule takes when triggered
+type RpzAction uint8
+
+const (
+	RpzNone      RpzAction = 0
+	RpzNxdomain  RpzAction = 1
+	RpzNodata    RpzAction = 2
+	RpzPassthru  RpzAction = 3
+	RpzDrop      RpzAction = 4
+	RpzLocalData RpzAction = 5
+
+	// special labels and cname targets used in policy zones
+	rpzClientIPSuffix = ".rpz-client-ip"
+	rpzIPSuffix       = ".rpz-ip"
+	rpzNsdnameSuffix  = ".rpz-nsdname"
+	rpzNsipSuffix     = ".rpz-nsip"
+	rpzPassthruTarget = "rpz-passthru."
+	rpzDropTarget     = "rpz-drop."
+	rpzTcpOnlyTarget  = "rpz-tcp-only."
+	rpzNxdomainTarget = "."
+	rpzNodataTarget   = "*."
+	rpzWildPrefix     = "*."
+)
+
+func (action RpzAction) String() string {
+	switch action {
+	case RpzNxdomain:
+		return "NXDOMAIN"
+	case RpzNodata:
+		return "NODATA"
+	case RpzPassthru:
+		return "PASSTHRU"
+	case RpzDrop:
+		return "DROP"
+	case RpzLocalData:
+		return "LOCAL-DATA"
+	}
+	return "NONE"
+}
+
+// a triggered policy, the records are only present for local data
+type RpzMatch struct {
+	Action  RpzAction
+	List    *config.GudgeonList
+	Trigger string
+	Records []dns.RR
+}
+
+// the rule text that is reported for the match
+func (match *RpzMatch) Rule() string {
+	return fmt.Sprintf("%s (%s)", match.Trigger, match.Action)
+}
+
+// a policy is the trigger (owner name) and the records found at that trigger in the zone
+type rpzPolicy struct {
+	trigger string
+	action  RpzAction
+	records []dns.RR
+}
+
+type rpzZone struct {
+	qnames    map[string]*rpzPolicy
+	wildcards map[string]*rpzPolicy
+	ips       *netMap
+	clientIps *netMap
+}
+
+type RpzStore interface {
+	// find a policy triggered by the client address or the question name, in list order
+	FindMatch(lists []*config.GudgeonList, client net.IP, domain string) *RpzMatch
+
+	// find a policy triggered by any of the addresses in an answer, in list order
+	FindResponseMatch(lists []*config.GudgeonList, addresses []net.IP) *RpzMatch
+
+	Close()
+}
+
+type rpzStore struct {
+	zones    map[string]*rpzZone
+	handlers []*events.Handle
+	mux      sync.RWMutex
+}
+
+// parse the address part of an rpz-ip or rpz-client-ip trigger, the labels are the prefix length followed by the
+// address in reverse order. ipv6 addresses use "zz" in place of "::"
+func parseRpzNet(labels string) *net.IPNet {
+	parts := strings.Split(labels, ".")
+	if len(parts) < 2 {
+		return nil
+	}
+	prefix, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil
+	}
+
+	// reverse the address parts
+	address := make([]string, 0, len(parts)-1)
+	for idx := len(parts) - 1; idx > 0; idx-- {
+		address = append(address, parts[idx])
+	}
+
+	var ip net.IP
+	if len(address) == net.IPv4len && prefix <= net.IPv4len*8 {
+		ip = net.ParseIP(strings.Join(address, ".")).To4()
+	} else if prefix <= net.IPv6len*8 {
+		ip = net.ParseIP(strings.Replace(strings.Join(address, ":"), "zz", ":", 1))
+	}
+	if ip == nil {
+		return nil
+	}
+
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, len(ip)*8)), Mask: net.CIDRMask(prefix, len(ip)*8)}
+}
+
+// load a policy zone from the given file, returns the number of triggers loaded
+func loadRpzZone(zoneFile string) (*rpzZone, uint64) {
+	zone := &rpzZone{
+		qnames:    make(map[string]*rpzPolicy),
+		wildcards: make(map[string]*rpzPolicy),
+		ips:       newNetMap(),
+		clientIps: newNetMap(),
+	}
+
+	file, err := os.Open(zoneFile)
+	if err != nil {
+		log.Errorf("Could not open policy zone file: %s", err)
+		return zone, 0
+	}
+	defer file.Close()
+
+	// collect the records for each owner name
+	origin := ""
+	owners := make([]string, 0)
+	records := make(map[string][]dns.RR)
+
+	zp := dns.NewZoneParser(file, ".", zoneFile)
+	for rr, hasNext := zp.Next(); true; {
+		if zp.Err() != nil {
+			log.Errorf("Parsing policy zone %s: %s", zoneFile, zp.Err())
+			break
+		}
+
+		if rr != nil && rr.Header() != nil {
+			name := strings.ToLower(rr.Header().Name)
+			if soa, ok := rr.(*dns.SOA); ok && soa != nil && "" == origin {
+				// the apex of the zone is removed from each owner to get the trigger
+				origin = name
+			} else if rr.Header().Rrtype != dns.TypeNS {
+				if _, found := records[name]; !found {
+					owners = append(owners, name)
+				}
+				records[name] = append(records[name], rr)
+			}
+		}
+
+		// break if no next element
+		if !hasNext {
+			break
+		}
+		rr, hasNext = zp.Next()
+	}
+
+	count := uint64(0)
+	for _, owner := range owners {
+		trigger := owner
+		if "" != origin && "." != origin {
+			if !strings.HasSuffix(trigger, "."+origin) {
+				continue
+			}
+			trigger = strings.TrimSuffix(trigger, "."+origin)
+		} else {
+			trigger = strings.TrimSuffix(trigger, ".")
+		}
+
+		policy := createRpzPolicy(trigger, records[owner])
+		if policy == nil {
+			continue
+		}
+
+		if strings.HasSuffix(trigger, rpzClientIPSuffix) {
+			if network := parseRpzNet(strings.TrimSuffix(trigger, rpzClientIPSuffix)); network != nil {
+				zone.clientIps.add(network, policy)
+				count++
+			}
+		} else if strings.HasSuffix(trigger, rpzIPSuffix) {
+			if network := parseRpzNet(strings.TrimSuffix(trigger, rpzIPSuffix)); network != nil {
+				zone.ips.add(network, policy)
+				count++
+			}
+		} else if strings.HasSuffix(trigger, rpzNsdnameSuffix) || strings.HasSuffix(trigger, rpzNsipSuffix) {
+			// name server triggers are not supported
+			continue
+		} else if strings.HasPrefix(trigger, rpzWildPrefix) {
+			zone.wildcards[strings.TrimPrefix(trigger, rpzWildPrefix)] = policy
+			count++
+		} else {
+			zone.qnames[trigger] = policy
+			count++
+		}
+	}
+
+	return zone, count
+}
+
+// determine the action for the records at a trigger
+func createRpzPolicy(trigger string, records []dns.RR) *rpzPolicy {
+	policy := &rpzPolicy{
+		trigger: trigger,
+		action:  RpzLocalData,
+		records: make([]dns.RR, 0, len(records)),
+	}
+
+	for _, rr := range records {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			switch strings.ToLower(cname.Target) {
+			case rpzNxdomainTarget:
+				policy.action = RpzNxdomain
+				return policy
+			case rpzNodataTarget:
+				policy.action = RpzNodata
+				return policy
+			case rpzPassthruTarget:
+				policy.action = RpzPassthru
+				return policy
+			case rpzDropTarget:
+				policy.action = RpzDrop
+				return policy
+			case rpzTcpOnlyTarget:
+				// not supported
+				return nil
+			}
+		}
+		policy.records = append(policy.records, rr)
+	}
+
+	if len(policy.records) < 1 {
+		return nil
+	}
+
+	return policy
+}
+
+// create a store of policy zones for all of the rpz lists in the configuration, the counts are in the same order as the lists
+func CreateRpzStore(conf *config.GudgeonConfig) (RpzStore, []uint64) {
+	store := &rpzStore{
+		zones:    make(map[string]*rpzZone),
+		handlers: make([]*events.Handle, 0),
+	}
+
+	outputCount := make([]uint64, 0, len(conf.Lists))
+	for _, list := range conf.Lists {
+		if config.RPZ != list.ParsedType() {
+			outputCount = append(outputCount, 0)
+			continue
+		}
+
+		zone, count := loadRpzZone(conf.PathToList(list))
+		store.zones[list.ShortName()] = zone
+		outputCount = append(outputCount, count)
+
+		// locally scoped variable for list watching
+		watchList := list
+
+		// reload the zone when the file changes
+		events.Send("file:watch:start", &events.Message{"path": conf.PathToList(watchList)})
+		handle := events.Listen("file:"+conf.PathToList(watchList), func(message *events.Message) {
+			zone, count := loadRpzZone(conf.PathToList(watchList))
+			store.mux.Lock()
+			store.zones[watchList.ShortName()] = zone
+			store.mux.Unlock()
+			// send message that a list value changed
+			events.Send("store:list:changed", &events.Message{
+				"listName":      watchList.CanonicalName(),
+				"listShortName": watchList.ShortName(),
+				"count":         count,
+			})
+			// watch file again
+			events.Send("file:watch:start", &events.Message{"path": conf.PathToList(watchList)})
+		})
+		if handle != nil {
+			store.handlers = append(store.handlers, handle)
+		}
+	}
+
+	return store, outputCount
+}
+
+func (zone *rpzZone) findQname(domain string) *rpzPolicy {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	// exact matches win over wildcards
+	if policy, found := zone.qnames[domain]; found {
+		return policy
+	}
+
+	// the most specific wildcard wins
+	for idx := strings.Index(domain, "."); idx > -1; idx = strings.Index(domain, ".") {
+		domain = domain[idx+1:]
+		if policy, found := zone.wildcards[domain]; found {
+			return policy
+		}
+	}
+
+	return nil
+}
+
+// zones for the given lists in list order
+func (store *rpzStore) forEachZone(lists []*config.GudgeonList, action func(list *config.GudgeonList, zone *rpzZone) *rpzPolicy) *RpzMatch {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	for _, list := range lists {
+		if config.RPZ != list.ParsedType() {
+			continue
+		}
+		zone, found := store.zones[list.ShortName()]
+		if !found {
+			continue
+		}
+		if policy := action(list, zone); policy != nil {
+			return &RpzMatch{
+				Action:  policy.action,
+				List:    list,
+				Trigger: policy.trigger,
+				Records: policy.records,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (store *rpzStore) FindMatch(lists []*config.GudgeonList, client net.IP, domain string) *RpzMatch {
+	return store.forEachZone(lists, func(list *config.GudgeonList, zone *rpzZone) *rpzPolicy {
+		// client triggers are checked before name triggers
+		if client != nil {
+			if policy, ok := zone.clientIps.find(client).(*rpzPolicy); ok {
+				return policy
+			}
+		}
+		return zone.findQname(domain)
+	})
+}
+
+func (store *rpzStore) FindResponseMatch(lists []*config.GudgeonList, addresses []net.IP) *RpzMatch {
+	if len(addresses) < 1 {
+		return nil
+	}
+	return store.forEachZone(lists, func(list *config.GudgeonList, zone *rpzZone) *rpzPolicy {
+		for _, address := range addresses {
+			if policy, ok := zone.ips.find(address).(*rpzPolicy); ok {
+				return policy
+			}
+		}
+		return nil
+	})
+}
+
+func (store *rpzStore) Close() {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	for _, handle := range store.handlers {
+		if handle != nil {
+			handle.Close()
+		}
+	}
+	store.handlers = make([]*events.Handle, 0)
+	store.zones = make(map[string]*rpzZone)
+}
@@ -0,0 +1,104 @@
+package rule
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestRpzStore(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	list := &config.GudgeonList{Name: "policy", Type: "rpz", Source: "testdata/rpz-test.db"}
+	list.VerifyAndInit()
+	blockList := &config.GudgeonList{Name: "block", Type: "block", Source: "testdata/rpz-test.db"}
+	blockList.VerifyAndInit()
+
+	conf := &config.GudgeonConfig{
+		Home:  tmpDir,
+		Lists: []*config.GudgeonList{blockList, list},
+	}
+
+	store, counts := CreateRpzStore(conf)
+	defer store.Close()
+	if len(counts) != 2 || counts[0] != 0 || counts[1] != 12 {
+		t.Errorf("Expected to load 12 policy triggers but loaded %v", counts)
+	}
+
+	qnames := []struct {
+		client  string
+		domain  string
+		action  RpzAction
+		trigger string
+	}{
+		{"", "nxdomain.example", RpzNxdomain, "nxdomain.example"},
+		{"", "nxdomain.example.", RpzNxdomain, "nxdomain.example"},
+		{"", "sub.nxdomain.example", RpzNone, ""},
+		{"", "nodata.example", RpzNodata, "nodata.example"},
+		{"", "passthru.example", RpzPassthru, "passthru.example"},
+		{"", "drop.example", RpzDrop, "drop.example"},
+		{"", "local.example", RpzLocalData, "local.example"},
+		{"", "garden.example", RpzLocalData, "garden.example"},
+		{"", "a.wild.example", RpzNxdomain, "*.wild.example"},
+		{"", "a.b.wild.example", RpzNxdomain, "*.wild.example"},
+		{"", "wild.example", RpzNone, ""},
+		{"", "exact.wild.example", RpzPassthru, "exact.wild.example"},
+		{"", "other.example", RpzNone, ""},
+		{"192.168.0.5", "other.example", RpzDrop, "32.5.0.168.192.rpz-client-ip"},
+		{"192.168.0.6", "other.example", RpzNone, ""},
+	}
+
+	for _, q := range qnames {
+		match := store.FindMatch(conf.Lists, net.ParseIP(q.client), q.domain)
+		if RpzNone == q.action {
+			if match != nil {
+				t.Errorf("Expected no policy for '%s' but got %s", q.domain, match.Rule())
+			}
+			continue
+		}
+		if match == nil {
+			t.Errorf("Expected policy %s for '%s' but found none", q.action, q.domain)
+			continue
+		}
+		if match.Action != q.action || match.Trigger != q.trigger || match.List != list {
+			t.Errorf("Expected policy %s (%s) for '%s' but got %s from %s", q.action, q.trigger, q.domain, match.Rule(), match.List)
+		}
+	}
+
+	// local data is kept for the answer
+	if match := store.FindMatch(conf.Lists, nil, "local.example"); match == nil || len(match.Records) != 2 {
+		t.Errorf("Expected two local data records")
+	}
+
+	addresses := []struct {
+		address string
+		action  RpzAction
+	}{
+		{"192.0.2.1", RpzNxdomain},
+		{"192.0.2.2", RpzNone},
+		{"198.51.0.44", RpzLocalData},
+		{"2001:db8::1", RpzNodata},
+		{"2001:db9::1", RpzNone},
+	}
+	for _, a := range addresses {
+		match := store.FindResponseMatch(conf.Lists, []net.IP{net.ParseIP(a.address)})
+		if RpzNone == a.action {
+			if match != nil {
+				t.Errorf("Expected no policy for address %s but got %s", a.address, match.Rule())
+			}
+			continue
+		}
+		if match == nil || match.Action != a.action {
+			t.Errorf("Expected policy %s for address %s but got %v", a.action, a.address, match)
+		}
+	}
+
+	// lists that are not policy zones don't match
+	if match := store.FindMatch([]*config.GudgeonList{blockList}, nil, "nxdomain.example"); match != nil {
+		t.Errorf("Expected no policy from a non-rpz list")
+	}
+}
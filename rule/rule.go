@@ -9,6 +9,9 @@ import (
 const (
 	ruleRegex = "/"
 	ruleGlob  = "*"
+
+	// rules that match every subdomain of a domain but not the domain itself
+	ruleSubdomainGlob = "*."
 )
 
 // parse a line, as from a file, and return the part that represents the rule
@@ -34,5 +37,15 @@ func ParseLine(line string) string {
 }
 
 func IsComplex(ruleText string) bool {
-	return strings.Contains(ruleText, ruleGlob) || (strings.HasPrefix(ruleText, ruleRegex) && strings.HasSuffix(ruleText, ruleRegex))
+	return strings.Contains(ruleText, ruleGlob) || IsRegex(ruleText)
+}
+
+// a regex rule starts and ends with "/"
+func IsRegex(ruleText string) bool {
+	return strings.HasPrefix(ruleText, ruleRegex) && strings.HasSuffix(ruleText, ruleRegex)
+}
+
+// a glob that matches every subdomain of a domain, like "*.example.com", and nothing else
+func isSubdomainWildcard(ruleText string) bool {
+	return strings.HasPrefix(ruleText, ruleSubdomainGlob) && len(ruleText) > len(ruleSubdomainGlob) && !strings.ContainsAny(ruleText[len(ruleSubdomainGlob):], ruleGlob+ruleRegex)
 }
@@ -0,0 +1,211 @@
+package rule
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+const (
+	adblockException = "@@"
+	adblockDomain    = "||"
+	adblockAnchor    = "|"
+	adblockSeparator = "^"
+	adblockModifier  = "$"
+
+	// how many non-empty lines to look at when detecting the format of a list
+	formatDetectLines = 100
+)
+
+// characters that can appear in a domain or a domain glob, anything else is not a dns rule
+var adblockDomainRegex = regexp.MustCompile("^[a-z0-9_.*-]+$")
+
+// a rule parsed from an adblock-style line along with the modifiers that were found
+type adblockRule struct {
+	text      string
+	exception bool
+	important bool
+	badfilter bool
+}
+
+// parse a line in adblock plus / ublock / adguard syntax into a rule, returns nil if the line
+// is a comment or if the line is a rule that can't be applied to dns (cosmetic rules, url paths,
+// or rules with modifiers that restrict the rule to something other than the domain)
+func parseAdblockLine(line string) *adblockRule {
+	line = strings.TrimSpace(line)
+
+	// comments and headers
+	if "" == line || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	// cosmetic rules
+	if strings.Contains(line, "##") || strings.Contains(line, "#@#") || strings.Contains(line, "#?#") || strings.Contains(line, "#$#") {
+		return nil
+	}
+
+	// hosts-style lines are also allowed in adblock lists
+	if strings.ContainsAny(line, " \t") {
+		text := ParseLine(line)
+		if "" == text {
+			return nil
+		}
+		return &adblockRule{text: strings.ToLower(text)}
+	}
+
+	parsed := &adblockRule{}
+	if strings.HasPrefix(line, adblockException) {
+		parsed.exception = true
+		line = line[len(adblockException):]
+	}
+
+	// split modifiers from the pattern, regex rules can contain the modifier character so only look after the closing /
+	pattern := line
+	modifiers := ""
+	searchFrom := 0
+	if strings.HasPrefix(line, ruleRegex) {
+		searchFrom = strings.LastIndex(line, ruleRegex)
+	}
+	if idx := strings.LastIndex(line[searchFrom:], adblockModifier); idx > -1 {
+		pattern = line[:searchFrom+idx]
+		modifiers = line[searchFrom+idx+1:]
+	}
+
+	for _, modifier := range strings.Split(modifiers, ",") {
+		switch strings.ToLower(strings.TrimSpace(modifier)) {
+		case "":
+		case "important":
+			parsed.important = true
+		case "badfilter":
+			parsed.badfilter = true
+		case "all", "document", "doc":
+			// these apply to the whole domain which is what a dns rule does anyway
+		default:
+			// any other modifier limits the rule in a way that can't be honored here
+			return nil
+		}
+	}
+
+	// regex rules are passed along as-is to be handled as complex rules
+	if len(pattern) > 2 && strings.HasPrefix(pattern, ruleRegex) && strings.HasSuffix(pattern, ruleRegex) {
+		parsed.text = pattern
+		return parsed
+	}
+
+	// a rule that starts with || is for the domain and all subdomains, a rule that
+	// starts with | is for only the domain, rules without either need to look like a domain
+	exact := false
+	anchored := false
+	if strings.HasPrefix(pattern, adblockDomain) {
+		pattern = pattern[len(adblockDomain):]
+		anchored = true
+	} else if strings.HasPrefix(pattern, adblockAnchor) {
+		pattern = pattern[len(adblockAnchor):]
+		anchored = true
+		exact = true
+	}
+	pattern = strings.TrimSuffix(pattern, adblockAnchor)
+	if strings.HasSuffix(pattern, adblockSeparator) {
+		pattern = strings.TrimSuffix(pattern, adblockSeparator)
+		anchored = true
+	}
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+
+	// anything with a path, port, or scheme (or that would match everything) is not a dns rule
+	if "" == pattern || strings.Trim(pattern, "*.") == "" || !adblockDomainRegex.MatchString(pattern) {
+		return nil
+	}
+	if !anchored && (!strings.Contains(pattern, ".") || strings.HasPrefix(pattern, ".") || strings.HasPrefix(pattern, "-")) {
+		return nil
+	}
+
+	if exact && !strings.Contains(pattern, ruleGlob) {
+		parsed.text = ruleRegex + "^" + regexp.QuoteMeta(pattern) + "$" + ruleRegex
+	} else {
+		parsed.text = pattern
+	}
+
+	return parsed
+}
+
+// the key that a $badfilter rule uses to disable the rule it matches
+func (parsed *adblockRule) key() string {
+	builder := strings.Builder{}
+	if parsed.exception {
+		builder.WriteString(adblockException)
+	}
+	builder.WriteString(parsed.text)
+	if parsed.important {
+		builder.WriteString(adblockModifier)
+		builder.WriteString("important")
+	}
+	return builder.String()
+}
+
+// the list that the rule should be loaded into, exceptions and important rules in
+// a block list are loaded into companion lists, in an allow list everything is an allow rule
+func (parsed *adblockRule) target(list *config.GudgeonList) *config.GudgeonList {
+	if config.BLOCK != list.ParsedType() {
+		return list
+	}
+	target := list
+	if parsed.important {
+		target = target.Important()
+	}
+	if parsed.exception {
+		target = target.Exceptions()
+	}
+	return target
+}
+
+// looks at the start of the file to see if it is an adblock-style list
+func detectFormat(listPath string, buffer []byte) string {
+	data, err := os.Open(listPath)
+	if err != nil {
+		return config.FormatHosts
+	}
+	defer data.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(data)
+	scanner.Buffer(buffer, len(buffer))
+	for scanner.Scan() && lines < formatDetectLines {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line {
+			continue
+		}
+		if strings.HasPrefix(line, "[Adblock") || strings.HasPrefix(line, "!") || strings.HasPrefix(line, adblockDomain) || strings.HasPrefix(line, adblockException) {
+			return config.FormatAdblock
+		}
+		lines++
+	}
+
+	return config.FormatHosts
+}
+
+// collect the keys of all the rules that are disabled by a $badfilter rule in the file
+func readBadfilters(listPath string, buffer []byte) map[string]bool {
+	badfilters := make(map[string]bool)
+
+	data, err := os.Open(listPath)
+	if err != nil {
+		return badfilters
+	}
+	defer data.Close()
+
+	scanner := bufio.NewScanner(data)
+	scanner.Buffer(buffer, len(buffer))
+	for scanner.Scan() {
+		if !strings.Contains(scanner.Text(), "badfilter") {
+			continue
+		}
+		if parsed := parseAdblockLine(scanner.Text()); parsed != nil && parsed.badfilter {
+			badfilters[parsed.key()] = true
+		}
+	}
+
+	return badfilters
+}
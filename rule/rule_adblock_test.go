@@ -0,0 +1,141 @@
+package rule
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestParseAdblockLine(t *testing.T) {
+	data := []struct {
+		input     string
+		expected  string
+		exception bool
+		important bool
+		badfilter bool
+	}{
+		{"", "", false, false, false},
+		{"! comment", "", false, false, false},
+		{"[Adblock Plus 2.0]", "", false, false, false},
+		{"example.com##.banner", "", false, false, false},
+		{"||example.com^", "example.com", false, false, false},
+		{"||Example.COM^", "example.com", false, false, false},
+		{"||example.com", "example.com", false, false, false},
+		{"@@||example.com^", "example.com", true, false, false},
+		{"||example.com^$important", "example.com", false, true, false},
+		{"@@||example.com^$important", "example.com", true, true, false},
+		{"||example.com^$badfilter", "example.com", false, false, true},
+		{"||example.com^$third-party", "", false, false, false},
+		{"||example.com^$client=192.168.0.1", "", false, false, false},
+		{"||*.example.com^", "*.example.com", false, false, false},
+		{"|example.com^", "/^example\\.com$/", false, false, false},
+		{"||example.com/ads", "", false, false, false},
+		{"/^ads[0-9]+\\.example\\.com$/", "/^ads[0-9]+\\.example\\.com$/", false, false, false},
+		{"/^ads[0-9]+\\.example\\.com$/$important", "/^ads[0-9]+\\.example\\.com$/", false, true, false},
+		{"0.0.0.0 hosts.example.com", "hosts.example.com", false, false, false},
+		{"plain.example.com", "plain.example.com", false, false, false},
+		{"&ad_box_", "", false, false, false},
+		{"||*^", "", false, false, false},
+	}
+
+	for _, d := range data {
+		result := parseAdblockLine(d.input)
+		if "" == d.expected {
+			if result != nil {
+				t.Errorf("Input '%s' should not produce a rule but got '%s'", d.input, result.text)
+			}
+			continue
+		}
+		if result == nil {
+			t.Errorf("Input '%s' should have '%s' but got no rule", d.input, d.expected)
+			continue
+		}
+		if d.expected != result.text || d.exception != result.exception || d.important != result.important || d.badfilter != result.badfilter {
+			t.Errorf("Input '%s' should have '%s' (exception=%t, important=%t, badfilter=%t) but got '%s' (exception=%t, important=%t, badfilter=%t)", d.input, d.expected, d.exception, d.important, d.badfilter, result.text, result.exception, result.important, result.badfilter)
+		}
+	}
+}
+
+var adblockTestList = []string{
+	"! Title: test list",
+	"||ads.com^",
+	"@@||good.ads.com^",
+	"||tracker.com^$important",
+	"@@||tracker.com^",
+	"||bad.com^",
+	"@@||fine.bad.com^$important",
+	"||bad.com^$important",
+	"||mistake.com^",
+	"||mistake.com^$badfilter",
+	"||*.glob.com^",
+	"@@||ok.glob.com^",
+}
+
+func TestAdblockListStores(t *testing.T) {
+	for _, storeType := range []string{"memory", "hash", "bloom+hash", "sqlite", "trie", "bloom+mmap"} {
+		tmpDir := testutil.TempDir()
+
+		listPath := path.Join(tmpDir, "adblock.txt")
+		err := ioutil.WriteFile(listPath, []byte(strings.Join(adblockTestList, "\n")), 0644)
+		if err != nil {
+			t.Errorf("Could not write list: %s", err)
+			continue
+		}
+
+		list := &config.GudgeonList{Name: "adblock", Type: "block", Source: listPath}
+		list.VerifyAndInit()
+		conf := &config.GudgeonConfig{
+			Home:    tmpDir,
+			Storage: &config.GudgeonStorage{RuleStorage: storeType},
+			Lists:   []*config.GudgeonList{list},
+		}
+
+		store, counts := CreateStore(tmpDir, conf)
+		if len(counts) != 1 || counts[0] != 9 {
+			t.Errorf("Store %s expected to load 9 rules but loaded %v", storeType, counts)
+		}
+
+		expected := []struct {
+			domain string
+			match  Match
+			rule   string
+		}{
+			{"ads.com", MatchBlock, "ads.com"},
+			{"sub.ads.com", MatchBlock, "ads.com"},
+			{"good.ads.com", MatchAllow, "@@good.ads.com"},
+			{"more.good.ads.com", MatchAllow, "@@good.ads.com"},
+			{"tracker.com", MatchBlock, "tracker.com$important"},
+			{"bad.com", MatchBlock, "bad.com$important"},
+			{"fine.bad.com", MatchAllow, "@@fine.bad.com$important"},
+			{"mistake.com", MatchNone, ""},
+			{"a.glob.com", MatchBlock, "*.glob.com"},
+			{"ok.glob.com", MatchAllow, "@@ok.glob.com"},
+			{"other.com", MatchNone, ""},
+		}
+
+		for _, e := range expected {
+			match, matchList, rule := store.FindMatch(conf.Lists, e.domain)
+			if e.match != match {
+				t.Errorf("Store %s expected match %d for '%s' but got %d", storeType, e.match, e.domain, match)
+				continue
+			}
+			if MatchNone == match {
+				continue
+			}
+			if matchList != list {
+				t.Errorf("Store %s expected '%s' to be attributed to list %s but got %v", storeType, e.domain, list.ShortName(), matchList)
+			}
+			if e.rule != rule {
+				t.Errorf("Store %s expected rule '%s' for '%s' but got '%s'", storeType, e.rule, e.domain, rule)
+			}
+		}
+
+		store.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
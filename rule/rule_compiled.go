@@ -0,0 +1,220 @@
+package rule
+
+import (
+	"regexp/syntax"
+	"strings"
+)
+
+// the complex rules of a list compiled so that a query only checks the rules that could match it, each rule
+// contributes the longest literal that any match must contain and all of the literals are searched for at once
+type compiledRules struct {
+	rules []ComplexRule
+	// rules that have no literal that a match must contain are always checked
+	always   []int32
+	literals *literalMatcher
+}
+
+func compileRules(rules []ComplexRule) *compiledRules {
+	compiled := &compiledRules{
+		rules:    rules,
+		always:   make([]int32, 0),
+		literals: newLiteralMatcher(),
+	}
+	for idx, rule := range rules {
+		literal := ruleLiteral(rule)
+		if "" == literal {
+			compiled.always = append(compiled.always, int32(idx))
+			continue
+		}
+		compiled.literals.add(literal, int32(idx))
+	}
+	compiled.literals.build()
+	return compiled
+}
+
+// the longest literal that every domain matched by the rule must contain
+func ruleLiteral(rule ComplexRule) string {
+	switch typed := rule.(type) {
+	case *wildcardMatchRule:
+		longest := ""
+		for _, part := range strings.Split(typed.text, ruleGlob) {
+			if len(part) > len(longest) {
+				longest = part
+			}
+		}
+		return longest
+	case *regexMatchRule:
+		parsed, err := syntax.Parse(typed.regexp.String(), syntax.Perl)
+		if err != nil {
+			return ""
+		}
+		return requiredLiteral(parsed.Simplify())
+	}
+	return ""
+}
+
+// the longest literal that must be part of any string that the expression matches
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return ""
+		}
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// literals next to each other in a concatenation are one longer literal
+		longest := ""
+		current := ""
+		for _, sub := range re.Sub {
+			if syntax.OpLiteral == sub.Op && sub.Flags&syntax.FoldCase == 0 {
+				current += string(sub.Rune)
+				continue
+			}
+			if len(current) > len(longest) {
+				longest = current
+			}
+			current = ""
+			if literal := requiredLiteral(sub); len(literal) > len(longest) {
+				longest = literal
+			}
+		}
+		if len(current) > len(longest) {
+			longest = current
+		}
+		return longest
+	}
+	return ""
+}
+
+// the first rule, in list order, that matches the domain
+func (compiled *compiledRules) find(domain string) ComplexRule {
+	var buffer [32]int32
+	candidates := compiled.literals.find(domain, buffer[:0])
+	if len(candidates) < 1 && len(compiled.always) < 1 {
+		return nil
+	}
+	// there are usually only a few candidates
+	for i := 1; i < len(candidates); i++ {
+		for j := i; j > 0 && candidates[j] < candidates[j-1]; j-- {
+			candidates[j], candidates[j-1] = candidates[j-1], candidates[j]
+		}
+	}
+
+	// check the candidates and the rules that are always checked in list order
+	last := int32(-1)
+	for c, a := 0, 0; c < len(candidates) || a < len(compiled.always); {
+		var idx int32
+		if a >= len(compiled.always) || (c < len(candidates) && candidates[c] < compiled.always[a]) {
+			idx = candidates[c]
+			c++
+		} else {
+			idx = compiled.always[a]
+			a++
+		}
+		// the same literal can be found more than once
+		if idx == last {
+			continue
+		}
+		last = idx
+		if compiled.rules[idx].IsMatch(domain) {
+			return compiled.rules[idx]
+		}
+	}
+
+	return nil
+}
+
+// finds all of the literals in a string in a single pass (aho-corasick)
+type literalMatcher struct {
+	edges []map[byte]int32
+	fail  []int32
+	// the rules for the literals that end at each state
+	output [][]int32
+	// the closest state on the failure path that has output
+	next []int32
+}
+
+func newLiteralMatcher() *literalMatcher {
+	return &literalMatcher{
+		edges:  []map[byte]int32{{}},
+		fail:   []int32{0},
+		output: [][]int32{nil},
+		next:   []int32{-1},
+	}
+}
+
+func (matcher *literalMatcher) add(literal string, rule int32) {
+	state := int32(0)
+	for idx := 0; idx < len(literal); idx++ {
+		next, found := matcher.edges[state][literal[idx]]
+		if !found {
+			next = int32(len(matcher.edges))
+			matcher.edges = append(matcher.edges, map[byte]int32{})
+			matcher.fail = append(matcher.fail, 0)
+			matcher.output = append(matcher.output, nil)
+			matcher.next = append(matcher.next, -1)
+			matcher.edges[state][literal[idx]] = next
+		}
+		state = next
+	}
+	matcher.output[state] = append(matcher.output[state], rule)
+}
+
+// link each state to the longest suffix of it that is also a state
+func (matcher *literalMatcher) build() {
+	queue := make([]int32, 0, len(matcher.edges))
+	for _, child := range matcher.edges[0] {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for char, child := range matcher.edges[state] {
+			fail := matcher.fail[state]
+			for {
+				if next, found := matcher.edges[fail][char]; found && next != child {
+					matcher.fail[child] = next
+					break
+				}
+				if 0 == fail {
+					matcher.fail[child] = 0
+					break
+				}
+				fail = matcher.fail[fail]
+			}
+			if len(matcher.output[matcher.fail[child]]) > 0 {
+				matcher.next[child] = matcher.fail[child]
+			} else {
+				matcher.next[child] = matcher.next[matcher.fail[child]]
+			}
+			queue = append(queue, child)
+		}
+	}
+}
+
+// add the rules for every literal found in the value to the found slice
+func (matcher *literalMatcher) find(value string, found []int32) []int32 {
+	state := int32(0)
+	for idx := 0; idx < len(value); idx++ {
+		for {
+			if next, ok := matcher.edges[state][value[idx]]; ok {
+				state = next
+				break
+			}
+			if 0 == state {
+				break
+			}
+			state = matcher.fail[state]
+		}
+		for output := state; output > 0; output = matcher.next[output] {
+			found = append(found, matcher.output[output]...)
+		}
+	}
+	return found
+}
@@ -0,0 +1,92 @@
+package rule
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestRuleLiteral(t *testing.T) {
+	data := []struct {
+		rule    string
+		literal string
+	}{
+		{"*.ads.com", ".ads.com"},
+		{"a*.*.com", ".com"},
+		{"*", ""},
+		{"/^ads[0-9]+\\.example\\.com$/", ".example.com"},
+		{"/^r.*\\..*/", "r"},
+		{"/(tracker|metrics)\\.example/", ".example"},
+		{"/(tracker|metrics)/", ""},
+		{"/(?i)ads\\.com/", ""},
+		{"/(ads){2}/", "ads"},
+		{"/(ads)?x/", "x"},
+	}
+	for _, d := range data {
+		if literal := ruleLiteral(createComplexRule(d.rule)); literal != d.literal {
+			t.Errorf("Expected literal '%s' for rule '%s' but got '%s'", d.literal, d.rule, literal)
+		}
+	}
+}
+
+func TestCompiledRules(t *testing.T) {
+	texts := []string{"*.ads.com", "/^ads[0-9]+\\.example\\.com$/", "/(tracker|metrics)\\./", "a*.*.org", "/^r.*\\..*/", "*track*", "/ads/"}
+	rules := make([]ComplexRule, 0, len(texts))
+	for _, text := range texts {
+		rules = append(rules, createComplexRule(text))
+	}
+	compiled := compileRules(rules)
+
+	domains := []string{"x.ads.com", "ads1.example.com", "ads.example.com", "tracker.net", "metrics.io", "ab.cd.org", "rank.io", "sidetracked.net", "nomatch.net", "ads.net", ""}
+	for idx := 0; idx < 200; idx++ {
+		domains = append(domains, testutil.RandomDomain())
+	}
+
+	// the compiled rules find the same first rule that checking each rule does
+	for _, domain := range domains {
+		var expected ComplexRule
+		for _, rule := range rules {
+			if rule.IsMatch(domain) {
+				expected = rule
+				break
+			}
+		}
+		if found := compiled.find(domain); found != expected {
+			t.Errorf("Expected %v for '%s' but found %v", expected, domain, found)
+		}
+	}
+}
+
+func BenchmarkCompiledRules(b *testing.B) {
+	rules := make([]ComplexRule, 0)
+	for idx := 0; idx < 2500; idx++ {
+		rules = append(rules, createComplexRule(fmt.Sprintf("/^ads%d\\.%s$/", idx, testutil.RandomDomain())))
+		rules = append(rules, createComplexRule(fmt.Sprintf("*.track%d.%s", idx, testutil.RandomDomain())))
+	}
+	compiled := compileRules(rules)
+
+	domains := make([]string, 100)
+	for idx := range domains {
+		domains[idx] = testutil.RandomDomain()
+	}
+
+	b.Run("compiled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			compiled.find(domains[i%len(domains)])
+		}
+	})
+
+	b.Run("linear", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			domain := domains[i%len(domains)]
+			for _, rule := range rules {
+				if rule.IsMatch(domain) {
+					break
+				}
+			}
+		}
+	})
+}
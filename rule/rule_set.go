@@ -0,0 +1,152 @@
+package rule
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+const ruleSetSuffix = ".set"
+
+// the parsed rules of each list are saved along with the hash of the list content so that a list that has not
+// changed since the last run is loaded without reading and parsing the list file again
+type ruleSets struct {
+	root string
+}
+
+// the rule sets in the given directory, the sets of lists that are not configured are removed
+func newRuleSets(root string, lists []*config.GudgeonList) *ruleSets {
+	sets := &ruleSets{root: root}
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		log.Errorf("Could not create rule set directory: %s", err)
+	}
+
+	configured := make(map[string]bool)
+	for _, list := range lists {
+		configured[path.Base(sets.path(list))] = true
+	}
+	files, _ := ioutil.ReadDir(root)
+	for _, file := range files {
+		if !configured[file.Name()] {
+			_ = os.Remove(path.Join(root, file.Name()))
+		}
+	}
+
+	return sets
+}
+
+func (sets *ruleSets) path(list *config.GudgeonList) string {
+	return path.Join(sets.root, base64.RawURLEncoding.EncodeToString([]byte(list.CanonicalName()))+ruleSetSuffix)
+}
+
+// load the saved rules of the list and its companions into the store if they were parsed from content with the
+// given hash, each line of a set is the index of the companion the rule belongs to and the rule
+func (sets *ruleSets) load(store Store, list *config.GudgeonList, hash string, buffer []byte) (uint64, bool) {
+	if sets == nil || "" == hash {
+		return 0, false
+	}
+	data, err := os.Open(sets.path(list))
+	if err != nil {
+		return 0, false
+	}
+	defer data.Close()
+
+	scanner := bufio.NewScanner(data)
+	scanner.Buffer(buffer, len(buffer))
+	if !scanner.Scan() || scanner.Text() != hash {
+		return 0, false
+	}
+
+	targets := withCompanions([]*config.GudgeonList{list})
+	listCounter := uint64(0)
+	for scanner.Scan() {
+		line := scanner.Text()
+		split := strings.IndexByte(line, '\t')
+		if split < 0 {
+			continue
+		}
+		idx, err := strconv.Atoi(line[:split])
+		if err != nil || idx < 0 || idx >= len(targets) {
+			continue
+		}
+		store.Load(targets[idx], line[split+1:])
+		listCounter++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("Reading rule set for list '%s': %s", list.CanonicalName(), err)
+	}
+
+	return listCounter, true
+}
+
+// a store that saves the rules loaded into it for the list and its companions as they are passed along
+func (sets *ruleSets) create(store Store, list *config.GudgeonList, hash string) *ruleSetWriter {
+	writer := &ruleSetWriter{Store: store, targets: make(map[string]int)}
+	if sets == nil || "" == hash {
+		return writer
+	}
+	for idx, target := range withCompanions([]*config.GudgeonList{list}) {
+		writer.targets[target.CanonicalName()] = idx
+	}
+
+	var err error
+	writer.file, err = ioutil.TempFile(sets.root, ".set")
+	if err != nil {
+		log.Errorf("Could not create rule set for list '%s': %s", list.CanonicalName(), err)
+		return writer
+	}
+	writer.path = sets.path(list)
+	writer.writer = bufio.NewWriter(writer.file)
+	_, writer.err = writer.writer.WriteString(hash + "\n")
+
+	return writer
+}
+
+type ruleSetWriter struct {
+	Store
+
+	targets map[string]int
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	err     error
+}
+
+func (writer *ruleSetWriter) Load(list *config.GudgeonList, rule string) {
+	if writer.writer != nil && writer.err == nil {
+		if idx, found := writer.targets[list.CanonicalName()]; found {
+			_, writer.err = writer.writer.WriteString(strconv.Itoa(idx) + "\t" + rule + "\n")
+		}
+	}
+	writer.Store.Load(list, rule)
+}
+
+// put the set in place once every rule has been written, a set that could not be written is discarded
+func (writer *ruleSetWriter) save() {
+	if writer.file == nil {
+		return
+	}
+	err := writer.err
+	if err == nil {
+		err = writer.writer.Flush()
+	}
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(writer.file.Name(), writer.path)
+	}
+	if err != nil {
+		log.Errorf("Could not save rule set '%s': %s", writer.path, err)
+		_ = os.Remove(writer.file.Name())
+	}
+	writer.file = nil
+}
@@ -0,0 +1,67 @@
+package rule
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestRuleSetsSkipUnchangedLists(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	blockPath := path.Join(tmpDir, "block.txt")
+	_ = ioutil.WriteFile(blockPath, []byte("ads.com\n*.glob.com\n"), 0644)
+
+	blockList := &config.GudgeonList{Name: "block", Type: "block", Source: blockPath}
+	blockList.VerifyAndInit()
+	conf := &config.GudgeonConfig{
+		Home:    tmpDir,
+		Storage: &config.GudgeonStorage{RuleStorage: "memory"},
+		Lists:   []*config.GudgeonList{blockList},
+	}
+
+	expect := func(run int, domain string, match Match) {
+		store, counts := CreateStore(tmpDir, conf)
+		defer store.Close()
+		if len(counts) != 1 || counts[0] != 2 {
+			t.Errorf("Run %d expected 2 rules but got %v", run, counts)
+		}
+		if result, _, _ := store.FindMatch(conf.Lists, domain); match != result {
+			t.Errorf("Run %d expected match %d for '%s' but got %d", run, match, domain, result)
+		}
+	}
+
+	expect(1, "ads.com", MatchBlock)
+
+	// the saved rules are used instead of the list file while the list is unchanged
+	sets := &ruleSets{root: path.Join(conf.RulesRoot(), "memory", "lists")}
+	saved, err := ioutil.ReadFile(sets.path(blockList))
+	if err != nil {
+		t.Fatalf("Expected rule set to be saved: %s", err)
+	}
+	_ = ioutil.WriteFile(sets.path(blockList), []byte(listHash(conf, blockList)+"\n0\tother.com\n0\t*.glob.com\n"), 0644)
+	expect(2, "other.com", MatchBlock)
+	expect(2, "ads.com", MatchNone)
+	expect(2, "sub.glob.com", MatchBlock)
+
+	// a changed list is read again
+	_ = ioutil.WriteFile(blockPath, []byte("ads.com\n*.glob.com\n\n"), 0644)
+	expect(3, "ads.com", MatchBlock)
+	expect(3, "other.com", MatchNone)
+	if updated, _ := ioutil.ReadFile(sets.path(blockList)); string(updated) == string(saved) {
+		t.Errorf("Expected rule set to be saved again for the changed list")
+	}
+
+	// sets for lists that are no longer configured are removed
+	conf.Lists = []*config.GudgeonList{}
+	store, _ := CreateStore(tmpDir, conf)
+	store.Close()
+	if _, err := os.Stat(sets.path(blockList)); !os.IsNotExist(err) {
+		t.Errorf("Expected rule set for removed list to be removed")
+	}
+}
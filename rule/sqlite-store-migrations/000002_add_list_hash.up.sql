@@ -0,0 +1,2 @@
+-- the hash of the content that the rules for each list were loaded from so that unchanged lists are not loaded again
+ALTER TABLE lists ADD COLUMN Hash TEXT DEFAULT '';
@@ -2,13 +2,16 @@ package rule
 
 import (
 	"bufio"
+	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/chrisruffalo/gudgeon/config"
 	"github.com/chrisruffalo/gudgeon/events"
+	"github.com/chrisruffalo/gudgeon/util"
 )
 
 // a match can be:
@@ -37,9 +40,52 @@ type Store interface {
 
 	FindMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string)
 
+	// every rule in the lists that matches the domain, not just the first
+	Explain(lists []*config.GudgeonList, domain string) []*RuleMatch
+
 	Close()
 }
 
+// a store that keeps its rules between runs, the rules for lists with unchanged content are not stored again
+type persistentStore interface {
+	Store
+
+	// true if the rules for the lists were stored from content with the given hash
+	isCurrent(lists []*config.GudgeonList, hash string) bool
+
+	// save the hash of the content that the rules for the lists were stored from
+	setCurrent(lists []*config.GudgeonList, hash string)
+}
+
+// a store that can replace every rule of a list at once so that the list is never seen partially loaded
+type replacingStore interface {
+	replace(sessionRoot string, conf *config.GudgeonConfig, list *config.GudgeonList, rules []string)
+}
+
+// clear the list and load the given rules in its place, stores that are shared with running queries swap the
+// rules in while no match is being made
+func ReplaceRules(store Store, sessionRoot string, conf *config.GudgeonConfig, list *config.GudgeonList, rules []string) {
+	if replacing, ok := store.(replacingStore); ok {
+		replacing.replace(sessionRoot, conf, list, rules)
+		return
+	}
+	replaceRules(store, sessionRoot, conf, list, rules)
+}
+
+func replaceRules(store Store, sessionRoot string, conf *config.GudgeonConfig, list *config.GudgeonList, rules []string) {
+	store.Clear(conf, list)
+	for _, rule := range rules {
+		store.Load(list, rule)
+	}
+	store.Finalize(sessionRoot, []*config.GudgeonList{list})
+}
+
+// a backing store that matches rules for every subdomain of a domain ("*.example.com") itself instead of
+// leaving them to be scanned by the complex store
+type wildcardStore interface {
+	matchesWildcards() bool
+}
+
 type baseStore struct {
 	// map of block/allow -> short name -> config list
 	lists map[config.ListType]map[string]*config.GudgeonList
@@ -126,6 +172,37 @@ func (baseStore *baseStore) forEachIn(lists []*config.GudgeonList, listAction ea
 	return actionedListCount
 }
 
+// the given lists along with the exception lists that belong to them and have been loaded
+// into the store, exceptions are allow rules that were found inside of a block list
+func (baseStore *baseStore) withExceptions(lists []*config.GudgeonList) []*config.GudgeonList {
+	allowLists, found := baseStore.lists[config.ALLOW]
+	if !found {
+		return lists
+	}
+
+	// only allocate a new slice when exceptions are found
+	var expanded []*config.GudgeonList
+	for _, list := range lists {
+		if config.BLOCK != list.ParsedType() {
+			continue
+		}
+		exceptions := list.Exceptions()
+		if _, found := allowLists[exceptions.ShortName()]; !found {
+			continue
+		}
+		if expanded == nil {
+			expanded = make([]*config.GudgeonList, len(lists), len(lists)+1)
+			copy(expanded, lists)
+		}
+		expanded = append(expanded, exceptions)
+	}
+
+	if expanded == nil {
+		return lists
+	}
+	return expanded
+}
+
 /**
  * For each list of the given type in the base store that also appears in the list that was given
  * perform an action that can return a match
@@ -139,6 +216,10 @@ func (baseStore *baseStore) matchForEachOfTypeIn(listType config.ListType, lists
 	if _, found := baseStore.lists[listType]; !found {
 		return MatchNone, nil, ""
 	}
+	// allow rules can also come from exceptions inside of block lists
+	if config.ALLOW == listType {
+		lists = baseStore.withExceptions(lists)
+	}
 	for _, v := range lists {
 		if list, found := baseStore.lists[listType][v.ShortName()]; found {
 			m, list, rule := matchAction(listType, list)
@@ -152,16 +233,27 @@ func (baseStore *baseStore) matchForEachOfTypeIn(listType config.ListType, lists
 
 // stores are created from lists of files inside a configuration
 func CreateStore(storeRoot string, conf *config.GudgeonConfig) (Store, []uint64) {
+	return createStore(storeRoot, conf, conf.Storage.RuleStorage, true)
+}
+
+// a store that only holds its rules in memory and writes nothing, the rules that are kept between runs are left
+// alone for the process that is using them
+func CreateMemoryStore(conf *config.GudgeonConfig) (Store, []uint64) {
+	return createStore("", conf, "memory", false)
+}
+
+func createStore(storeRoot string, conf *config.GudgeonConfig, storageType string, keep bool) (Store, []uint64) {
 	// outer shell reloading store
 	store := &reloadingStore{
 		handlers: make([]*events.Handle, 0),
 	}
 
-	// get type of backing store from conf file
-	backingStoreType := strings.ToLower(conf.Storage.RuleStorage)
+	// get type of backing store
+	backingStoreType := strings.ToLower(storageType)
 
-	// create appropriate backing store
+	// create appropriate backing store, the sqlite and mmap stores keep their rules between runs
 	var delegate Store
+	var persistent persistentStore
 	if "bloom+hash" == backingStoreType {
 		bloomStore := new(bloomStore)
 		bloomStore.backingStore = new(hashStore)
@@ -170,24 +262,44 @@ func CreateStore(storeRoot string, conf *config.GudgeonConfig) (Store, []uint64)
 		delegate = new(hashStore)
 		backingStoreType = "hash"
 	} else if "hash+sqlite" == backingStoreType {
+		persistent = new(sqlStore)
 		hashStore := new(hashStore)
-		hashStore.delegate = new(sqlStore)
+		hashStore.delegate = persistent
 		delegate = hashStore
 	} else if "hash32" == backingStoreType {
 		delegate = new(hashStore32)
 	} else if "hash32+sqlite" == backingStoreType {
+		persistent = new(sqlStore)
 		hashStore32 := new(hashStore32)
-		hashStore32.delegate = new(sqlStore)
+		hashStore32.delegate = persistent
 		delegate = hashStore32
 	} else if "sqlite" == backingStoreType || "sql" == backingStoreType {
-		delegate = new(sqlStore)
+		persistent = new(sqlStore)
+		delegate = persistent
 		backingStoreType = "sqlite"
+	} else if "mmap" == backingStoreType {
+		persistent = new(mmapStore)
+		delegate = persistent
+	} else if "bloom+mmap" == backingStoreType {
+		persistent = new(mmapStore)
+		bloomStore := new(bloomStore)
+		bloomStore.backingStore = persistent
+		delegate = bloomStore
+	} else if "hash32+mmap" == backingStoreType {
+		persistent = new(mmapStore)
+		hashStore32 := new(hashStore32)
+		hashStore32.delegate = persistent
+		delegate = hashStore32
+	} else if "trie" == backingStoreType {
+		delegate = new(trieStore)
 	} else if "bloom" == backingStoreType {
 		delegate = new(bloomStore)
 	} else if "bloom+sqlite" == backingStoreType || "bloom+sql" == backingStoreType {
+		persistent = new(sqlStore)
 		bloomStore := new(bloomStore)
-		bloomStore.backingStore = new(sqlStore)
+		bloomStore.backingStore = persistent
 		delegate = bloomStore
+		backingStoreType = "bloom+sqlite"
 	} else {
 		if backingStoreType != "memory" && backingStoreType != "mem" && backingStoreType != "" {
 			log.Warnf("Could not find backing store type '%s', using default memory store instead", backingStoreType)
@@ -201,8 +313,35 @@ func CreateStore(storeRoot string, conf *config.GudgeonConfig) (Store, []uint64)
 	// reloading -> complex -> actual chosen store (which can delegate even further)
 	store.delegate = &complexStore{backingStore: delegate}
 
+	// lists along with their companion lists, which are loaded from the same source, and the
+	// managed lists which are part of the store but have their rules loaded by their owner
+	allLists := withCompanions(conf.Lists)
+	allLists = append(allLists, withCompanions(conf.CustomLists())...)
+
+	// rules are kept between runs for each type of store and only one process can use them at a time, the rules
+	// of a process that can't have them are kept in its own session instead
+	rulesRoot := storeRoot
+	var sets *ruleSets
+	if keep {
+		rulesRoot = path.Join(conf.RulesRoot(), backingStoreType)
+		if err := os.MkdirAll(conf.RulesRoot(), os.ModePerm); err != nil {
+			log.Errorf("Could not create rules directory: %s", err)
+		}
+		lock, err := util.TryLock(rulesRoot + util.LockSuffix)
+		if err != nil {
+			log.Warnf("Rules in '%s' are not available (%s), rules will be loaded into the session", rulesRoot, err)
+			rulesRoot = path.Join(storeRoot, "rules", backingStoreType)
+		}
+		store.lock = lock
+	}
+
 	// initialize stores
-	store.Init(storeRoot, conf, conf.Lists)
+	store.Init(rulesRoot, conf, allLists)
+
+	// the parsed rules of each list
+	if keep {
+		sets = newRuleSets(path.Join(rulesRoot, "lists"), conf.Lists)
+	}
 
 	// load files into stores based on complexity
 	outputCount := make([]uint64, 0, len(conf.Lists))
@@ -210,8 +349,18 @@ func CreateStore(storeRoot string, conf *config.GudgeonConfig) (Store, []uint64)
 	// buffer for reading files
 	var buffer = make([]byte, _loadBufferSize)
 
+	// the hash of the content of each list
+	hashes := make(map[*config.GudgeonList]string)
+
 	for _, list := range conf.Lists {
-		listCounter := loadList(store, conf, list, buffer)
+		// policy zones and ip lists are not loaded as rules
+		if config.RPZ == list.ParsedType() || list.IsIPList() {
+			outputCount = append(outputCount, 0)
+			continue
+		}
+
+		hashes[list] = listHash(conf, list)
+		listCounter := loadHashedList(store, persistent, sets, conf, list, hashes[list], buffer)
 
 		// locally scoped variable for list watching
 		watchList := list
@@ -220,9 +369,20 @@ func CreateStore(storeRoot string, conf *config.GudgeonConfig) (Store, []uint64)
 		events.Send("file:watch:start", &events.Message{"path": conf.PathToList(watchList)})
 		// save handle so it can later be used to close watchers
 		handle := events.Listen("file:"+conf.PathToList(watchList), func(message *events.Message) {
-			store.Clear(conf, watchList)
-			newRuleCount := loadList(store, conf, watchList, buffer)
-			store.Finalize(conf.SessionRoot(), []*config.GudgeonList{watchList})
+			watchLists := withCompanions([]*config.GudgeonList{watchList})
+			for _, clearList := range watchLists {
+				store.Clear(conf, clearList)
+			}
+			hash := listHash(conf, watchList)
+			writer := sets.create(store, watchList, hash)
+			newRuleCount := loadList(writer, conf, watchList, buffer)
+			writer.save()
+			store.Finalize(conf.SessionRoot(), watchLists)
+			if persistent != nil {
+				store.mux.Lock()
+				persistent.setCurrent(watchLists, hash)
+				store.mux.Unlock()
+			}
 			// send message that a list value changed
 			events.Send("store:list:changed", &events.Message{
 				"listName":      watchList.CanonicalName(),
@@ -241,16 +401,83 @@ func CreateStore(storeRoot string, conf *config.GudgeonConfig) (Store, []uint64)
 	}
 
 	// finalize both stores (store finalizes delegate)
-	store.Finalize(storeRoot, conf.Lists)
+	store.Finalize(storeRoot, allLists)
+
+	// remember the content that the kept rules came from
+	if persistent != nil {
+		for list, hash := range hashes {
+			persistent.setCurrent(withCompanions([]*config.GudgeonList{list}), hash)
+		}
+	}
 
 	// finalize and return store
 	return store, outputCount
 }
 
+// load the rules of a list from the rules saved for the same content or, when the list has changed, from the list
+// file, the rules that a persistent store kept for content that has changed are cleared first
+func loadHashedList(store Store, persistent persistentStore, sets *ruleSets, conf *config.GudgeonConfig, list *config.GudgeonList, hash string, buffer []byte) uint64 {
+	companions := withCompanions([]*config.GudgeonList{list})
+	if persistent != nil && !persistent.isCurrent(companions, hash) {
+		for _, companion := range companions {
+			persistent.Clear(conf, companion)
+		}
+	}
+
+	if listCounter, found := sets.load(store, list, hash, buffer); found {
+		log.Debugf("Loaded saved rules for unchanged list '%s'", list.CanonicalName())
+		return listCounter
+	}
+
+	log.Debugf("Storing rules for changed list '%s'", list.CanonicalName())
+	writer := sets.create(store, list, hash)
+	listCounter := loadList(writer, conf, list, buffer)
+	writer.save()
+	return listCounter
+}
+
+// the hash of the list content along with the settings that change how the list is read
+func listHash(conf *config.GudgeonConfig, list *config.GudgeonList) string {
+	hash, err := util.FileHash(conf.PathToList(list))
+	if err != nil {
+		return ""
+	}
+	regex := list.Regex != nil && *list.Regex
+	return fmt.Sprintf("%s:%s:%s:%t", hash, list.Type, list.Format, regex)
+}
+
+// the given rule lists followed by all of their companion lists
+func withCompanions(lists []*config.GudgeonList) []*config.GudgeonList {
+	allLists := make([]*config.GudgeonList, 0, len(lists))
+	for _, list := range lists {
+		if config.RPZ != list.ParsedType() && !list.IsIPList() {
+			allLists = append(allLists, list)
+		}
+	}
+	for _, list := range lists {
+		allLists = append(allLists, list.Companions()...)
+	}
+	return allLists
+}
+
 // load list with a reusable buffer
-func loadList(store Store, config *config.GudgeonConfig, list *config.GudgeonList, buffer []byte) uint64 {
+func loadList(store Store, conf *config.GudgeonConfig, list *config.GudgeonList, buffer []byte) uint64 {
+	listPath := conf.PathToList(list)
+
+	// determine how the list should be read
+	format := list.Format
+	if config.FormatHosts != format && config.FormatAdblock != format {
+		format = detectFormat(listPath, buffer)
+	}
+
+	// adblock rules can be disabled by a $badfilter rule anywhere in the list
+	var badfilters map[string]bool
+	if config.FormatAdblock == format {
+		badfilters = readBadfilters(listPath, buffer)
+	}
+
 	// open file and scan
-	data, err := os.Open(config.PathToList(list))
+	data, err := os.Open(listPath)
 	if err != nil {
 		log.Errorf("Could not open list file: %s", err)
 		return uint64(0)
@@ -262,6 +489,17 @@ func loadList(store Store, config *config.GudgeonConfig, list *config.GudgeonLis
 	scanner := bufio.NewScanner(data)
 	scanner.Buffer(buffer, len(buffer))
 	for scanner.Scan() {
+		if config.FormatAdblock == format {
+			// parsed adblock rules can be loaded into a companion list
+			parsed := parseAdblockLine(scanner.Text())
+			if parsed == nil || parsed.badfilter || badfilters[parsed.key()] {
+				continue
+			}
+			store.Load(parsed.target(list), parsed.text)
+			listCounter++
+			continue
+		}
+
 		text := ParseLine(scanner.Text())
 		if "" != text {
 			// load the text into the store which will load it into the next delegate
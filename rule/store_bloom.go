@@ -126,6 +126,10 @@ func (store *bloomStore) FindMatch(lists []*config.GudgeonList, domain string) (
 	return match, list, rule
 }
 
+func (store *bloomStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	return probeMatches(store, lists, domain)
+}
+
 func (store *bloomStore) Close() {
 	// remove reference to blooms
 	store.blooms = make(map[string]*bloom.BloomFilter)
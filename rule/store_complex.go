@@ -9,10 +9,17 @@ type complexStore struct {
 
 	backingStore Store
 	complexRules map[string][]ComplexRule
+
+	// the rules for each list compiled when the list is finalized
+	compiled map[string]*compiledRules
+
+	// wildcard rules are loaded into the backing store when it can match them
+	wildcards bool
 }
 
 func (store *complexStore) Init(sessionRoot string, config *config.GudgeonConfig, lists []*config.GudgeonList) {
 	store.complexRules = make(map[string][]ComplexRule, 0)
+	store.compiled = make(map[string]*compiledRules)
 
 	for _, list := range lists {
 		if _, found := store.complexRules[list.CanonicalName()]; !found {
@@ -21,12 +28,16 @@ func (store *complexStore) Init(sessionRoot string, config *config.GudgeonConfig
 	}
 
 	if store.backingStore != nil {
+		if wildcards, ok := store.backingStore.(wildcardStore); ok {
+			store.wildcards = wildcards.matchesWildcards()
+		}
 		store.backingStore.Init(sessionRoot, config, lists)
 	}
 }
 
 func (store *complexStore) Clear(config *config.GudgeonConfig, list *config.GudgeonList) {
 	store.complexRules[list.CanonicalName()] = make([]ComplexRule, 0)
+	delete(store.compiled, list.CanonicalName())
 	store.removeList(list)
 
 	if store.backingStore != nil {
@@ -41,11 +52,15 @@ func (store *complexStore) Load(list *config.GudgeonList, rule string) {
 		complexRule = specifyRegexOnlyRule(rule)
 		if complexRule != nil {
 			store.complexRules[list.CanonicalName()] = append(store.complexRules[list.CanonicalName()], complexRule)
+			delete(store.compiled, list.CanonicalName())
 		}
+	} else if store.wildcards && isSubdomainWildcard(rule) {
+		store.backingStore.Load(list, rule)
 	} else if IsComplex(rule) {
 		complexRule = createComplexRule(rule)
 		if complexRule != nil {
 			store.complexRules[list.CanonicalName()] = append(store.complexRules[list.CanonicalName()], complexRule)
+			delete(store.compiled, list.CanonicalName())
 		}
 	} else if store.backingStore != nil {
 		store.backingStore.Load(list, rule)
@@ -54,7 +69,11 @@ func (store *complexStore) Load(list *config.GudgeonList, rule string) {
 }
 
 func (store *complexStore) Finalize(sessionRoot string, lists []*config.GudgeonList) {
-	// no-op
+	for _, list := range lists {
+		if rules := store.complexRules[list.CanonicalName()]; len(rules) > 0 {
+			store.compiled[list.CanonicalName()] = compileRules(rules)
+		}
+	}
 
 	if store.backingStore != nil {
 		store.backingStore.Finalize(sessionRoot, lists)
@@ -62,37 +81,109 @@ func (store *complexStore) Finalize(sessionRoot string, lists []*config.GudgeonL
 }
 
 func (store *complexStore) FindMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string) {
-	match, list, rule := store.matchForEachOfTypeIn(config.ALLOW, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
-		rules, found := store.complexRules[list.CanonicalName()]
-		if !found {
-			return MatchNone, nil, ""
+	return store.tracedMatch(lists, domain, nil)
+}
+
+func (store *complexStore) traceMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string, []string) {
+	trace := &matchTrace{steps: make([]string, 0)}
+	match, list, rule := store.tracedMatch(lists, domain, trace)
+	return match, list, rule, trace.steps
+}
+
+// find the match and record each stage of the lookup in the trace, if there is one
+func (store *complexStore) tracedMatch(lists []*config.GudgeonList, domain string, trace *matchTrace) (Match, *config.GudgeonList, string) {
+	// rules marked important are checked first so that they win over exceptions
+	if important := store.importantLists(lists); len(important) > 0 {
+		trace.scope("important ")
+		if match, list, rule := store.findMatch(important, domain, trace); MatchNone != match {
+			return attribute(match, list, rule)
 		}
+		trace.scope("")
+	}
+	return attribute(store.findMatch(lists, domain, trace))
+}
 
-		for _, rule := range rules {
-			if rule.IsMatch(domain) {
-				return MatchAllow, list, rule.Text()
-			}
+// the important companions of the given lists that have rules loaded
+func (store *complexStore) importantLists(lists []*config.GudgeonList) []*config.GudgeonList {
+	blockLists, found := store.lists[config.BLOCK]
+	if !found {
+		return nil
+	}
+
+	var important []*config.GudgeonList
+	for _, list := range lists {
+		if config.BLOCK != list.ParsedType() || list.Important() == nil {
+			continue
+		}
+		if _, found := blockLists[list.Important().ShortName()]; found {
+			important = append(important, list.Important())
+		}
+	}
+	return important
+}
+
+// matches from companion lists are reported as matches from the list they came from with the rule marked the same way it was in the list
+func attribute(match Match, list *config.GudgeonList, rule string) (Match, *config.GudgeonList, string) {
+	if list == nil || list.Parent() == nil {
+		return match, list, rule
+	}
+	list, important, exception := companionOrigin(list)
+	if exception {
+		rule = adblockException + rule
+	}
+	if important {
+		rule = rule + adblockModifier + "important"
+	}
+	return match, list, rule
+}
+
+// the first rule in the list that matches the domain, lists that have not been finalized since rules were added are checked one rule at a time
+func (store *complexStore) firstMatch(list *config.GudgeonList, domain string) ComplexRule {
+	if compiled, found := store.compiled[list.CanonicalName()]; found {
+		return compiled.find(domain)
+	}
+	for _, rule := range store.complexRules[list.CanonicalName()] {
+		if rule.IsMatch(domain) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (store *complexStore) findMatch(lists []*config.GudgeonList, domain string, trace *matchTrace) (Match, *config.GudgeonList, string) {
+	match, list, rule := store.matchForEachOfTypeIn(config.ALLOW, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
+		if rule := store.firstMatch(list, domain); rule != nil {
+			return MatchAllow, list, rule.Text()
 		}
 		return MatchNone, nil, ""
 	})
+	trace.add("glob and regex allow rules", lists, match, list, rule)
 
 	if MatchNone != match {
 		return match, list, rule
 	}
 
-	match, list, rule = store.matchForEachOfTypeIn(config.BLOCK, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
-		rules, found := store.complexRules[list.CanonicalName()]
-		if !found {
-			return MatchNone, nil, ""
+	// adblock exceptions win over every block rule, even the complex rules of their own list, but can be in the backing store
+	if store.backingStore != nil {
+		if exceptions := store.exceptionLists(lists); len(exceptions) > 0 {
+			match, list, rule := store.backingStore.FindMatch(exceptions, domain)
+			if MatchAllow != match {
+				match = MatchNone
+			}
+			trace.add("adblock exceptions", exceptions, match, list, rule)
+			if MatchAllow == match {
+				return match, list, rule
+			}
 		}
+	}
 
-		for _, rule := range rules {
-			if rule.IsMatch(domain) {
-				return MatchBlock, list, rule.Text()
-			}
+	match, list, rule = store.matchForEachOfTypeIn(config.BLOCK, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
+		if rule := store.firstMatch(list, domain); rule != nil {
+			return MatchBlock, list, rule.Text()
 		}
 		return MatchNone, nil, ""
 	})
+	trace.add("glob and regex block rules", lists, match, list, rule)
 
 	if MatchNone != match {
 		return match, list, rule
@@ -100,12 +191,48 @@ func (store *complexStore) FindMatch(lists []*config.GudgeonList, domain string)
 
 	// delegate to backing store if no result found
 	if store.backingStore != nil {
-		return store.backingStore.FindMatch(lists, domain)
+		match, list, rule = store.backingStore.FindMatch(lists, domain)
+		trace.add("allow and then block rules in the rule store", lists, match, list, rule)
+		return match, list, rule
 	}
 
 	return MatchNone, nil, ""
 }
 
+// the exception lists of the given block lists that have rules loaded, exceptions are the allow rules of adblock lists
+func (store *complexStore) exceptionLists(lists []*config.GudgeonList) []*config.GudgeonList {
+	var exceptions []*config.GudgeonList
+	for _, list := range lists {
+		if config.BLOCK != list.ParsedType() {
+			continue
+		}
+		if store.getList(list.Exceptions().ShortName()) != nil {
+			exceptions = append(exceptions, list.Exceptions())
+		}
+	}
+	return exceptions
+}
+
+func (store *complexStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	matches := make([]*RuleMatch, 0)
+	for _, list := range lists {
+		if store.getList(list.ShortName()) == nil {
+			continue
+		}
+		for _, rule := range store.complexRules[list.CanonicalName()] {
+			if rule.IsMatch(domain) {
+				matches = append(matches, newRuleMatch(list, rule.Text(), complexRuleKind(rule)))
+			}
+		}
+	}
+
+	if store.backingStore != nil {
+		matches = append(matches, store.backingStore.Explain(lists, domain)...)
+	}
+
+	return matches
+}
+
 func (store *complexStore) Close() {
 	// default no-op
 }
@@ -111,6 +111,10 @@ func (store *hashStore) FindMatch(lists []*config.GudgeonList, domain string) (M
 	return match, list, rule
 }
 
+func (store *hashStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	return probeMatches(store, lists, domain)
+}
+
 func (store *hashStore) Close() {
 	// overwrite map with empty map
 	store.hashes = make(map[string]map[uint64]struct{})
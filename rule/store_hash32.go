@@ -111,6 +111,10 @@ func (store *hashStore32) FindMatch(lists []*config.GudgeonList, domain string)
 	return match, list, rule
 }
 
+func (store *hashStore32) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	return probeMatches(store, lists, domain)
+}
+
 func (store *hashStore32) Close() {
 	// overwrite map with empty map
 	store.hashes = make(map[string]map[uint32]struct{})
@@ -105,6 +105,10 @@ func (store *memoryStore) FindMatch(lists []*config.GudgeonList, domain string)
 	return match, list, rule
 }
 
+func (store *memoryStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	return probeMatches(store, lists, domain)
+}
+
 func (store *memoryStore) Close() {
 	// remove reference to rules
 	store.rules = make(map[string][]string)
@@ -0,0 +1,623 @@
+package rule
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/util"
+)
+
+const (
+	mmapMagic  = "GRUL"
+	mmapSuffix = ".rules"
+	// the hash of the list content that the rules file was written from is kept next to it
+	mmapHashSuffix = ".hash"
+	// files that are being written
+	mmapTempPrefix = ".mmap"
+	// rules in a block share prefixes with the rule before them, only the first rule in a block is written in full
+	mmapBlockSize = 16
+	// magic, rule count, block count
+	mmapHeaderSize = 12
+)
+
+// how many rules of a list are held in memory before they are sorted and spilled to a chunk file
+var mmapChunkRules = 64 * 1024
+
+// each list is written to a file of sorted rules that is memory mapped and searched in place so that the
+// rules only take up memory while the pages that hold them are being read
+type mmapStore struct {
+	baseStore
+
+	root  string
+	files map[string]*mmapRules
+
+	// rules loaded since the list was last written
+	pending map[string]*mmapPending
+
+	// the files are kept between runs, these are the lists that already have their rules written for the content
+	// they are loaded from so loading them again is skipped
+	current map[string]bool
+}
+
+// the rules loaded for a list that are not written yet, sorted runs of rules are spilled to chunk files so that
+// only a limited number of rules for each list are held in memory while the list is loaded
+type mmapPending struct {
+	rules  []string
+	chunks []string
+}
+
+// the file layout is a header, the offset of each block, and then the blocks, each rule in a block is the
+// length of the prefix it shares with the rule before it followed by the length and text of the rest of the rule
+type mmapRules struct {
+	data   []byte
+	count  int
+	blocks int
+}
+
+func (store *mmapStore) Init(sessionRoot string, config *config.GudgeonConfig, lists []*config.GudgeonList) {
+	store.root = sessionRoot
+	store.files = make(map[string]*mmapRules)
+	store.pending = make(map[string]*mmapPending)
+	store.current = make(map[string]bool)
+
+	if err := os.MkdirAll(store.root, os.ModePerm); err != nil {
+		log.Errorf("Could not create rule file directory: %s", err)
+	}
+
+	// remove the files of lists that are no longer configured and files left behind while they were being written
+	configured := make(map[string]bool)
+	for _, list := range lists {
+		name := path.Base(store.filePath(list))
+		configured[name] = true
+		configured[name+mmapHashSuffix] = true
+	}
+	files, _ := ioutil.ReadDir(store.root)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || configured[name] {
+			continue
+		}
+		if strings.HasSuffix(name, mmapSuffix) || strings.HasSuffix(name, mmapHashSuffix) || strings.HasPrefix(name, mmapTempPrefix) {
+			_ = os.Remove(path.Join(store.root, name))
+		}
+	}
+}
+
+// true if the rules files for the lists were written from content with the given hash, the files are opened and
+// the rules loaded for the lists are skipped
+func (store *mmapStore) isCurrent(lists []*config.GudgeonList, hash string) bool {
+	if "" == hash {
+		return false
+	}
+	for _, list := range lists {
+		saved, err := ioutil.ReadFile(store.filePath(list) + mmapHashSuffix)
+		if err != nil || string(saved) != hash {
+			return false
+		}
+	}
+
+	// a list without rules has no file
+	opened := make(map[string]*mmapRules)
+	for _, list := range lists {
+		rules, err := openMmapRules(store.filePath(list))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			for _, rules := range opened {
+				rules.close()
+			}
+			return false
+		}
+		opened[list.CanonicalName()] = rules
+	}
+	for _, list := range lists {
+		if existing, found := store.files[list.CanonicalName()]; found {
+			existing.close()
+			delete(store.files, list.CanonicalName())
+		}
+		if rules, found := opened[list.CanonicalName()]; found {
+			store.files[list.CanonicalName()] = rules
+		}
+		store.current[list.CanonicalName()] = true
+	}
+	return true
+}
+
+// save the hash of the content that the rules files for the lists were written from, must be called after the
+// lists are finalized
+func (store *mmapStore) setCurrent(lists []*config.GudgeonList, hash string) {
+	for _, list := range lists {
+		if err := ioutil.WriteFile(store.filePath(list)+mmapHashSuffix, []byte(hash), 0644); err != nil {
+			log.Errorf("Saving rule list hash: %s", err)
+		}
+	}
+}
+
+func (store *mmapStore) Clear(config *config.GudgeonConfig, list *config.GudgeonList) {
+	name := list.CanonicalName()
+	if rules, found := store.files[name]; found {
+		rules.close()
+		delete(store.files, name)
+	}
+	if pending, found := store.pending[name]; found {
+		pending.remove()
+		delete(store.pending, name)
+	}
+	_ = os.Remove(store.filePath(list))
+	_ = os.Remove(store.filePath(list) + mmapHashSuffix)
+	delete(store.current, name)
+	store.removeList(list)
+}
+
+func (store *mmapStore) Load(list *config.GudgeonList, rule string) {
+	store.addList(list)
+
+	// the rules for the list are already written
+	if store.current[list.CanonicalName()] {
+		return
+	}
+
+	pending, found := store.pending[list.CanonicalName()]
+	if !found {
+		pending = &mmapPending{}
+		store.pending[list.CanonicalName()] = pending
+	}
+	pending.rules = append(pending.rules, strings.ToLower(rule))
+	if len(pending.rules)%mmapChunkRules == 0 {
+		if err := pending.spill(store.root); err != nil {
+			log.Errorf("Could not write rules for list '%s' to chunk: %s", list.CanonicalName(), err)
+		}
+	}
+}
+
+func (store *mmapStore) Finalize(sessionRoot string, lists []*config.GudgeonList) {
+	for _, list := range lists {
+		name := list.CanonicalName()
+		pending, found := store.pending[name]
+		if !found {
+			continue
+		}
+		delete(store.pending, name)
+
+		// rules loaded on top of a list that was already written are merged with it
+		existing := store.files[name]
+		written, err := writeMmapRules(store.root, store.filePath(list), pending, existing)
+		pending.remove()
+		if existing != nil {
+			existing.close()
+			delete(store.files, name)
+		}
+		if err != nil {
+			log.Errorf("Could not write rules for list '%s': %s", list.CanonicalName(), err)
+			continue
+		}
+		store.files[name] = written
+	}
+}
+
+func (store *mmapStore) filePath(list *config.GudgeonList) string {
+	return path.Join(store.root, base64.RawURLEncoding.EncodeToString([]byte(list.CanonicalName()))+mmapSuffix)
+}
+
+// sort the rules held in memory and write them to a chunk file
+func (pending *mmapPending) spill(dir string) error {
+	sort.Strings(pending.rules)
+
+	file, err := ioutil.TempFile(dir, mmapTempPrefix)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for idx, rule := range pending.rules {
+		if idx > 0 && rule == pending.rules[idx-1] {
+			continue
+		}
+		if _, err = writer.WriteString(rule + "\n"); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	pending.chunks = append(pending.chunks, file.Name())
+	pending.rules = pending.rules[:0]
+	return nil
+}
+
+// pass each of the pending rules, and the rules that were already written, to the action in order
+func (pending *mmapPending) merge(existing *mmapRules, action func(rule string)) error {
+	sort.Strings(pending.rules)
+	sources := []mmapSource{&mmapSliceSource{rules: pending.rules}}
+	for _, chunk := range pending.chunks {
+		file, err := os.Open(chunk)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sources = append(sources, &mmapChunkSource{scanner: bufio.NewScanner(file)})
+	}
+	if existing != nil {
+		sources = append(sources, &mmapCursor{rules: existing})
+	}
+
+	merge := make(mmapMerge, 0, len(sources))
+	for _, source := range sources {
+		if rule, ok := source.next(); ok {
+			merge = append(merge, &mmapHead{rule: rule, source: source})
+		}
+	}
+	heap.Init(&merge)
+	for merge.Len() > 0 {
+		head := merge[0]
+		action(head.rule)
+		if rule, ok := head.source.next(); ok {
+			head.rule = rule
+			heap.Fix(&merge, 0)
+		} else {
+			heap.Pop(&merge)
+		}
+	}
+
+	for _, source := range sources {
+		if chunk, ok := source.(*mmapChunkSource); ok && chunk.scanner.Err() != nil {
+			return chunk.scanner.Err()
+		}
+	}
+	return nil
+}
+
+// remove the chunk files
+func (pending *mmapPending) remove() {
+	for _, chunk := range pending.chunks {
+		_ = os.Remove(chunk)
+	}
+	pending.chunks = nil
+	pending.rules = nil
+}
+
+// a sorted source of rules
+type mmapSource interface {
+	next() (string, bool)
+}
+
+type mmapSliceSource struct {
+	rules []string
+	idx   int
+}
+
+func (source *mmapSliceSource) next() (string, bool) {
+	if source.idx >= len(source.rules) {
+		return "", false
+	}
+	source.idx++
+	return source.rules[source.idx-1], true
+}
+
+type mmapChunkSource struct {
+	scanner *bufio.Scanner
+}
+
+func (source *mmapChunkSource) next() (string, bool) {
+	if !source.scanner.Scan() {
+		return "", false
+	}
+	return source.scanner.Text(), true
+}
+
+// the next rule of each source ordered by rule
+type mmapHead struct {
+	rule   string
+	source mmapSource
+}
+
+type mmapMerge []*mmapHead
+
+func (merge mmapMerge) Len() int           { return len(merge) }
+func (merge mmapMerge) Less(i, j int) bool { return merge[i].rule < merge[j].rule }
+func (merge mmapMerge) Swap(i, j int)      { merge[i], merge[j] = merge[j], merge[i] }
+
+func (merge *mmapMerge) Push(head interface{}) {
+	*merge = append(*merge, head.(*mmapHead))
+}
+
+func (merge *mmapMerge) Pop() interface{} {
+	old := *merge
+	head := old[len(old)-1]
+	*merge = old[:len(old)-1]
+	return head
+}
+
+// writes the blocks of rules that are given in order, the blocks are written to a temporary file because the offset
+// table that comes before them is only known once every rule has been written
+type mmapWriter struct {
+	data     *bufio.Writer
+	offsets  []byte
+	size     int
+	count    int
+	previous string
+	err      error
+}
+
+func (writer *mmapWriter) add(rule string) {
+	if writer.count > 0 && rule == writer.previous {
+		return
+	}
+	shared := 0
+	if writer.count%mmapBlockSize == 0 {
+		var offset [4]byte
+		binary.LittleEndian.PutUint32(offset[:], uint32(writer.size))
+		writer.offsets = append(writer.offsets, offset[:]...)
+	} else {
+		for shared < len(rule) && shared < len(writer.previous) && rule[shared] == writer.previous[shared] {
+			shared++
+		}
+	}
+	var varint [binary.MaxVarintLen64]byte
+	writer.write(varint[:binary.PutUvarint(varint[:], uint64(shared))])
+	writer.write(varint[:binary.PutUvarint(varint[:], uint64(len(rule)-shared))])
+	writer.write([]byte(rule[shared:]))
+	writer.previous = rule
+	writer.count++
+}
+
+func (writer *mmapWriter) write(part []byte) {
+	if writer.err != nil {
+		return
+	}
+	written, err := writer.data.Write(part)
+	writer.size += written
+	writer.err = err
+}
+
+// merge and write the rules to a new file and then map it, the file is renamed into place so that a mapping of
+// the file it replaces is never changed underneath a reader
+func writeMmapRules(dir string, filePath string, pending *mmapPending, existing *mmapRules) (*mmapRules, error) {
+	blocks, err := ioutil.TempFile(dir, mmapTempPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(blocks.Name())
+	defer blocks.Close()
+
+	writer := &mmapWriter{data: bufio.NewWriter(blocks)}
+	err = pending.merge(existing, writer.add)
+	if err == nil {
+		err = writer.err
+	}
+	if err == nil {
+		err = writer.data.Flush()
+	}
+	if err == nil {
+		_, err = blocks.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, mmapHeaderSize)
+	copy(header, mmapMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(writer.count))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(writer.offsets)/4))
+
+	file, err := ioutil.TempFile(dir, mmapTempPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range [][]byte{header, writer.offsets} {
+		if _, err = file.Write(part); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = io.Copy(file, blocks)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filePath)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+
+	return openMmapRules(filePath)
+}
+
+func openMmapRules(filePath string) (*mmapRules, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mapRules(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &mmapRules{
+		data:   data,
+		count:  int(binary.LittleEndian.Uint32(data[4:])),
+		blocks: int(binary.LittleEndian.Uint32(data[8:])),
+	}
+	if string(data[:4]) != mmapMagic || len(data) < mmapHeaderSize+4*rules.blocks {
+		rules.close()
+		return nil, syscall.EINVAL
+	}
+	return rules, nil
+}
+
+func (rules *mmapRules) close() {
+	if rules.data != nil {
+		unmapRules(rules.data)
+		rules.data = nil
+	}
+}
+
+// the start and end of a block in the file, block offsets are from the end of the offset table
+func (rules *mmapRules) block(idx int) (int, int) {
+	base := mmapHeaderSize + 4*rules.blocks
+	start := base + int(binary.LittleEndian.Uint32(rules.data[mmapHeaderSize+4*idx:]))
+	end := len(rules.data)
+	if idx+1 < rules.blocks {
+		end = base + int(binary.LittleEndian.Uint32(rules.data[mmapHeaderSize+4*(idx+1):]))
+	}
+	return start, end
+}
+
+// the first rule in a block is not prefix compressed and can be compared in place
+func (rules *mmapRules) first(idx int) []byte {
+	start, _ := rules.block(idx)
+	// skip the shared prefix length, which is always zero
+	start++
+	length, n := binary.Uvarint(rules.data[start:])
+	start += n
+	return rules.data[start : start+int(length)]
+}
+
+func (rules *mmapRules) contains(domain string) bool {
+	if rules.data == nil || rules.blocks < 1 {
+		return false
+	}
+	// the last block that starts with a rule that is not after the domain
+	low, high := 0, rules.blocks
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if string(rules.first(middle)) > domain {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	if low < 1 {
+		return false
+	}
+
+	// rebuild each rule in the block until the domain is found or passed
+	var buffer [256]byte
+	key := buffer[:0]
+	start, end := rules.block(low - 1)
+	for pos := start; pos < end; {
+		shared, n := binary.Uvarint(rules.data[pos:])
+		pos += n
+		length, n := binary.Uvarint(rules.data[pos:])
+		pos += n
+		key = append(key[:shared], rules.data[pos:pos+int(length)]...)
+		pos += int(length)
+		if string(key) >= domain {
+			return string(key) == domain
+		}
+	}
+	return false
+}
+
+// reads the rules of a file in order, blocks follow each other and the first rule of a block shares nothing with the
+// rule before it so the rules can be read straight through
+type mmapCursor struct {
+	rules *mmapRules
+	pos   int
+	key   []byte
+}
+
+func (cursor *mmapCursor) next() (string, bool) {
+	data := cursor.rules.data
+	if cursor.pos == 0 {
+		cursor.pos = mmapHeaderSize + 4*cursor.rules.blocks
+	}
+	if cursor.pos >= len(data) {
+		return "", false
+	}
+	shared, n := binary.Uvarint(data[cursor.pos:])
+	cursor.pos += n
+	length, n := binary.Uvarint(data[cursor.pos:])
+	cursor.pos += n
+	cursor.key = append(cursor.key[:shared], data[cursor.pos:cursor.pos+int(length)]...)
+	cursor.pos += int(length)
+	return string(cursor.key), true
+}
+
+func (store *mmapStore) foundInList(rules *mmapRules, domain string) (bool, string) {
+	if rules.contains(domain) {
+		return true, domain
+	}
+	return false, ""
+}
+
+func (store *mmapStore) FindMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string) {
+
+	domains := util.DomainList(strings.ToLower(domain))
+
+	match, list, rule := store.matchForEachOfTypeIn(config.ALLOW, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
+		rules, found := store.files[list.CanonicalName()]
+		if !found {
+			return MatchNone, nil, ""
+		}
+		for _, d := range domains {
+			if found, ruleString := store.foundInList(rules, d); found {
+				return MatchAllow, list, ruleString
+			}
+		}
+		return MatchNone, nil, ""
+	})
+
+	if MatchNone != match {
+		return match, list, rule
+	}
+
+	match, list, rule = store.matchForEachOfTypeIn(config.BLOCK, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
+		rules, found := store.files[list.CanonicalName()]
+		if !found {
+			return MatchNone, nil, ""
+		}
+		for _, d := range domains {
+			if found, ruleString := store.foundInList(rules, d); found {
+				return MatchBlock, list, ruleString
+			}
+		}
+		return MatchNone, nil, ""
+	})
+
+	return match, list, rule
+}
+
+func (store *mmapStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	return probeMatches(store, lists, domain)
+}
+
+func (store *mmapStore) Close() {
+	for _, rules := range store.files {
+		rules.close()
+	}
+	store.files = make(map[string]*mmapRules)
+	for _, pending := range store.pending {
+		pending.remove()
+	}
+	store.pending = make(map[string]*mmapPending)
+}
@@ -0,0 +1,8 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package rule
+
+// the mapping is used with the default read ahead
+func adviseRandom(data []byte) {
+}
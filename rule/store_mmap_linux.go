@@ -0,0 +1,10 @@
+package rule
+
+import (
+	"syscall"
+)
+
+// lookups jump around the file so reading ahead only wastes memory
+func adviseRandom(data []byte) {
+	_ = syscall.Madvise(data, syscall.MADV_RANDOM)
+}
@@ -0,0 +1,185 @@
+package rule
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+)
+
+func TestMmapRuleStore(t *testing.T) {
+	testStore(defaultRuleData, func() Store { return &mmapStore{} }, t)
+}
+
+func BenchmarkMmapRuleStore(b *testing.B) {
+	benchNonComplexStore(func() Store { return &mmapStore{} }, b)
+}
+
+func TestBloomMmapRuleStore(t *testing.T) {
+	testStore(defaultRuleData, func() Store {
+		return &bloomStore{
+			backingStore:     &mmapStore{},
+			defaultRuleCount: benchRules,
+		}
+	}, t)
+}
+
+func BenchmarkBloomMmapRuleStore(b *testing.B) {
+	benchNonComplexStore(func() Store {
+		return &bloomStore{
+			backingStore:     &mmapStore{},
+			defaultRuleCount: benchRules,
+		}
+	}, b)
+}
+
+func TestHash32MmapRuleStore(t *testing.T) {
+	testStore(defaultRuleData, func() Store {
+		return &hashStore32{
+			delegate: &mmapStore{},
+		}
+	}, t)
+}
+
+func TestMmapReload(t *testing.T) {
+	block := &config.GudgeonList{Name: "block", Type: "block"}
+	block.VerifyAndInit()
+	lists := []*config.GudgeonList{block}
+
+	// enough rules to fill several blocks of shared prefixes
+	store := &mmapStore{}
+	store.Init(testutil.TempDir(), nil, lists)
+	for idx := 0; idx < 100; idx++ {
+		store.Load(block, fmt.Sprintf("ads%03d.example.com", idx))
+	}
+	store.Load(block, "ADS050.example.com")
+	store.Finalize("", lists)
+
+	// rules loaded after the list was written are added to it
+	store.Load(block, "tracker.com")
+	store.Finalize("", lists)
+
+	expected := []struct {
+		domain string
+		match  Match
+	}{
+		{"ads000.example.com", MatchBlock},
+		{"sub.ads050.example.com", MatchBlock},
+		{"ads099.example.com", MatchBlock},
+		{"ads100.example.com", MatchNone},
+		{"ads.example.com", MatchNone},
+		{"tracker.com", MatchBlock},
+		{"aaa.com", MatchNone},
+		{"zzz.com", MatchNone},
+	}
+	for _, e := range expected {
+		if match, _, _ := store.FindMatch(lists, e.domain); match != e.match {
+			t.Errorf("Expected match %d for '%s' but got %d", e.match, e.domain, match)
+		}
+	}
+	if store.files[block.CanonicalName()].count != 101 {
+		t.Errorf("Expected 101 unique rules but got %d", store.files[block.CanonicalName()].count)
+	}
+
+	// a cleared list has no rules until it is loaded again
+	store.Clear(nil, block)
+	store.Load(block, "new.com")
+	store.Finalize("", lists)
+	if match, _, _ := store.FindMatch(lists, "tracker.com"); MatchNone != match {
+		t.Errorf("Expected cleared rules to be removed")
+	}
+	if match, _, rule := store.FindMatch(lists, "a.new.com"); MatchBlock != match || "new.com" != rule {
+		t.Errorf("Expected 'new.com' to block but got %d by '%s'", match, rule)
+	}
+	store.Close()
+}
+
+func TestMmapChunkedRules(t *testing.T) {
+	defer func(chunkRules int) { mmapChunkRules = chunkRules }(mmapChunkRules)
+	mmapChunkRules = 7
+
+	block := &config.GudgeonList{Name: "block", Type: "block"}
+	block.VerifyAndInit()
+	lists := []*config.GudgeonList{block}
+
+	// rules out of order and repeated across chunks
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+	store := &mmapStore{}
+	store.Init(tmpDir, nil, lists)
+	for idx := 99; idx >= 0; idx-- {
+		store.Load(block, fmt.Sprintf("ads%03d.example.com", idx))
+		store.Load(block, fmt.Sprintf("ads%03d.example.com", (idx*7)%100))
+	}
+	if chunks := len(store.pending[block.CanonicalName()].chunks); chunks < 2 {
+		t.Errorf("Expected rules to be spilled to chunks but got %d chunks", chunks)
+	}
+	store.Finalize("", lists)
+	defer store.Close()
+
+	if store.files[block.CanonicalName()].count != 100 {
+		t.Errorf("Expected 100 unique rules but got %d", store.files[block.CanonicalName()].count)
+	}
+	for idx := 0; idx < 100; idx++ {
+		if match, _, _ := store.FindMatch(lists, fmt.Sprintf("ads%03d.example.com", idx)); MatchBlock != match {
+			t.Errorf("Expected rule %d to block", idx)
+		}
+	}
+
+	// only the rules file is left
+	files, _ := ioutil.ReadDir(tmpDir)
+	if len(files) != 1 || files[0].Name() != path.Base(store.filePath(block)) {
+		t.Errorf("Expected only the rules file to be left but found %d files", len(files))
+	}
+}
+
+func TestMmapRulesKeptBetweenRuns(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	blockPath := path.Join(tmpDir, "block.txt")
+	_ = ioutil.WriteFile(blockPath, []byte("ads.com\n"), 0644)
+	blockList := &config.GudgeonList{Name: "block", Type: "block", Source: blockPath}
+	blockList.VerifyAndInit()
+	conf := &config.GudgeonConfig{
+		Home:    tmpDir,
+		Storage: &config.GudgeonStorage{RuleStorage: "hash32+mmap"},
+		Lists:   []*config.GudgeonList{blockList},
+	}
+	mmapRoot := path.Join(conf.RulesRoot(), "hash32+mmap")
+	rulesPath := (&mmapStore{root: mmapRoot}).filePath(blockList)
+
+	expect := func(run int, domain string, match Match) {
+		store, _ := CreateStore(tmpDir, conf)
+		defer store.Close()
+		if result, _, _ := store.FindMatch(conf.Lists, domain); match != result {
+			t.Errorf("Run %d expected match %d for '%s' but got %d", run, match, domain, result)
+		}
+	}
+
+	expect(1, "ads.com", MatchBlock)
+	written, err := os.Stat(rulesPath)
+	if err != nil {
+		t.Fatalf("Expected rules file: %s", err)
+	}
+
+	// an unchanged list keeps its file and files that don't belong to a list are removed
+	orphan := path.Join(mmapRoot, "orphan"+mmapSuffix)
+	_ = ioutil.WriteFile(orphan, []byte{}, 0644)
+	expect(2, "ads.com", MatchBlock)
+	if kept, err := os.Stat(rulesPath); err != nil || !os.SameFile(written, kept) {
+		t.Errorf("Expected rules file to be kept for unchanged list")
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected orphaned rules file to be removed")
+	}
+
+	// a changed list is written again
+	_ = ioutil.WriteFile(blockPath, []byte("newads.com\n"), 0644)
+	expect(3, "ads.com", MatchNone)
+	expect(3, "newads.com", MatchBlock)
+}
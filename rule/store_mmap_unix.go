@@ -0,0 +1,23 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package rule
+
+import (
+	"os"
+	"syscall"
+)
+
+// map the file into memory, the pages are read from the file as they are used
+func mapRules(file *os.File, size int) ([]byte, error) {
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	adviseRandom(data)
+	return data, nil
+}
+
+func unmapRules(data []byte) {
+	_ = syscall.Munmap(data)
+}
@@ -45,6 +45,11 @@ type sqlStore struct {
 	// mutex and variable that stops allowing queries when the db is "closing"
 	closing      bool
 	closingMutex sync.RWMutex
+
+	// the database is kept between runs, these are the content hashes that the rules for each list were
+	// loaded from and the lists that already have their rules so loading them again is skipped
+	hashes  map[string]string
+	current map[string]bool
 }
 
 func (store *sqlStore) Init(sessionRoot string, config *config.GudgeonConfig, lists []*config.GudgeonList) {
@@ -72,16 +77,25 @@ func (store *sqlStore) Init(sessionRoot string, config *config.GudgeonConfig, li
 	// anyway
 	store.db.SetMaxOpenConns(1)
 
-	// insert lists into table
+	// make sure each list is in the table and remove the lists (and rules) that are no longer configured
+	store.hashes = make(map[string]string)
+	store.current = make(map[string]bool)
+	configured := make(map[string]bool)
 	for _, list := range lists {
 		if list == nil {
 			continue
 		}
-		_, err = store.db.Exec("INSERT INTO lists (ShortName, ListType) VALUES (?, ?)", list.ShortName(), list.ParsedType())
+		configured[list.ShortName()] = true
+		_, err = store.db.Exec("INSERT INTO lists (ShortName, ListType) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM lists WHERE ShortName = ?)", list.ShortName(), list.ParsedType(), list.ShortName())
 		if err != nil {
 			log.Errorf("Inserting list: %s", err)
 		}
+		_, err = store.db.Exec("UPDATE lists SET ListType = ? WHERE ShortName = ?", list.ParsedType(), list.ShortName())
+		if err != nil {
+			log.Errorf("Updating list: %s", err)
+		}
 	}
+	store.loadHashes(configured)
 
 	// set up cache
 	store.stmtCache = cache.New(time.Minute*5, time.Minute)
@@ -99,7 +113,71 @@ func (store *sqlStore) Init(sessionRoot string, config *config.GudgeonConfig, li
 	store.closing = false
 }
 
+// read the saved hashes for the configured lists and remove the lists that are not configured
+func (store *sqlStore) loadHashes(configured map[string]bool) {
+	rows, err := store.db.Query("SELECT ShortName, Hash FROM lists")
+	if err != nil {
+		log.Errorf("Reading rule list hashes: %s", err)
+		return
+	}
+	stale := make([]string, 0)
+	for rows.Next() {
+		var shortName, hash string
+		if err := rows.Scan(&shortName, &hash); err != nil {
+			continue
+		}
+		if configured[shortName] {
+			store.hashes[shortName] = hash
+		} else {
+			stale = append(stale, shortName)
+		}
+	}
+	_ = rows.Close()
+
+	for _, shortName := range stale {
+		if _, err := store.db.Exec(_deleteStmt, shortName); err != nil {
+			log.Errorf("Removing rules for list %s: %s", shortName, err)
+		}
+		if _, err := store.db.Exec("DELETE FROM lists WHERE ShortName = ?", shortName); err != nil {
+			log.Errorf("Removing list %s: %s", shortName, err)
+		}
+	}
+}
+
+// true if the rules for the lists were loaded from content with the same hash, loading rules for those lists is skipped
+func (store *sqlStore) isCurrent(lists []*config.GudgeonList, hash string) bool {
+	if "" == hash {
+		return false
+	}
+	for _, list := range lists {
+		if store.hashes[list.ShortName()] != hash {
+			return false
+		}
+	}
+	for _, list := range lists {
+		store.current[list.ShortName()] = true
+	}
+	return true
+}
+
+// save the hash of the content that the rules for the lists were loaded from, must be called after the lists are finalized
+func (store *sqlStore) setCurrent(lists []*config.GudgeonList, hash string) {
+	for _, list := range lists {
+		if _, err := store.db.Exec("UPDATE lists SET Hash = ? WHERE ShortName = ?", hash, list.ShortName()); err != nil {
+			log.Errorf("Saving rule list hash: %s", err)
+			continue
+		}
+		store.hashes[list.ShortName()] = hash
+	}
+}
+
 func (store *sqlStore) Clear(config *config.GudgeonConfig, list *config.GudgeonList) {
+	// the insert statement belongs to the transaction
+	if store.stmt != nil {
+		_ = store.stmt.Close()
+		store.stmt = nil
+	}
+
 	// close transaction if it exists
 	if store.tx != nil {
 		err := store.tx.Commit()
@@ -137,6 +215,13 @@ func (store *sqlStore) Clear(config *config.GudgeonConfig, list *config.GudgeonL
 	// start with a fresh transaction on the next operation
 	store.tx = nil
 
+	// the rules need to be loaded again
+	store.hashes[list.ShortName()] = ""
+	delete(store.current, list.ShortName())
+	if _, err = store.db.Exec("UPDATE lists SET Hash = '' WHERE ShortName = ?", list.ShortName()); err != nil {
+		log.Errorf("Clearing rule list hash: %s", err)
+	}
+
 	// clear base store
 	store.removeList(list)
 }
@@ -147,6 +232,11 @@ func (store *sqlStore) Load(list *config.GudgeonList, rule string) {
 	// add list to base store
 	store.addList(list)
 
+	// the rules for the list are already in the database
+	if store.current[list.ShortName()] {
+		return
+	}
+
 	if store.tx == nil {
 		store.tx, err = store.db.Begin()
 		if err != nil {
@@ -179,6 +269,7 @@ func (store *sqlStore) Finalize(sessionRoot string, lists []*config.GudgeonList)
 
 	if store.stmt != nil {
 		_ = store.stmt.Close()
+		store.stmt = nil
 	}
 
 	// commit any outstanding transactions
@@ -232,6 +323,9 @@ func (store *sqlStore) foundInLists(lists []*config.GudgeonList, domains []strin
 
 	numDomains := len(domains)
 
+	// include exceptions from block lists
+	lists = store.withExceptions(lists)
+
 	vars := make([]interface{}, len(lists)+numDomains)
 
 	numLists := store.forEachIn(lists, func(index int, listType config.ListType, list *config.GudgeonList) {
@@ -328,6 +422,10 @@ func (store *sqlStore) FindMatch(lists []*config.GudgeonList, domain string) (Ma
 	return MatchNone, nil, ""
 }
 
+func (store *sqlStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	return probeMatches(store, lists, domain)
+}
+
 func (store *sqlStore) Close() {
 	store.closingMutex.Lock()
 	store.closing = true
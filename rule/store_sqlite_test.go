@@ -1,9 +1,16 @@
 package rule
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/fortytw2/leaktest"
+
+	"github.com/chrisruffalo/gudgeon/config"
+	"github.com/chrisruffalo/gudgeon/testutil"
+	"github.com/chrisruffalo/gudgeon/util"
 )
 
 func TestSqliteRuleStore(t *testing.T) {
@@ -14,3 +21,80 @@ func TestSqliteRuleStore(t *testing.T) {
 func BenchmarkSqliteRuleStore(b *testing.B) {
 	benchNonComplexStore(func() Store { return &sqlStore{} }, b)
 }
+
+func TestSqliteRulesKeptBetweenRuns(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	blockPath := path.Join(tmpDir, "block.txt")
+	otherPath := path.Join(tmpDir, "other.txt")
+	_ = ioutil.WriteFile(blockPath, []byte("ads.com\n"), 0644)
+	_ = ioutil.WriteFile(otherPath, []byte("tracker.com\n"), 0644)
+
+	blockList := &config.GudgeonList{Name: "block", Type: "block", Source: blockPath}
+	blockList.VerifyAndInit()
+	otherList := &config.GudgeonList{Name: "other", Type: "block", Source: otherPath}
+	otherList.VerifyAndInit()
+	conf := &config.GudgeonConfig{
+		Home:    tmpDir,
+		Storage: &config.GudgeonStorage{RuleStorage: "sqlite"},
+		Lists:   []*config.GudgeonList{blockList, otherList},
+	}
+
+	expect := func(run int, domain string, match Match) {
+		store, _ := CreateStore(tmpDir, conf)
+		defer store.Close()
+		if result, _, _ := store.FindMatch(conf.Lists, domain); match != result {
+			t.Errorf("Run %d expected match %d for '%s' but got %d", run, match, domain, result)
+		}
+	}
+
+	expect(1, "ads.com", MatchBlock)
+
+	// unchanged lists keep their rules
+	expect(2, "sub.tracker.com", MatchBlock)
+	expect(2, "ads.com", MatchBlock)
+
+	// a changed list loses its old rules
+	_ = ioutil.WriteFile(blockPath, []byte("newads.com\n"), 0644)
+	expect(3, "ads.com", MatchNone)
+	expect(3, "newads.com", MatchBlock)
+	expect(3, "tracker.com", MatchBlock)
+}
+
+func TestSqliteRulesInUseByAnotherProcess(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	blockPath := path.Join(tmpDir, "block.txt")
+	_ = ioutil.WriteFile(blockPath, []byte("ads.com\n"), 0644)
+	blockList := &config.GudgeonList{Name: "block", Type: "block", Source: blockPath}
+	blockList.VerifyAndInit()
+	conf := &config.GudgeonConfig{
+		Home:    tmpDir,
+		Storage: &config.GudgeonStorage{RuleStorage: "sqlite"},
+		Lists:   []*config.GudgeonList{blockList},
+	}
+
+	// another owner of the shared rules
+	rulesRoot := path.Join(conf.RulesRoot(), "sqlite")
+	_ = os.MkdirAll(conf.RulesRoot(), os.ModePerm)
+	lock, err := util.TryLock(rulesRoot + util.LockSuffix)
+	if err != nil {
+		t.Fatalf("Could not lock rules: %s", err)
+	}
+	defer lock.Release()
+
+	sessionRoot := path.Join(tmpDir, "session")
+	store, _ := CreateStore(sessionRoot, conf)
+	defer store.Close()
+	if result, _, _ := store.FindMatch(conf.Lists, "ads.com"); MatchBlock != result {
+		t.Errorf("Expected rules to be loaded into the session")
+	}
+	if _, err := os.Stat(path.Join(rulesRoot, sqlDbName)); !os.IsNotExist(err) {
+		t.Errorf("Expected shared rules to be left alone while they are in use")
+	}
+	if _, err := os.Stat(path.Join(sessionRoot, "rules", "sqlite", sqlDbName)); err != nil {
+		t.Errorf("Expected rules in the session: %s", err)
+	}
+}
@@ -2,7 +2,9 @@ package rule
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"runtime"
 	"testing"
 
@@ -168,3 +170,112 @@ func printMemUsage(msg string, b *testing.B) {
 func bToMb(b uint64) uint64 {
 	return b / 1024 / 1024
 }
+
+// complex block rules are checked before the rules in the backing store, only adblock exceptions are checked before them
+func TestComplexRulePrecedence(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"allow.list":   "ok.example.com\n",
+		"block.list":   "*.example.com\n",
+		"adblock.list": "||*.ads.com^\n@@||ok.ads.com^\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Errorf("Could not write list: %s", err)
+			return
+		}
+	}
+
+	allow := &config.GudgeonList{Name: "allow", Type: "allow", Source: path.Join(tmpDir, "allow.list")}
+	block := &config.GudgeonList{Name: "block", Type: "block", Source: path.Join(tmpDir, "block.list")}
+	adblock := &config.GudgeonList{Name: "adblock", Type: "block", Format: config.FormatAdblock, Source: path.Join(tmpDir, "adblock.list")}
+	lists := []*config.GudgeonList{allow, block, adblock}
+	for _, list := range lists {
+		list.VerifyAndInit()
+	}
+	conf := &config.GudgeonConfig{
+		Home:    tmpDir,
+		Storage: &config.GudgeonStorage{RuleStorage: "memory"},
+		Lists:   lists,
+	}
+	store, _ := CreateStore(tmpDir, conf)
+	defer store.Close()
+
+	expected := []struct {
+		domain string
+		match  Match
+		list   *config.GudgeonList
+	}{
+		// the allow rule is in the backing store and the block rule is complex
+		{"ok.example.com", MatchBlock, block},
+		{"other.example.com", MatchBlock, block},
+		// the exception is in the backing store and wins over the complex rule of its list
+		{"ok.ads.com", MatchAllow, adblock},
+		{"other.ads.com", MatchBlock, adblock},
+	}
+	for _, e := range expected {
+		match, list, rule := store.FindMatch(lists, e.domain)
+		if e.match != match || e.list != list {
+			t.Errorf("Expected match %d from %s for '%s' but got %d from %v (%s)", e.match, e.list.ShortName(), e.domain, match, list, rule)
+		}
+	}
+}
+
+// a list that is replaced is never seen without its rules by the matches that are made at the same time
+func TestReplaceRules(t *testing.T) {
+	tmpDir := testutil.TempDir()
+	defer os.RemoveAll(tmpDir)
+
+	if err := ioutil.WriteFile(path.Join(tmpDir, "block.list"), []byte("kept.example.com\n"), 0644); err != nil {
+		t.Errorf("Could not write list: %s", err)
+		return
+	}
+	list := &config.GudgeonList{Name: "block", Type: "block", Source: path.Join(tmpDir, "block.list")}
+	list.VerifyAndInit()
+	lists := []*config.GudgeonList{list}
+	conf := &config.GudgeonConfig{
+		Home:    tmpDir,
+		Storage: &config.GudgeonStorage{RuleStorage: "memory"},
+		Lists:   lists,
+	}
+	store, _ := CreateStore(tmpDir, conf)
+	defer store.Close()
+
+	stop := make(chan bool)
+	done := make(chan int)
+	go func() {
+		missed := 0
+		for {
+			select {
+			case <-stop:
+				done <- missed
+				return
+			default:
+			}
+			if match, _, _ := store.FindMatch(lists, "kept.example.com"); MatchBlock != match {
+				missed++
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		// the kept rule is loaded last so that it is missing for as long as possible
+		rules := make([]string, 0, 101)
+		for j := 0; j < 100; j++ {
+			rules = append(rules, fmt.Sprintf("added%d-%d.example.com", i, j))
+		}
+		ReplaceRules(store, tmpDir, conf, list, append(rules, "kept.example.com"))
+	}
+	close(stop)
+	if missed := <-done; missed > 0 {
+		t.Errorf("Expected the replaced list to always match but %d matches were missed", missed)
+	}
+
+	if match, _, _ := store.FindMatch(lists, "added99-0.example.com"); MatchBlock != match {
+		t.Errorf("Expected the replacement rules to be loaded")
+	}
+	if match, _, _ := store.FindMatch(lists, "added0-0.example.com"); MatchNone != match {
+		t.Errorf("Expected the rules that were replaced to be removed")
+	}
+}
@@ -0,0 +1,395 @@
+package rule
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+const (
+	// separates labels in the sort key of a pending rule, sorts before any character allowed in a label
+	trieKeySeparator = "\x00"
+
+	// the most labels that a domain can have
+	trieMaxDepth = 128
+
+	// the longest name that a domain can have
+	maxDomainLength = 253
+)
+
+// nodes are kept in a single slice with the children of a node next to each other and sorted by label
+// so that they can be searched, labels are kept in a single string
+type trieNode struct {
+	label      uint32
+	firstChild uint32
+	children   uint32
+	bits       uint32
+	labelLen   uint8
+}
+
+// a rule waiting to be added to the trie when the store is finalized
+type trieRule struct {
+	key      string
+	list     uint32
+	wildcard bool
+}
+
+// the trie store keeps rules in a trie of domain labels starting from the top level domain, each node has a bitmap
+// of the lists that have a rule for that name and of the lists that have a wildcard rule for the names below it
+type trieStore struct {
+	baseStore
+
+	nodes  []trieNode
+	labels string
+
+	// each bitmap is the words for exact rules followed by the words for wildcard rules, nodes with the same
+	// lists share a bitmap and the first bitmap is always empty
+	bitmaps [][]uint64
+	words   int
+
+	listIndex map[string]uint32
+	pending   []trieRule
+}
+
+func (store *trieStore) Init(sessionRoot string, config *config.GudgeonConfig, lists []*config.GudgeonList) {
+	store.listIndex = make(map[string]uint32)
+	store.pending = make([]trieRule, 0)
+	for _, list := range lists {
+		store.indexOf(list)
+	}
+	store.build(nil)
+}
+
+// the bit used for the list
+func (store *trieStore) indexOf(list *config.GudgeonList) uint32 {
+	if idx, found := store.listIndex[list.CanonicalName()]; found {
+		return idx
+	}
+	idx := uint32(len(store.listIndex))
+	store.listIndex[list.CanonicalName()] = idx
+	return idx
+}
+
+// the labels of the domain from the top level domain down joined so that they sort the same way as the trie
+func trieKey(domain string) string {
+	labels := strings.Split(domain, ".")
+	for left, right := 0, len(labels)-1; left < right; left, right = left+1, right-1 {
+		labels[left], labels[right] = labels[right], labels[left]
+	}
+	return strings.Join(labels, trieKeySeparator)
+}
+
+func (store *trieStore) matchesWildcards() bool {
+	return true
+}
+
+func (store *trieStore) Load(list *config.GudgeonList, rule string) {
+	rule = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rule)), ".")
+	wildcard := isSubdomainWildcard(rule)
+	if wildcard {
+		rule = rule[len(ruleSubdomainGlob):]
+	}
+	// names longer than a domain name can be are not rules
+	if "" == rule || len(rule) > maxDomainLength {
+		return
+	}
+	store.pending = append(store.pending, trieRule{key: trieKey(rule), list: store.indexOf(list), wildcard: wildcard})
+	store.addList(list)
+}
+
+func (store *trieStore) Clear(config *config.GudgeonConfig, list *config.GudgeonList) {
+	// the bitmaps are shared so clearing the bit in each bitmap removes the list from every node
+	if idx, found := store.listIndex[list.CanonicalName()]; found && int(idx/64) < store.words {
+		mask := ^(uint64(1) << (idx % 64))
+		for _, bitmap := range store.bitmaps {
+			bitmap[idx/64] &= mask
+			bitmap[store.words+int(idx/64)] &= mask
+		}
+	}
+
+	// rules that were loaded but not finalized are also removed
+	if idx, found := store.listIndex[list.CanonicalName()]; found {
+		kept := store.pending[:0]
+		for _, pending := range store.pending {
+			if pending.list != idx {
+				kept = append(kept, pending)
+			}
+		}
+		store.pending = kept
+	}
+
+	store.removeList(list)
+}
+
+func (store *trieStore) Finalize(sessionRoot string, lists []*config.GudgeonList) {
+	if len(store.pending) < 1 {
+		return
+	}
+
+	// the rules already in the trie are rebuilt along with the new rules
+	rules := store.pending
+	store.pending = make([]trieRule, 0)
+	store.build(append(store.existing(), rules...))
+}
+
+// the rules that are in the trie for lists that have not been cleared
+func (store *trieStore) existing() []trieRule {
+	rules := make([]trieRule, 0)
+	if len(store.nodes) < 1 {
+		return rules
+	}
+
+	labels := make([]string, 0, trieMaxDepth)
+	var walk func(nodeIdx uint32)
+	walk = func(nodeIdx uint32) {
+		node := store.nodes[nodeIdx]
+		labels = append(labels, store.labels[node.label:node.label+uint32(node.labelLen)])
+		bitmap := store.bitmaps[node.bits]
+		for word := 0; word < store.words*2; word++ {
+			for bit := 0; bit < 64; bit++ {
+				if bitmap[word]&(uint64(1)<<uint(bit)) == 0 {
+					continue
+				}
+				rules = append(rules, trieRule{
+					key:      strings.Join(labels[1:], trieKeySeparator),
+					list:     uint32((word%store.words)*64 + bit),
+					wildcard: word >= store.words,
+				})
+			}
+		}
+		for child := node.firstChild; child < node.firstChild+node.children; child++ {
+			walk(child)
+		}
+		labels = labels[:len(labels)-1]
+	}
+	walk(0)
+
+	return rules
+}
+
+// replace the trie with one built from the given rules
+func (store *trieStore) build(rules []trieRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].key < rules[j].key
+	})
+
+	store.words = (len(store.listIndex) + 63) / 64
+	if store.words < 1 {
+		store.words = 1
+	}
+	store.bitmaps = [][]uint64{make([]uint64, store.words*2)}
+	bitmapIndex := make(map[string]uint32)
+
+	builder := strings.Builder{}
+	store.nodes = make([]trieNode, 1, len(rules)+1)
+
+	// the bitmap for the rules in the range that end at the node, shared with any node that has the same lists
+	bitmapFor := func(rules []trieRule) uint32 {
+		bitmap := make([]uint64, store.words*2)
+		for _, rule := range rules {
+			word := int(rule.list / 64)
+			if rule.wildcard {
+				word += store.words
+			}
+			bitmap[word] |= uint64(1) << (rule.list % 64)
+		}
+		empty := true
+		key := make([]byte, 0, len(bitmap)*8)
+		for _, word := range bitmap {
+			empty = empty && 0 == word
+			for shift := uint(0); shift < 64; shift += 8 {
+				key = append(key, byte(word>>shift))
+			}
+		}
+		if empty {
+			return 0
+		}
+		if idx, found := bitmapIndex[string(key)]; found {
+			return idx
+		}
+		idx := uint32(len(store.bitmaps))
+		store.bitmaps = append(store.bitmaps, bitmap)
+		bitmapIndex[string(key)] = idx
+		return idx
+	}
+
+	// all of the rules in the range share the first offset bytes of their key
+	var add func(nodeIdx int, rules []trieRule, offset int)
+	add = func(nodeIdx int, rules []trieRule, offset int) {
+		// group the rules by the label after the offset
+		type group struct {
+			label string
+			rules []trieRule
+		}
+		groups := make([]group, 0)
+		for start := 0; start < len(rules); {
+			label := rules[start].key[offset:]
+			if sep := strings.Index(label, trieKeySeparator); sep > -1 {
+				label = label[:sep]
+			}
+			end := start + 1
+			for end < len(rules) && strings.HasPrefix(rules[end].key[offset:], label) && (len(rules[end].key) == offset+len(label) || rules[end].key[offset+len(label):offset+len(label)+1] == trieKeySeparator) {
+				end++
+			}
+			groups = append(groups, group{label: label, rules: rules[start:end]})
+			start = end
+		}
+
+		// the children of a node are next to each other
+		first := len(store.nodes)
+		store.nodes[nodeIdx].firstChild = uint32(first)
+		store.nodes[nodeIdx].children = uint32(len(groups))
+		for _, g := range groups {
+			// rules that end at this label are sorted before the rules for names below it
+			ending := 0
+			for ending < len(g.rules) && len(g.rules[ending].key) == offset+len(g.label) {
+				ending++
+			}
+			store.nodes = append(store.nodes, trieNode{
+				label:    uint32(builder.Len()),
+				labelLen: uint8(len(g.label)),
+				bits:     bitmapFor(g.rules[:ending]),
+			})
+			builder.WriteString(g.label)
+		}
+		for idx, g := range groups {
+			ending := 0
+			for ending < len(g.rules) && len(g.rules[ending].key) == offset+len(g.label) {
+				ending++
+			}
+			if ending < len(g.rules) {
+				add(first+idx, g.rules[ending:], offset+len(g.label)+len(trieKeySeparator))
+			}
+		}
+	}
+	if len(rules) > 0 {
+		add(0, rules, 0)
+	}
+
+	store.labels = builder.String()
+}
+
+// find the child of the node with the given label
+func (store *trieStore) child(nodeIdx uint32, label string) (uint32, bool) {
+	node := store.nodes[nodeIdx]
+	children := store.nodes[node.firstChild : node.firstChild+node.children]
+	idx := sort.Search(len(children), func(i int) bool {
+		return store.labels[children[i].label:children[i].label+uint32(children[i].labelLen)] >= label
+	})
+	if idx < len(children) && store.labels[children[idx].label:children[idx].label+uint32(children[idx].labelLen)] == label {
+		return node.firstChild + uint32(idx), true
+	}
+	return 0, false
+}
+
+// a node on the path for a domain and where the name of that node starts in the domain
+type trieStep struct {
+	node  uint32
+	start int
+}
+
+// walk the labels of the domain from the top level domain down as far as the trie goes
+func (store *trieStore) walk(domain string, path []trieStep) []trieStep {
+	if len(store.nodes) < 1 {
+		return path
+	}
+	node := uint32(0)
+	end := len(domain)
+	for end > 0 && len(path) < cap(path) {
+		start := strings.LastIndex(domain[:end], ".") + 1
+		next, found := store.child(node, domain[start:end])
+		if !found {
+			break
+		}
+		path = append(path, trieStep{node: next, start: start})
+		node = next
+		end = start - 1
+	}
+	return path
+}
+
+// the rule for the list at the most specific node on the path, an exact rule wins over a wildcard rule at the same node
+func (store *trieStore) ruleOnPath(domain string, path []trieStep, idx uint32) (string, bool) {
+	if int(idx/64) >= store.words {
+		return "", false
+	}
+	mask := uint64(1) << (idx % 64)
+	for step := len(path) - 1; step >= 0; step-- {
+		bitmap := store.bitmaps[store.nodes[path[step].node].bits]
+		if bitmap[idx/64]&mask != 0 {
+			return domain[path[step].start:], true
+		}
+		// wildcards only match names below the node
+		if path[step].start > 0 && bitmap[store.words+int(idx/64)]&mask != 0 {
+			return ruleSubdomainGlob + domain[path[step].start:], true
+		}
+	}
+	return "", false
+}
+
+func (store *trieStore) FindMatch(lists []*config.GudgeonList, domain string) (Match, *config.GudgeonList, string) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	var steps [trieMaxDepth]trieStep
+	path := store.walk(domain, steps[:0])
+	if len(path) < 1 {
+		return MatchNone, nil, ""
+	}
+
+	match, list, rule := store.matchForEachOfTypeIn(config.ALLOW, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
+		if rule, found := store.ruleOnPath(domain, path, store.listIndex[list.CanonicalName()]); found {
+			return MatchAllow, list, rule
+		}
+		return MatchNone, nil, ""
+	})
+
+	if MatchNone != match {
+		return match, list, rule
+	}
+
+	return store.matchForEachOfTypeIn(config.BLOCK, lists, func(listType config.ListType, list *config.GudgeonList) (Match, *config.GudgeonList, string) {
+		if rule, found := store.ruleOnPath(domain, path, store.listIndex[list.CanonicalName()]); found {
+			return MatchBlock, list, rule
+		}
+		return MatchNone, nil, ""
+	})
+}
+
+// every exact, parent, and wildcard rule on the path is found in a single walk
+func (store *trieStore) Explain(lists []*config.GudgeonList, domain string) []*RuleMatch {
+	matches := make([]*RuleMatch, 0)
+
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	var steps [trieMaxDepth]trieStep
+	path := store.walk(domain, steps[:0])
+
+	for _, list := range lists {
+		idx, found := store.listIndex[list.CanonicalName()]
+		if !found || store.getList(list.ShortName()) == nil || int(idx/64) >= store.words {
+			continue
+		}
+		mask := uint64(1) << (idx % 64)
+		for step := len(path) - 1; step >= 0; step-- {
+			bitmap := store.bitmaps[store.nodes[path[step].node].bits]
+			if bitmap[idx/64]&mask != 0 {
+				kind := MatchKindParent
+				if 0 == path[step].start {
+					kind = MatchKindExact
+				}
+				matches = append(matches, newRuleMatch(list, domain[path[step].start:], kind))
+			}
+			if path[step].start > 0 && bitmap[store.words+int(idx/64)]&mask != 0 {
+				matches = append(matches, newRuleMatch(list, ruleSubdomainGlob+domain[path[step].start:], MatchKindGlob))
+			}
+		}
+	}
+
+	return matches
+}
+
+func (store *trieStore) Close() {
+	store.nodes = nil
+	store.labels = ""
+	store.bitmaps = nil
+	store.pending = nil
+}
@@ -0,0 +1,58 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/chrisruffalo/gudgeon/config"
+)
+
+func TestTrieRuleStore(t *testing.T) {
+	testStore(defaultRuleData, func() Store { return &trieStore{} }, t)
+}
+
+func TestTrieWildcardRules(t *testing.T) {
+	ruleData := []ruleList{
+		{group: "default", rules: []string{"*.glob.com", "exact.wild.com", "*.wild.com"}, ruleType: config.BLOCK, blocked: []string{"a.glob.com", "a.b.glob.com", "exact.wild.com", "sub.exact.wild.com", "a.wild.com"}, nomatch: []string{"glob.com", "wild.com", "aglob.com", "com"}},
+		{group: "default", rules: []string{"*.glob.com"}, ruleType: config.ALLOW, allowed: []string{"a.glob.com"}, nomatch: []string{"glob.com"}},
+	}
+	testStore(ruleData, func() Store { return &complexStore{backingStore: &trieStore{}} }, t)
+}
+
+func BenchmarkTrieRuleStore(b *testing.B) {
+	benchNonComplexStore(func() Store { return &trieStore{} }, b)
+}
+
+func TestTrieReload(t *testing.T) {
+	block := &config.GudgeonList{Name: "block", Type: "block"}
+	block.VerifyAndInit()
+	other := &config.GudgeonList{Name: "other", Type: "block"}
+	other.VerifyAndInit()
+	lists := []*config.GudgeonList{block, other}
+
+	store := &trieStore{}
+	store.Init("", nil, lists)
+	store.Load(block, "ads.com")
+	store.Load(other, "tracker.com")
+	store.Finalize("", lists)
+
+	// replacing the rules of one list leaves the other list alone
+	store.Clear(nil, block)
+	store.Load(block, "*.new.com")
+	store.Finalize("", []*config.GudgeonList{block})
+
+	expected := []struct {
+		domain string
+		match  Match
+		rule   string
+	}{
+		{"ads.com", MatchNone, ""},
+		{"sub.tracker.com", MatchBlock, "tracker.com"},
+		{"a.new.com", MatchBlock, "*.new.com"},
+		{"new.com", MatchNone, ""},
+	}
+	for _, e := range expected {
+		if match, _, rule := store.FindMatch(lists, e.domain); match != e.match || rule != e.rule {
+			t.Errorf("Expected match %d by '%s' for '%s' but got %d by '%s'", e.match, e.rule, e.domain, match, rule)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"net"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -170,3 +171,20 @@ func IsRecordEmpty(record interface{}) bool {
 
 	return true
 }
+
+// get the addresses from the A and AAAA records in the answer section
+func GetAnswerAddresses(response *dns.Msg) []net.IP {
+	if response == nil || len(response.Answer) < 1 {
+		return []net.IP{}
+	}
+
+	addresses := make([]net.IP, 0, len(response.Answer))
+	for _, answer := range response.Answer {
+		if aRecord, ok := answer.(*dns.A); ok && aRecord != nil && aRecord.A != nil {
+			addresses = append(addresses, aRecord.A)
+		} else if aaaaRecord, ok := answer.(*dns.AAAA); ok && aaaaRecord != nil && aaaaRecord.AAAA != nil {
+			addresses = append(addresses, aaaaRecord.AAAA)
+		}
+	}
+	return addresses
+}
@@ -2,11 +2,14 @@ package util
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/couchbase/go-slab"
 )
@@ -63,3 +66,60 @@ func LineCount(inputfile string) (uint, error) {
 		}
 	}
 }
+
+// hash of the contents of a file as a hex string
+func FileHash(inputfile string) (string, error) {
+	r, err := os.Open(inputfile)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// the suffix of the lock file that marks the entry with the same name as owned
+const LockSuffix = ".lock"
+
+// removes everything in the directory that is no longer owned by a running process, an entry is owned for as long as
+// the lock file next to it is held and entries without a lock file have no owner
+func ClearUnlocked(inputdir string) int {
+	dir, err := ioutil.ReadDir(inputdir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, d := range dir {
+		name := d.Name()
+		entry := path.Join(inputdir, name)
+		if strings.HasSuffix(name, LockSuffix) {
+			// a lock without its entry is removed when it is no longer held
+			if _, err := os.Stat(strings.TrimSuffix(entry, LockSuffix)); os.IsNotExist(err) {
+				if lock, err := TryLock(entry); err == nil {
+					lock.Remove()
+				}
+			}
+			continue
+		}
+		if strings.Contains(name, LockSuffix+".tmp") {
+			// a lock that was never put in place, given time to be renamed by the process that is creating it
+			if time.Since(d.ModTime()) > time.Minute {
+				_ = os.Remove(entry)
+			}
+			continue
+		}
+		lock, err := TryLock(entry + LockSuffix)
+		if err != nil {
+			continue
+		}
+		if os.RemoveAll(entry) == nil {
+			removed++
+		}
+		lock.Remove()
+	}
+	return removed
+}
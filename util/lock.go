@@ -0,0 +1,72 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// returned when the lock is held by another owner
+var ErrLocked = errors.New("lock is held by another process")
+
+// an exclusive lock on a file, the operating system releases the lock when the process that holds it exits so
+// a lock file that can be locked was left behind by a process that is gone
+type FileLock struct {
+	path string
+	file *os.File
+}
+
+// lock an existing (or new) file without waiting for the owner to release it
+func TryLock(lockPath string) (*FileLock, error) {
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &FileLock{path: lockPath, file: file}, nil
+}
+
+// create a new lock file that is already locked when it appears under the given path so that nothing can take
+// it between the file being created and being locked
+func CreateLock(lockPath string) (*FileLock, error) {
+	file, err := ioutil.TempFile(path.Dir(lockPath), path.Base(lockPath)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(file); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+	if err = os.Rename(file.Name(), lockPath); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return &FileLock{path: lockPath, file: file}, nil
+}
+
+// release the lock and keep the file
+func (lock *FileLock) Release() {
+	if lock == nil || lock.file == nil {
+		return
+	}
+	unlockFile(lock.file)
+	_ = lock.file.Close()
+	lock.file = nil
+}
+
+// remove the file and then release the lock
+func (lock *FileLock) Remove() {
+	if lock == nil || lock.file == nil {
+		return
+	}
+	_ = os.Remove(lock.path)
+	lock.Release()
+}
@@ -0,0 +1,38 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package util
+
+import (
+	"os"
+	"sync"
+)
+
+// without flock the files are only locked against the other locks of this process, a lock held by another process
+// is not seen
+var (
+	heldMux sync.Mutex
+	held    = make(map[*os.File]os.FileInfo)
+)
+
+func lockFile(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	heldMux.Lock()
+	defer heldMux.Unlock()
+	for _, other := range held {
+		if os.SameFile(info, other) {
+			return ErrLocked
+		}
+	}
+	held[file] = info
+	return nil
+}
+
+func unlockFile(file *os.File) {
+	heldMux.Lock()
+	delete(held, file)
+	heldMux.Unlock()
+}
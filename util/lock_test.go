@@ -0,0 +1,80 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFileLock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gudgeon-lock-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockPath := path.Join(tmpDir, "owned"+LockSuffix)
+	lock, err := CreateLock(lockPath)
+	if err != nil {
+		t.Fatalf("Could not create lock: %s", err)
+	}
+
+	// a held lock can't be taken again
+	if _, err := TryLock(lockPath); err != ErrLocked {
+		t.Errorf("Expected lock to be held but got: %v", err)
+	}
+
+	// and can be taken once it is released
+	lock.Release()
+	again, err := TryLock(lockPath)
+	if err != nil {
+		t.Errorf("Expected released lock to be taken but got: %s", err)
+	}
+	again.Remove()
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("Expected lock file to be removed")
+	}
+}
+
+func TestClearUnlocked(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gudgeon-sessions-")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// a session that is still owned, one that was left by an owner that is gone, and one without a lock
+	owned := path.Join(tmpDir, ".owned")
+	lock, err := CreateLock(owned + LockSuffix)
+	if err != nil {
+		t.Fatalf("Could not create lock: %s", err)
+	}
+	defer lock.Remove()
+	abandoned := path.Join(tmpDir, ".abandoned")
+	abandonedLock, err := CreateLock(abandoned + LockSuffix)
+	if err != nil {
+		t.Fatalf("Could not create lock: %s", err)
+	}
+	abandonedLock.Release()
+	unlocked := path.Join(tmpDir, ".unlocked")
+	for _, dir := range []string{owned, abandoned, unlocked} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatalf("Could not create session dir: %s", err)
+		}
+	}
+
+	if removed := ClearUnlocked(tmpDir); removed != 2 {
+		t.Errorf("Expected 2 sessions to be removed but %d were", removed)
+	}
+	for _, removed := range []string{abandoned, abandoned + LockSuffix, unlocked, unlocked + LockSuffix} {
+		if _, err := os.Stat(removed); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", removed)
+		}
+	}
+	for _, kept := range []string{owned, owned + LockSuffix} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("Expected %s to be kept: %s", kept, err)
+		}
+	}
+}
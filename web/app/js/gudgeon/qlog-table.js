@@ -49,6 +49,9 @@ export class QueryLog extends React.Component {
             clientString = clientString + rowData.ClientName + " | ";
           }
           clientString = clientString + rowData.Address;
+          if ( rowData.ClientMac ) {
+            clientString = clientString + " | " + rowData.ClientMac;
+          }
           return (
             <div>{ clientString }</div>
           );
@@ -77,7 +80,11 @@ export class QueryLog extends React.Component {
             }
           }
 
-          if ( rowData.Blocked ) {
+          if ( rowData.RateLimit ) {
+            return (
+              <div style={{ color: "red" }}><ErrorCircleOIcon alt="rate limited" /> RATE { rowData.RateLimit.toUpperCase() }</div>
+            );
+          } else if ( rowData.Blocked ) {
             return (
               <div style={{ color: "red" }}><ErrorCircleOIcon alt="blocked" /> BLOCKED</div>
             );
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GeertJohan/go.rice"
@@ -17,17 +18,24 @@ import (
 	"github.com/chrisruffalo/gudgeon/config"
 	"github.com/chrisruffalo/gudgeon/engine"
 	"github.com/chrisruffalo/gudgeon/resolver"
+	"github.com/chrisruffalo/gudgeon/rule"
 )
 
 type web struct {
+	// guards the configuration and the server, which change when the configuration is updated
+	mux    sync.RWMutex
 	conf   *config.GudgeonConfig
 	server *http.Server
+	router *gin.Engine
+	// serializes updates and stopping, which shut down servers without holding the lock
+	updateMux sync.Mutex
 
 	engine engine.Engine
 }
 
 type Web interface {
 	Serve(conf *config.GudgeonConfig, engine engine.Engine) error
+	UpdateConfig(conf *config.GudgeonConfig) error
 	Stop()
 }
 
@@ -210,6 +218,10 @@ func (web *web) GetQueryLogInfo(c *gin.Context) {
 		query.ClientName = clientName
 	}
 
+	if clientMac := c.Query("clientMac"); len(clientMac) > 0 {
+		query.ClientMac = clientMac
+	}
+
 	if responseText := c.Query("responseText"); len(responseText) > 0 {
 		query.ResponseText = responseText
 	}
@@ -218,6 +230,10 @@ func (web *web) GetQueryLogInfo(c *gin.Context) {
 		query.MatchRule = matchRule
 	}
 
+	if rateLimit := c.Query("rateLimit"); len(rateLimit) > 0 {
+		query.RateLimit = strings.ToLower(rateLimit)
+	}
+
 	// look for and convert time (seconds since unix epoch) to local date
 	if after := c.Query("after"); len(after) > 0 {
 		iAfter, err := strconv.ParseInt(after, 10, 64)
@@ -281,7 +297,7 @@ func (web *web) GetTestComponents(c *gin.Context) {
 }
 
 func (web *web) GetTop(c *gin.Context) {
-	if web.engine.Metrics() == nil || !(*web.conf.Metrics.Detailed) {
+	if web.engine.Metrics() == nil || !(*web.config().Metrics.Detailed) {
 		c.String(http.StatusNotFound, "Detailed Metrics not enabled)")
 		return
 	}
@@ -347,8 +363,9 @@ func (web *web) GetTestResult(c *gin.Context) {
 	question.Question = []dns.Question{{Name: domain, Qclass: dns.ClassINET, Qtype: dns.StringToType[qtype]}}
 
 	var (
-		response *dns.Msg
-		result   *resolver.ResolutionResult
+		response    *dns.Msg
+		result      *resolver.ResolutionResult
+		explanation *rule.Explanation
 	)
 
 	rCon := resolver.DefaultRequestContext()
@@ -356,8 +373,10 @@ func (web *web) GetTestResult(c *gin.Context) {
 
 	if consumer := c.Query("consumer"); len(consumer) > 0 {
 		response, _, result = web.engine.HandleWithConsumerName(consumer, rCon, question)
+		explanation = web.engine.ExplainWithConsumerName(consumer, domain)
 	} else if groups := c.Query("groups"); len(groups) > 0 {
 		response, _, result = web.engine.HandleWithGroups([]string{groups}, rCon, question)
+		explanation = web.engine.ExplainWithGroups([]string{groups}, domain)
 	} else if resolvers := c.Query("resolvers"); len(resolvers) > 0 {
 		response, _, result = web.engine.HandleWithResolvers([]string{resolvers}, rCon, question)
 	}
@@ -371,6 +390,150 @@ func (web *web) GetTestResult(c *gin.Context) {
 		"response": response,
 		"result":   result,
 		"text":     responseText,
+		"explain":  explanation,
+	})
+}
+
+// list cache entries, optionally limited to a partition and/or a domain (and subdomains)
+func (web *web) GetCacheEntries(c *gin.Context) {
+	entries := web.engine.CacheEntries(c.Query("partition"), c.Query("domain"))
+	total := len(entries)
+
+	// allow limit setting
+	if limitQuery := c.Query("limit"); len(limitQuery) > 0 {
+		iLimit, err := strconv.Atoi(limitQuery)
+		if err == nil && iLimit >= 0 && iLimit < len(entries) {
+			entries = entries[:iLimit]
+		}
+	}
+
+	c.JSON(http.StatusOK, &gin.H{
+		"total": total,
+		"items": entries,
+	})
+}
+
+// remove the domain and all subdomains from the cache, from all partitions unless a partition is given
+func (web *web) FlushCacheDomain(c *gin.Context) {
+	domain := c.Params.ByName("domain")
+	if len(strings.Trim(domain, ". ")) < 1 {
+		c.String(http.StatusBadRequest, "Domain must be provided")
+		return
+	}
+
+	c.JSON(http.StatusOK, &gin.H{
+		"flushed": web.engine.CacheFlushDomain(c.Query("partition"), domain),
+	})
+}
+
+// remove all entries from the given partition
+func (web *web) FlushCachePartition(c *gin.Context) {
+	partition := c.Params.ByName("partition")
+	if len(partition) < 1 {
+		c.String(http.StatusBadRequest, "Partition must be provided")
+		return
+	}
+
+	c.JSON(http.StatusOK, &gin.H{
+		"flushed": web.engine.CacheFlushPartition(partition),
+	})
+}
+
+// list the temporary overrides that have not expired
+func (web *web) GetOverrides(c *gin.Context) {
+	overrides := web.engine.Overrides()
+	c.JSON(http.StatusOK, &gin.H{
+		"total": len(overrides),
+		"items": overrides,
+	})
+}
+
+// pause or force blocking for a consumer, group, or everything for the given number of minutes
+func (web *web) SetOverride(c *gin.Context) {
+	minutes, err := strconv.Atoi(c.DefaultQuery("minutes", "0"))
+	if err != nil || minutes < 1 {
+		c.String(http.StatusBadRequest, "Minutes must be a number greater than zero")
+		return
+	}
+
+	override, err := web.engine.SetOverride(c.Params.ByName("scope"), c.Query("name"), c.DefaultQuery("action", engine.OverridePause), time.Duration(minutes)*time.Minute)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, override)
+}
+
+// remove an override before it expires
+func (web *web) ClearOverride(c *gin.Context) {
+	c.JSON(http.StatusOK, &gin.H{
+		"cleared": web.engine.ClearOverride(c.Params.ByName("scope"), c.Query("name")),
+	})
+}
+
+// list the clients that are banned for staying over the rate limit
+func (web *web) GetBans(c *gin.Context) {
+	bans := web.engine.Bans()
+	c.JSON(http.StatusOK, &gin.H{
+		"total": len(bans),
+		"items": bans,
+	})
+}
+
+// remove the ban for a client (an address or a consumer) before it expires
+func (web *web) Unban(c *gin.Context) {
+	client := c.Query("client")
+	if "" == client {
+		c.String(http.StatusBadRequest, "Client must be provided")
+		return
+	}
+
+	c.JSON(http.StatusOK, &gin.H{
+		"unbanned": web.engine.Unban(client),
+	})
+}
+
+// list the custom rules of a group, or of every group when the group is "all"
+func (web *web) GetCustomRules(c *gin.Context) {
+	group := c.Params.ByName("group")
+	if "all" == group {
+		group = ""
+	}
+	rules := web.engine.CustomRules(group)
+	c.JSON(http.StatusOK, &gin.H{
+		"total": len(rules),
+		"items": rules,
+	})
+}
+
+// add a rule to the custom allow or block list of a group, rules with minutes set expire after that many minutes
+func (web *web) AddCustomRule(c *gin.Context) {
+	minutes, err := strconv.Atoi(c.DefaultQuery("minutes", "0"))
+	if err != nil || minutes < 0 {
+		c.String(http.StatusBadRequest, "Minutes must be a number that is zero or greater")
+		return
+	}
+
+	rule, err := web.engine.AddCustomRule(c.Params.ByName("group"), c.DefaultQuery("type", "block"), c.Query("rule"), time.Duration(minutes)*time.Minute)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// remove a rule from the custom allow or block list of a group
+func (web *web) RemoveCustomRule(c *gin.Context) {
+	removed, err := web.engine.RemoveCustomRule(c.Params.ByName("group"), c.DefaultQuery("type", "block"), c.Query("rule"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, &gin.H{
+		"removed": removed,
 	})
 }
 
@@ -402,33 +565,122 @@ func (web *web) Serve(conf *config.GudgeonConfig, engine engine.Engine) error {
 		api.GET("/test/query", web.GetTestResult)
 		// attach query log
 		api.GET("/query/list", web.GetQueryLogInfo)
-	}
+		// cache inspection and flushing
+		api.GET("/cache/list", web.GetCacheEntries)
+		api.DELETE("/cache/domain/:domain", web.FlushCacheDomain)
+		api.DELETE("/cache/partition/:partition", web.FlushCachePartition)
+		// temporary overrides
+		api.GET("/override/list", web.GetOverrides)
+		api.POST("/override/:scope", web.SetOverride)
+		api.DELETE("/override/:scope", web.ClearOverride)
+		// rate limit bans
+		api.GET("/ban/list", web.GetBans)
+		api.DELETE("/ban", web.Unban)
+		api.GET("/custom/:group", web.GetCustomRules)
+		api.POST("/custom/:group", web.AddCustomRule)
+		api.DELETE("/custom/:group", web.RemoveCustomRule)
+	}
+
+	web.mux.Lock()
+	defer web.mux.Unlock()
+	web.router = router
+	web.start(conf.Web)
+
+	return nil
+}
 
-	// go serve
-	webConf := conf.Web
+// the configuration the web ui was last served or updated with
+func (web *web) config() *config.GudgeonConfig {
+	web.mux.RLock()
+	defer web.mux.RUnlock()
+	return web.conf
+}
+
+// serve the router on the address of the configuration, must be called while holding the lock
+func (web *web) start(webConf *config.GudgeonWeb) {
 	address := fmt.Sprintf("%s:%d", webConf.Address, webConf.Port)
-	web.server = &http.Server{
+	server := &http.Server{
 		Addr:    address,
-		Handler: router,
+		Handler: web.router,
 	}
+	web.server = server
 	go func() {
 		// service connections
-		if err := web.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Errorf("Starting server: %s", err)
 		}
 	}()
 
 	log.Infof("Started web ui on %s", address)
+}
 
-	return nil
+// take the server so that it can be shut down after the lock is released, handlers that are still running need
+// the lock to read the configuration. must be called while holding the lock.
+func (web *web) detach() *http.Server {
+	server := web.server
+	web.server = nil
+	return server
 }
 
-func (web *web) Stop() {
+// shut down a server that was detached
+func shutdown(server *http.Server) {
+	if server == nil {
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := web.server.Shutdown(ctx); err != nil {
+	if err := server.Shutdown(ctx); err != nil {
 		log.Errorf("Server Shutdown: %s", err)
-		return
 	}
-	ctx.Done()
+}
+
+// use the new configuration and move the web ui when its address or port changed, the web ui stops when it is
+// disabled and starts again when it is enabled
+func (web *web) UpdateConfig(conf *config.GudgeonConfig) error {
+	if conf == nil || conf.Web == nil {
+		return fmt.Errorf("No web configuration to update from")
+	}
+
+	// only one update or stop at a time
+	web.updateMux.Lock()
+	defer web.updateMux.Unlock()
+
+	web.mux.Lock()
+	web.conf = conf
+	if web.router == nil {
+		web.mux.Unlock()
+		return fmt.Errorf("Web ui has not been served")
+	}
+	address := fmt.Sprintf("%s:%d", conf.Web.Address, conf.Web.Port)
+	if conf.Web.Enabled && web.server != nil && web.server.Addr == address {
+		web.mux.Unlock()
+		return nil
+	}
+	old := web.detach()
+	web.mux.Unlock()
+
+	// the old server has to let go of its address before the new server can use it
+	if old != nil {
+		log.Infof("Stopping web ui on %s", old.Addr)
+		shutdown(old)
+	}
+
+	if conf.Web.Enabled {
+		web.mux.Lock()
+		web.start(conf.Web)
+		web.mux.Unlock()
+	}
+
+	return nil
+}
+
+func (web *web) Stop() {
+	web.updateMux.Lock()
+	defer web.updateMux.Unlock()
+
+	web.mux.Lock()
+	server := web.detach()
+	web.mux.Unlock()
+
+	shutdown(server)
 }
@@ -103,13 +103,14 @@ func (web *web) ServeStatic(fs *rice.Box) gin.HandlerFunc {
 				}
 
 				// hash
+				conf := web.config()
 				options := make(map[string]interface{}, 0)
 				options["version"] = version.Info()
-				options["query_log"] = web.conf.QueryLog.Enabled
-				options["query_log_persist"] = web.conf.QueryLog.Persist
-				options["metrics"] = web.conf.Metrics.Enabled
-				options["metrics_persist"] = web.conf.Metrics.Persist
-				options["metrics_detailed"] = web.conf.Metrics.Detailed
+				options["query_log"] = conf.QueryLog.Enabled
+				options["query_log_persist"] = conf.QueryLog.Persist
+				options["metrics"] = conf.Metrics.Enabled
+				options["metrics_persist"] = conf.Metrics.Persist
+				options["metrics_detailed"] = conf.Metrics.Detailed
 
 				// execute and write template
 				c.Status(http.StatusOK)